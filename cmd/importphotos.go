@@ -0,0 +1,71 @@
+/*
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/ingest"
+	"github.com/spf13/cobra"
+)
+
+var importAlbum bool
+var importAlbumName string
+
+// importPhotosCmd represents the import command
+var importPhotosCmd = &cobra.Command{
+	Use:   "import <zip|dir>",
+	Short: "Import photos from a zip archive or directory",
+	Long: `Imports all jpegs in a zip archive or a directory (recursively). Photos that already
+exist are reported as duplicates. Optionally adds the photos to an album named after the archive`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config.InitConfig()
+		db, err := dao.NewPGDB()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer db.Close()
+		if err = dao.CreateImageDirs(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts := ingest.ArchiveOptions{CreateAlbum: importAlbum || importAlbumName != "", AlbumName: importAlbumName}
+		counts := map[string]int{}
+		_, err = ingest.New(db).ImportPath(args[0], opts, func(r *ingest.Result) {
+			counts[r.Status]++
+			if r.Error != "" {
+				fmt.Printf("%-9s %s: %s\n", r.Status, r.Name, r.Error)
+			} else {
+				fmt.Printf("%-9s %s\n", r.Status, r.Name)
+			}
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Printf("added: %d duplicate: %d failed: %d\n",
+			counts[ingest.StatusAdded], counts[ingest.StatusDuplicate], counts[ingest.StatusFailed])
+	},
+}
+
+func init() {
+	photoCmd.AddCommand(importPhotosCmd)
+
+	importPhotosCmd.Flags().BoolVarP(&importAlbum, "album", "a", false, "Add photos to an album named after the archive")
+	importPhotosCmd.Flags().StringVarP(&importAlbumName, "name", "n", "", "Add photos to an album with this name")
+}
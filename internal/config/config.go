@@ -21,6 +21,24 @@ const (
 
 const CameraDir = "camera"
 
+// Policies for photos whose Drive file has been removed or modified
+const (
+	DrivePolicyKeep     = "keep"
+	DrivePolicyTrash    = "trash"
+	DrivePolicyReimport = "reimport"
+)
+
+// Drivers for sending email
+const (
+	MailDriverGmail = "gmail"
+	MailDriverSmtp  = "smtp"
+	MailDriverFile  = "file"
+	MailDriverLog   = "log"
+)
+
+// DefaultMailFrom is the sender of outgoing email if mail.from is not configured
+const DefaultMailFrom = "Mellowtech Photos <msvens@gmail.com>"
+
 var photoTypeDirNames = map[PhotoType]string{
 	Original:  "img",
 	Thumb:     "thumb",
@@ -113,6 +131,28 @@ func DbUser() string {
 	return viper.GetString("db.user")
 }
 
+// DriveOnModified returns the policy for photos whose Drive file has been replaced: keep or reimport
+func DriveOnModified() string {
+	return drivePolicy("drive.onModified")
+}
+
+// DriveOnRemoved returns the policy for photos whose Drive file has been removed: keep or trash
+func DriveOnRemoved() string {
+	return drivePolicy("drive.onRemoved")
+}
+
+// DriveSchedule returns the interval or cron expression for automatic Drive syncs. Empty if disabled
+func DriveSchedule() string {
+	return viper.GetString("drive.schedule")
+}
+
+func drivePolicy(key string) string {
+	if p := viper.GetString(key); p != "" {
+		return p
+	}
+	return DrivePolicyKeep
+}
+
 func GoogleClientId() string {
 	return viper.GetString("google.clientId")
 }
@@ -125,6 +165,58 @@ func GoogleClientSecret() string {
 	return viper.GetString("google.ClientSecret")
 }
 
+func InboxAlbum() string {
+	return viper.GetString("inbox.album")
+}
+
+func InboxDir() string {
+	return viper.GetString("inbox.dir")
+}
+
+// MailDriver returns how email is sent, gmail if not configured
+func MailDriver() string {
+	if d := viper.GetString("mail.driver"); d != "" {
+		return d
+	}
+	return MailDriverGmail
+}
+
+func MailDir() string {
+	return viper.GetString("mail.dir")
+}
+
+// MailFrom returns the sender of outgoing email, the gmail account of the service if not configured
+func MailFrom() string {
+	if f := viper.GetString("mail.from"); f != "" {
+		return f
+	}
+	return DefaultMailFrom
+}
+
+func MailSmtpHost() string {
+	return viper.GetString("mail.smtpHost")
+}
+
+func MailSmtpPassword() string {
+	return viper.GetString("mail.smtpPassword")
+}
+
+func MailSmtpPort() int {
+	return viper.GetInt("mail.smtpPort")
+}
+
+func MailSmtpUser() string {
+	return viper.GetString("mail.smtpUser")
+}
+
+// ReactionKinds returns the kinds of reactions guests can add to photos, only like if not configured
+func ReactionKinds() []string {
+	if kinds := viper.GetStringSlice("reactions.kinds"); len(kinds) > 0 {
+		return kinds
+	}
+	return []string{"like"}
+}
+
 func ServerPort() int {
 	return viper.GetInt("server.port")
 }
@@ -137,6 +229,12 @@ func ServerAddr() string {
     return fmt.Sprintf("%s:%d",ServerHost(),ServerPort())
 }
 
+// ServerTrustedProxies returns the addresses or CIDR ranges of the reverse proxies that are
+// trusted to set X-Forwarded-For
+func ServerTrustedProxies() []string {
+	return viper.GetStringSlice("server.trustedProxies")
+}
+
 func VerifyUrl() string {
 	return viper.GetString("server.verifyUrl")
 }
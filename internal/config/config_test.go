@@ -28,6 +28,9 @@ func TestConfigFile(t *testing.T) {
 	if ServerPort() != 8050 {
 		t.Errorf("expected 8050 got %v", ServerPort())
 	}
+	if proxies := ServerTrustedProxies(); len(proxies) != 2 || proxies[0] != "127.0.0.1" || proxies[1] != "10.0.0.0/8" {
+		t.Errorf("expected [127.0.0.1 10.0.0.0/8] got %v", proxies)
+	}
 	if ServerPrefix() != "/api" {
 		t.Errorf("expected /api got %v", ServerPrefix())
 	}
@@ -69,6 +72,49 @@ func TestConfigFile(t *testing.T) {
 	if ServicePassword() != "password" {
 		t.Errorf("expected password got %v", ServicePassword())
 	}
+	//inbox config:
+	if InboxDir() != ".server/inbox" {
+		t.Errorf("expected .server/inbox got %v", InboxDir())
+	}
+	if InboxAlbum() != "inbox" {
+		t.Errorf("expected inbox got %v", InboxAlbum())
+	}
+	//drive config:
+	if DriveOnRemoved() != DrivePolicyTrash {
+		t.Errorf("expected trash got %v", DriveOnRemoved())
+	}
+	if DriveOnModified() != DrivePolicyReimport {
+		t.Errorf("expected reimport got %v", DriveOnModified())
+	}
+	if DriveSchedule() != "1h" {
+		t.Errorf("expected 1h got %v", DriveSchedule())
+	}
+	//reactions config:
+	if kinds := ReactionKinds(); len(kinds) != 4 || kinds[0] != "like" || kinds[3] != "fire" {
+		t.Errorf("expected [like heart wow fire] got %v", kinds)
+	}
+	//mail config:
+	if MailDriver() != MailDriverFile {
+		t.Errorf("expected file got %v", MailDriver())
+	}
+	if MailFrom() != "Mellowtech Photos <photos@example.com>" {
+		t.Errorf("expected Mellowtech Photos <photos@example.com> got %v", MailFrom())
+	}
+	if MailDir() != ".server/mail" {
+		t.Errorf("expected .server/mail got %v", MailDir())
+	}
+	if MailSmtpHost() != "localhost" {
+		t.Errorf("expected localhost got %v", MailSmtpHost())
+	}
+	if MailSmtpPort() != 587 {
+		t.Errorf("expected 587 got %v", MailSmtpPort())
+	}
+	if MailSmtpUser() != "user" {
+		t.Errorf("expected user got %v", MailSmtpUser())
+	}
+	if MailSmtpPassword() != "password" {
+		t.Errorf("expected password got %v", MailSmtpPassword())
+	}
 	//google config:
 	if GoogleClientId() != "clientId" {
 		t.Errorf("expected clientId got %v", GoogleClientId())
@@ -183,8 +183,12 @@ func (dao *AlbumPG) Photos(id uuid.UUID, private bool) ([]*Photo, error) {
 func (dao *AlbumPG) Delete(id uuid.UUID) error {
 
 	if _, err := dao.db.Exec("DELETE FROM album WHERE id = $1", id); err == nil {
-		_, err1 := dao.db.Exec("DELETE FROM albumphotos WHERE albumId = $1", id)
-		return err1
+		for _, table := range []string{"albumphotos", "proofselection", "proofsubmission"} {
+			if _, err = dao.db.Exec("DELETE FROM "+table+" WHERE albumId = $1", id); err != nil {
+				return err
+			}
+		}
+		return nil
 	} else {
 		return err
 	}
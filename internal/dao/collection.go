@@ -0,0 +1,143 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"time"
+)
+
+type CollectionPG struct {
+	db         *sqlx.DB
+	insertStmt string
+	updateStmt string
+}
+
+func NewCollectionPG(db *sqlx.DB) *CollectionPG {
+	fields := getStructFields(&Collection{})
+	return &CollectionPG{db, buildInsertNamed("collection", fields), buildUpdateNamed2("collection", fields, "id", "id")}
+}
+
+func (dao *CollectionPG) Add(c *Collection) error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("white space/empty names not allowed")
+	}
+	if c.Id == uuid.Nil {
+		c.Id = uuid.New()
+	}
+	_, err := dao.db.NamedExec(dao.insertStmt, c)
+	return err
+}
+
+// AddPhotos adds photoIds to the collection with id. Photos that are already in the collection
+// are ignored. Returns the number of added photos
+func (dao *CollectionPG) AddPhotos(id uuid.UUID, photoIds []uuid.UUID) (int, error) {
+	if len(photoIds) == 0 {
+		return 0, nil
+	}
+	query, args, err := sqlx.In("SELECT COUNT(*) FROM img WHERE id IN (?)", photoIds)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	if err = dao.db.QueryRowx(dao.db.Rebind(query), args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count != len(photoIds) {
+		return 0, fmt.Errorf("Missing photos")
+	}
+	const stmt = "INSERT INTO collectionphotos (collectionId, photoId, added) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	var numRows int64
+	now := time.Now()
+	for _, pid := range photoIds {
+		if res, err := dao.db.Exec(stmt, id, pid, now); err != nil {
+			return int(numRows), err
+		} else {
+			r, _ := res.RowsAffected()
+			numRows += r
+		}
+	}
+	return int(numRows), nil
+}
+
+func (dao *CollectionPG) Delete(id uuid.UUID) error {
+	if _, err := dao.db.Exec("DELETE FROM collectionphotos WHERE collectionId = $1", id); err != nil {
+		return err
+	}
+	_, err := dao.db.Exec("DELETE FROM collection WHERE id = $1", id)
+	return err
+}
+
+func (dao *CollectionPG) DeleteByGuest(guestId uuid.UUID) error {
+	const stmt = "DELETE FROM collectionphotos WHERE collectionId IN (SELECT id FROM collection WHERE guestId = $1)"
+	if _, err := dao.db.Exec(stmt, guestId); err != nil {
+		return err
+	}
+	_, err := dao.db.Exec("DELETE FROM collection WHERE guestId = $1", guestId)
+	return err
+}
+
+func (dao *CollectionPG) DeletePhotos(id uuid.UUID, photoIds []uuid.UUID) (int, error) {
+	if len(photoIds) == 0 {
+		return 0, nil
+	}
+	query, args, err := sqlx.In("DELETE FROM collectionphotos WHERE collectionId = ? AND photoId IN (?)", id, photoIds)
+	if err != nil {
+		return 0, err
+	}
+	res, err := dao.db.Exec(dao.db.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+func (dao *CollectionPG) Get(id uuid.UUID) (*Collection, error) {
+	ret := Collection{}
+	if err := dao.db.Get(&ret, "SELECT * FROM collection WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *CollectionPG) ListByGuest(guestId uuid.UUID) ([]*Collection, error) {
+	ret := []*Collection{}
+	err := dao.db.Select(&ret, "SELECT * FROM collection WHERE guestId = $1 ORDER BY name", guestId)
+	return ret, err
+}
+
+// ListShared returns the collections that guests have shared with the owner, latest first
+func (dao *CollectionPG) ListShared() ([]*Collection, error) {
+	ret := []*Collection{}
+	err := dao.db.Select(&ret, "SELECT * FROM collection WHERE shared = true ORDER BY created DESC")
+	return ret, err
+}
+
+// PhotoIds returns the ids of the photos in the collection in the order they were added
+func (dao *CollectionPG) PhotoIds(id uuid.UUID) ([]uuid.UUID, error) {
+	ret := []uuid.UUID{}
+	const stmt = "SELECT photoId FROM collectionphotos WHERE collectionId = $1 ORDER BY added, photoId"
+	err := dao.db.Select(&ret, stmt, id)
+	return ret, err
+}
+
+// Photos returns the photos in the collection in the order they were added
+func (dao *CollectionPG) Photos(id uuid.UUID) ([]*Photo, error) {
+	ret := []*Photo{}
+	const stmt = `SELECT img.* FROM img JOIN collectionphotos cp ON img.id = cp.photoId
+	WHERE cp.collectionId = $1 ORDER BY cp.added, img.id`
+	err := dao.db.Select(&ret, stmt, id)
+	return ret, err
+}
+
+func (dao *CollectionPG) Update(c *Collection) (*Collection, error) {
+	if strings.TrimSpace(c.Name) == "" {
+		return nil, fmt.Errorf("white space/empty names not allowed")
+	}
+	if _, err := dao.db.NamedExec(dao.updateStmt, c); err != nil {
+		return nil, err
+	}
+	return dao.Get(c.Id)
+}
@@ -0,0 +1,63 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCollections(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+	if err := loadPhotoTestData(); err != nil {
+		t.Fatalf("Could not load img test data: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := pgdb.Photo.Add(&testPhotos[i], testExifs[i].Data); err != nil {
+			t.Fatalf("Could not create img: %v", err)
+		}
+	}
+	guestId := uuid.New()
+	c := Collection{GuestId: guestId, Name: "prints", Created: time.Now()}
+	if err := pgdb.Collection.Add(&c); err != nil {
+		t.Fatalf("could not add collection: %s", err.Error())
+	}
+	if err := pgdb.Collection.Add(&Collection{GuestId: guestId, Name: " "}); err == nil {
+		t.Errorf("expected error when adding a collection with an empty name")
+	}
+	photoIds := []uuid.UUID{testPhotos[0].Id, testPhotos[1].Id}
+	if cnt, err := pgdb.Collection.AddPhotos(c.Id, photoIds); err != nil || cnt != 2 {
+		t.Errorf("expected 2 added photos got %d %v", cnt, err)
+	}
+	if cnt, err := pgdb.Collection.AddPhotos(c.Id, photoIds[:1]); err != nil || cnt != 0 {
+		t.Errorf("expected no added photos got %d %v", cnt, err)
+	}
+	if _, err := pgdb.Collection.AddPhotos(c.Id, []uuid.UUID{uuid.New()}); err == nil {
+		t.Errorf("expected error when adding a non-existent photo")
+	}
+	if photos, err := pgdb.Collection.Photos(c.Id); err != nil || len(photos) != 2 {
+		t.Errorf("expected 2 photos got %v %v", photos, err)
+	}
+	if shared, err := pgdb.Collection.ListShared(); err != nil || len(shared) != 0 {
+		t.Errorf("expected no shared collections got %v %v", shared, err)
+	}
+	c.Shared = true
+	if updated, err := pgdb.Collection.Update(&c); err != nil || !updated.Shared {
+		t.Errorf("could not share collection %v %v", updated, err)
+	}
+	if shared, err := pgdb.Collection.ListShared(); err != nil || len(shared) != 1 || shared[0].GuestId != guestId {
+		t.Errorf("expected 1 shared collection got %v %v", shared, err)
+	}
+	if cnt, err := pgdb.Collection.DeletePhotos(c.Id, photoIds[:1]); err != nil || cnt != 1 {
+		t.Errorf("expected 1 deleted photo got %d %v", cnt, err)
+	}
+	if ids, err := pgdb.Collection.PhotoIds(c.Id); err != nil || len(ids) != 1 || ids[0] != photoIds[1] {
+		t.Errorf("expected 1 photo id got %v %v", ids, err)
+	}
+	if err := pgdb.Collection.DeleteByGuest(guestId); err != nil {
+		t.Errorf("could not delete collections: %s", err.Error())
+	}
+	if collections, err := pgdb.Collection.ListByGuest(guestId); err != nil || len(collections) != 0 {
+		t.Errorf("expected no collections got %v %v", collections, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
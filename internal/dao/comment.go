@@ -10,36 +10,59 @@ type CommentPG struct {
 	db                *sqlx.DB
 	commentFields     []string
 	insertCommentStmt string
+	updateCommentStmt string
 }
 
 func NewCommentPG(db *sqlx.DB) *CommentPG {
 	c := &Comment{}
 	fields := getStructFields(c)
 	stmt := buildInsertNamed("comment", fields, "id") + " RETURNING ID"
-	return &CommentPG{db, fields, stmt}
+	return &CommentPG{db, fields, stmt, buildUpdateNamed2("comment", fields, "id")}
 }
 
-func (dao *CommentPG) Add(guestId uuid.UUID, photoId uuid.UUID, body string) (*Comment, error) {
-	c := Comment{GuestId: guestId, PhotoId: photoId, Body: body, Time: time.Now()}
-	if rows, err := dao.db.NamedQuery(dao.insertCommentStmt, &c); err != nil {
+// Add adds c, setting its id and time
+func (dao *CommentPG) Add(c *Comment) (*Comment, error) {
+	c.Time = time.Now()
+	if rows, err := dao.db.NamedQuery(dao.insertCommentStmt, c); err != nil {
 		return nil, err
 	} else {
+		defer rows.Close()
 		rows.Next()
-		var id int
-		err = rows.Scan(&id)
-		c.Id = id
-		return &c, err
+		err = rows.Scan(&c.Id)
+		return c, err
 	}
 }
 
+// CountByGuest returns the number of comments by guestId in any of states, or in any state if none are given
+func (dao *CommentPG) CountByGuest(guestId uuid.UUID, states ...string) (int, error) {
+	var cnt int
+	if len(states) == 0 {
+		err := dao.db.Get(&cnt, "SELECT count(*) FROM comment WHERE guestId = $1", guestId)
+		return cnt, err
+	}
+	query, args, err := sqlx.In("SELECT count(*) FROM comment WHERE guestId = ? AND state IN (?)", guestId, states)
+	if err != nil {
+		return 0, err
+	}
+	err = dao.db.Get(&cnt, dao.db.Rebind(query), args...)
+	return cnt, err
+}
+
 func (dao *CommentPG) Get(id int) (*Comment, error) {
 	ret := Comment{}
-	err := dao.db.Get(&ret, "SELECT * FROM comment WHERE id = $1")
-	return &ret, err
+	if err := dao.db.Get(&ret, "SELECT * FROM comment WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
 }
 
+// Delete deletes the comment with id and all replies to it
 func (dao *CommentPG) Delete(id int) error {
-	_, err := dao.db.Exec("DELETE from comment WHERE id = $1", id)
+	const stmt = `WITH RECURSIVE thread AS (
+		SELECT id FROM comment WHERE id = $1
+		UNION SELECT comment.id FROM comment, thread WHERE comment.parentId = thread.id)
+	DELETE from comment WHERE id IN (SELECT id FROM thread)`
+	_, err := dao.db.Exec(stmt, id)
 	return err
 }
 
@@ -48,20 +71,49 @@ func (dao *CommentPG) DeleteByPhoto(photoId uuid.UUID) error {
 	return err
 }
 
+// deleteGuestCommentsStmt deletes all comments by a guest together with their replies
+const deleteGuestCommentsStmt = `WITH RECURSIVE thread AS (
+		SELECT id FROM comment WHERE guestId = $1 AND owner = false
+		UNION SELECT comment.id FROM comment, thread WHERE comment.parentId = thread.id)
+	DELETE from comment WHERE id IN (SELECT id FROM thread)`
+
+// DeleteByGuest deletes all comments by guestId together with their replies
 func (dao *CommentPG) DeleteByGuest(guestId uuid.UUID) error {
-	_, err := dao.db.Exec("DELETE from comment WHERE guestId = $1", guestId)
+	_, err := dao.db.Exec(deleteGuestCommentsStmt, guestId)
 	return err
 }
 
-func (dao *CommentPG) List() ([]*Comment, error) {
-	ret := []*Comment{}
-	err := dao.db.Select(&ret, "SELECT * FROM comment ORDER BY time DESC")
+// selectAuthored selects comments together with the name and email of the guest
+const selectAuthored = `SELECT comment.*, COALESCE(guest.name, '') AS name, COALESCE(guest.email, '') AS email
+	FROM comment LEFT JOIN guest ON guest.id = comment.guestId AND comment.owner = false`
+
+// List returns comments in any of states, or all comments if no states are given, latest first
+func (dao *CommentPG) List(states ...string) ([]*AuthoredComment, error) {
+	ret := []*AuthoredComment{}
+	if len(states) == 0 {
+		err := dao.db.Select(&ret, selectAuthored+" ORDER BY comment.time DESC")
+		return ret, err
+	}
+	query, args, err := sqlx.In(selectAuthored+" WHERE comment.state IN (?) ORDER BY comment.time DESC", states)
+	if err != nil {
+		return nil, err
+	}
+	err = dao.db.Select(&ret, dao.db.Rebind(query), args...)
 	return ret, err
 }
 
-func (dao *CommentPG) ListByPhoto(photoId uuid.UUID) ([]*Comment, error) {
-	ret := []*Comment{}
-	err := dao.db.Select(&ret, "SELECT * FROM comment WHERE photoid = $1 ORDER BY time DESC", photoId)
+// ListByPhoto returns the comments of photoId in any of states, or in any state if no states are given
+func (dao *CommentPG) ListByPhoto(photoId uuid.UUID, states ...string) ([]*AuthoredComment, error) {
+	ret := []*AuthoredComment{}
+	if len(states) == 0 {
+		err := dao.db.Select(&ret, selectAuthored+" WHERE comment.photoId = $1 ORDER BY comment.time DESC", photoId)
+		return ret, err
+	}
+	query, args, err := sqlx.In(selectAuthored+" WHERE comment.photoId = ? AND comment.state IN (?) ORDER BY comment.time DESC", photoId, states)
+	if err != nil {
+		return nil, err
+	}
+	err = dao.db.Select(&ret, dao.db.Rebind(query), args...)
 	return ret, err
 }
 
@@ -70,3 +122,10 @@ func (dao *CommentPG) ListByGuest(guestId uuid.UUID) ([]*Comment, error) {
 	err := dao.db.Select(&ret, "SELECT * FROM comment WHERE guestid = $1 ORDER BY time DESC", guestId)
 	return ret, err
 }
+
+func (dao *CommentPG) Update(c *Comment) (*Comment, error) {
+	if _, err := dao.db.NamedExec(dao.updateCommentStmt, c); err != nil {
+		return nil, err
+	}
+	return dao.Get(c.Id)
+}
@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCommentStates(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	guest, err := pgdb.Guest.Add("commenter", "commenter@example.com")
+	if err != nil {
+		t.Fatalf("could not add guest: %s", err.Error())
+	}
+	guestId, photoId := guest.Id, uuid.New()
+	approved, err := pgdb.Comment.Add(&Comment{GuestId: guestId, PhotoId: photoId, Body: "approved", State: CommentApproved})
+	if err != nil {
+		t.Fatalf("could not add comment: %s", err.Error())
+	}
+	pending, err := pgdb.Comment.Add(&Comment{GuestId: guestId, PhotoId: photoId, Body: "pending", State: CommentPending})
+	if err != nil {
+		t.Fatalf("could not add comment: %s", err.Error())
+	}
+	if c, err := pgdb.Comment.Get(pending.Id); err != nil || c.State != CommentPending {
+		t.Errorf("expected pending comment got %v %v", c, err)
+	}
+	if comments, err := pgdb.Comment.ListByPhoto(photoId, CommentApproved); err != nil || len(comments) != 1 || comments[0].Id != approved.Id {
+		t.Errorf("expected 1 approved comment got %v %v", comments, err)
+	}
+	if comments, err := pgdb.Comment.ListByPhoto(photoId); err != nil || len(comments) != 2 {
+		t.Errorf("expected 2 comments got %v %v", comments, err)
+	}
+
+	edited := time.Now().UTC().Truncate(time.Millisecond)
+	pending.State, pending.Body, pending.Edited = CommentRejected, "edited", edited
+	if c, err := pgdb.Comment.Update(pending); err != nil {
+		t.Errorf("could not update comment: %s", err.Error())
+	} else if c.State != CommentRejected || c.Body != "edited" || !c.Edited.Equal(edited) {
+		t.Errorf("comment not updated: %v", c)
+	}
+	if comments, err := pgdb.Comment.List(CommentPending); err != nil || len(comments) != 0 {
+		t.Errorf("expected no pending comments got %v %v", comments, err)
+	}
+	if cnt, err := pgdb.Comment.CountByGuest(guestId, CommentApproved); err != nil || cnt != 1 {
+		t.Errorf("expected 1 approved comment by guest got %d %v", cnt, err)
+	}
+	held, err := pgdb.Comment.Add(&Comment{GuestId: guestId, PhotoId: photoId, Body: "spam", State: CommentHeld, Flag: "links"})
+	if err != nil {
+		t.Fatalf("could not add comment: %s", err.Error())
+	}
+	if comments, err := pgdb.Comment.List(CommentHeld); err != nil || len(comments) != 1 || comments[0].Flag != held.Flag {
+		t.Errorf("expected 1 held comment got %v %v", comments, err)
+	}
+	if comments, err := pgdb.Comment.ListByPhoto(photoId, CommentHeld); err != nil || len(comments) != 1 ||
+		comments[0].Name != guest.Name || comments[0].Email != guest.Email {
+		t.Errorf("expected the comment with the guest name and email got %v %v", comments, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
+
+func TestCommentThread(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	photoId := uuid.New()
+	root, err := pgdb.Comment.Add(&Comment{GuestId: uuid.New(), PhotoId: photoId, Body: "root", State: CommentApproved})
+	if err != nil {
+		t.Fatalf("could not add comment: %s", err.Error())
+	}
+	reply, err := pgdb.Comment.Add(&Comment{PhotoId: photoId, Body: "reply", State: CommentApproved, ParentId: root.Id, Owner: true})
+	if err != nil {
+		t.Fatalf("could not add reply: %s", err.Error())
+	}
+	if _, err = pgdb.Comment.Add(&Comment{GuestId: uuid.New(), PhotoId: photoId, Body: "nested", State: CommentApproved, ParentId: reply.Id}); err != nil {
+		t.Fatalf("could not add nested reply: %s", err.Error())
+	}
+	other, _ := pgdb.Comment.Add(&Comment{GuestId: uuid.New(), PhotoId: photoId, Body: "other", State: CommentApproved})
+
+	if c, err := pgdb.Comment.Get(reply.Id); err != nil || c.ParentId != root.Id || !c.Owner {
+		t.Errorf("expected owner reply to %d got %v %v", root.Id, c, err)
+	}
+	if err = pgdb.Comment.Delete(root.Id); err != nil {
+		t.Errorf("could not delete thread: %s", err.Error())
+	}
+	if comments, err := pgdb.Comment.ListByPhoto(photoId); err != nil || len(comments) != 1 || comments[0].Id != other.Id {
+		t.Errorf("expected the thread to be deleted got %v %v", comments, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -11,6 +11,7 @@ import (
 	//"github.com/msvens/mexif"
 	"github.com/msvens/mphotos/internal/config"
 	"go.uber.org/zap"
+	"time"
 )
 
 type AlbumDAO interface {
@@ -46,14 +47,44 @@ type CameraDAO interface {
 }
 
 type CommentDAO interface {
-	Add(guestId uuid.UUID, photoId uuid.UUID, body string) (*Comment, error)
+	Add(c *Comment) (*Comment, error)
+	CountByGuest(guestId uuid.UUID, states ...string) (int, error)
 	Get(id int) (*Comment, error)
 	Delete(id int) error
 	DeleteByPhoto(photoId uuid.UUID) error
 	DeleteByGuest(guestId uuid.UUID) error
-	List() ([]*Comment, error)
-	ListByPhoto(photoId uuid.UUID) ([]*Comment, error)
+	List(states ...string) ([]*AuthoredComment, error)
+	ListByPhoto(photoId uuid.UUID, states ...string) ([]*AuthoredComment, error)
 	ListByGuest(photoId uuid.UUID) ([]*Comment, error)
+	Update(c *Comment) (*Comment, error)
+}
+
+type CollectionDAO interface {
+	Add(c *Collection) error
+	AddPhotos(id uuid.UUID, photoIds []uuid.UUID) (int, error)
+	Delete(id uuid.UUID) error
+	DeleteByGuest(guestId uuid.UUID) error
+	DeletePhotos(id uuid.UUID, photoIds []uuid.UUID) (int, error)
+	Get(id uuid.UUID) (*Collection, error)
+	ListByGuest(guestId uuid.UUID) ([]*Collection, error)
+	ListShared() ([]*Collection, error)
+	PhotoIds(id uuid.UUID) ([]uuid.UUID, error)
+	Photos(id uuid.UUID) ([]*Photo, error)
+	Update(c *Collection) (*Collection, error)
+}
+
+type DriveSourceDAO interface {
+	Add(source *DriveSource) (*DriveSource, error)
+	AddFolder(folder *DriveFolder) error
+	Delete(id uuid.UUID) error
+	DeleteFolder(id string) error
+	Folder(id string) (*DriveFolder, error)
+	Folders(sourceId uuid.UUID) ([]*DriveFolder, error)
+	Get(id uuid.UUID) (*DriveSource, error)
+	GetByFolder(folderId string) (*DriveSource, error)
+	HasFolder(folderId string) bool
+	List() ([]*DriveSource, error)
+	Update(source *DriveSource) (*DriveSource, error)
 }
 
 type GuestDAO interface {
@@ -65,18 +96,60 @@ type GuestDAO interface {
 	Has(id uuid.UUID) bool
 	HasByEmail(email string) bool
 	HasByName(name string) bool
+	List(query string) ([]*GuestActivity, error)
+	SetBanned(id uuid.UUID, banned bool) (*Guest, error)
 	Update(email string, name string, id uuid.UUID) (*Guest, error)
 }
 
+type GuestTokenDAO interface {
+	Add(token *GuestToken) error
+	DeleteByGuest(guestId uuid.UUID) error
+	Latest(guestId uuid.UUID) (*GuestToken, error)
+	ListByGuest(guestId uuid.UUID) ([]*GuestToken, error)
+	Prune(before time.Time) (int, error)
+	Use(hash string) (*GuestToken, error)
+}
+
+type JobDAO interface {
+	Add(job *Job) error
+	Get(id uuid.UUID) (*Job, error)
+	List(limit int) ([]*Job, error)
+	ListState(states ...string) ([]*Job, error)
+	Prune(states []string, before time.Time) (int, error)
+	Update(job *Job) error
+}
+
+type NotificationDAO interface {
+	Add(n *Notification) error
+	DeleteSent(before time.Time) (int, error)
+	MarkSent(ids []int) error
+	Pending() ([]*Notification, error)
+	ReachMilestone(photoId uuid.UUID, kind string, count int) (bool, error)
+}
+
+type ProofDAO interface {
+	CountSelected(albumId, guestId uuid.UUID) (int, error)
+	DeleteByGuest(guestId uuid.UUID) error
+	ListByAlbum(albumId uuid.UUID) ([]*ProofSelection, error)
+	ListByGuest(guestId uuid.UUID) ([]*ProofSelection, error)
+	Selections(albumId, guestId uuid.UUID) ([]*ProofSelection, error)
+	Set(sel *ProofSelection) error
+	Submission(albumId, guestId uuid.UUID) (*ProofSubmission, error)
+	Submissions(albumId uuid.UUID) ([]*ProofSubmission, error)
+	Submit(sub *ProofSubmission) error
+	Unlock(albumId, guestId uuid.UUID) error
+}
+
 type ReactionDAO interface {
 	Add(reaction *Reaction) error
+	Counts(photoIds ...uuid.UUID) (map[uuid.UUID]map[string]int, error)
 	Delete(reaction *Reaction) error
 	DeleteByGuest(guest uuid.UUID) error
 	DeleteByPhoto(photoId uuid.UUID) error
 	List() ([]*Reaction, error)
-	ListByGuest(guestId uuid.UUID) ([]uuid.UUID, error)
+	ListByGuest(guestId uuid.UUID, kinds ...string) ([]*Reaction, error)
 	ListByPhoto(photoId uuid.UUID) ([]*GuestReaction, error)
-	Has(guest uuid.UUID, photoId uuid.UUID) bool
+	Has(reaction *Reaction) bool
 }
 
 type PhotoDAO interface {
@@ -90,12 +163,24 @@ type PhotoDAO interface {
 	Has(id uuid.UUID) bool
 	HasMd5(md5 string) bool
 	Get(id uuid.UUID) (*Photo, error)
+	GetByMd5(md5 string) (*Photo, error)
+	GetBySource(source, sourceId string) (*Photo, error)
 	List() ([]*Photo, error)
 	ListSource(source string) ([]*Photo, error)
+	Replace(p *Photo, exif *metadata.Summary) error
 	//Select(r Range, order PhotoOrder, filter PhotoFilter) ([]*Photo, error)
 	//SetPrivate(private bool, id uuid.UUID) (*Photo, error)
 	Set(title string, description string, keywords []string, id uuid.UUID) (*Photo, error)
 	SetAlbums(id uuid.UUID, albumIds []uuid.UUID) (int, error)
+	SetFavorite(favorite bool, id uuid.UUID) (*Photo, error)
+	SetSource(p *Photo) (*Photo, error)
+}
+
+type SyncRunDAO interface {
+	Add(run *SyncRun) error
+	Last() (*SyncRun, error)
+	List(limit int) ([]*SyncRun, error)
+	Update(run *SyncRun) error
 }
 
 type UserDAO interface {
@@ -103,22 +188,44 @@ type UserDAO interface {
 	Get() (*User, error)
 }
 
+type WebhookDAO interface {
+	Add(hook *Webhook) (*Webhook, error)
+	AddDelivery(delivery *WebhookDelivery) error
+	Delete(id uuid.UUID) error
+	Deliveries(id uuid.UUID, limit int) ([]*WebhookDelivery, error)
+	Get(id uuid.UUID) (*Webhook, error)
+	List() ([]*Webhook, error)
+	Pending() ([]*WebhookDelivery, error)
+	PruneDeliveries(before time.Time) (int, error)
+	Update(hook *Webhook) (*Webhook, error)
+	UpdateDelivery(delivery *WebhookDelivery) error
+}
+
 type VersionDAO interface {
 	Get() (*Version, error)
+	Set(versionId int, description string) (*Version, error)
 	Update() (*Version, error)
 	IsCurrent() (bool, error)
 }
 
 type PGDB struct {
-	db       *sqlx.DB
-	Album    AlbumDAO
-	Camera   CameraDAO
-	Comment  CommentDAO
-	Guest    GuestDAO
-	Photo    PhotoDAO
-	Reaction ReactionDAO
-	User     UserDAO
-	Version  VersionDAO
+	db           *sqlx.DB
+	Album        AlbumDAO
+	Camera       CameraDAO
+	Collection   CollectionDAO
+	Comment      CommentDAO
+	Drive        DriveSourceDAO
+	Guest        GuestDAO
+	GuestToken   GuestTokenDAO
+	Job          JobDAO
+	Notification NotificationDAO
+	Photo        PhotoDAO
+	Proof        ProofDAO
+	Reaction     ReactionDAO
+	SyncRun      SyncRunDAO
+	User         UserDAO
+	Version      VersionDAO
+	Webhook      WebhookDAO
 }
 
 var logger *zap.SugaredLogger
@@ -143,15 +250,23 @@ func NewPGDB() (*PGDB, error) {
 			return nil, err
 		}
 		return &PGDB{
-			db:       db,
-			Album:    NewAlbumPG(db),
-			Camera:   NewCameraPG(db),
-			Comment:  NewCommentPG(db),
-			Guest:    NewGuestPG(db),
-			Photo:    NewPhotoPG(db),
-			Reaction: NewReactionPG(db),
-			User:     NewUserPG(db),
-			Version:  NewVersionPG(db),
+			db:           db,
+			Album:        NewAlbumPG(db),
+			Camera:       NewCameraPG(db),
+			Collection:   NewCollectionPG(db),
+			Comment:      NewCommentPG(db),
+			Drive:        NewDriveSourcePG(db),
+			Guest:        NewGuestPG(db),
+			GuestToken:   NewGuestTokenPG(db),
+			Job:          NewJobPG(db),
+			Notification: NewNotificationPG(db),
+			Photo:        NewPhotoPG(db),
+			Proof:        NewProofPG(db),
+			Reaction:     NewReactionPG(db),
+			SyncRun:      NewSyncRunPG(db),
+			User:         NewUserPG(db),
+			Version:      NewVersionPG(db),
+			Webhook:      NewWebhookPG(db),
 		}, nil
 	}
 }
@@ -175,7 +290,7 @@ func (pgd *PGDB) tableExists(table string) bool {
 
 func (pgd *PGDB) CreateTables() error {
 	//pgd.db.MustExec(schemaV1)
-	if _, err := pgd.db.Exec(schemaV3); err != nil {
+	if _, err := pgd.db.Exec(schemaV4); err != nil {
 		return err
 	} else { //make sure version is correct
 		_, err = pgd.Version.Update()
@@ -187,6 +302,6 @@ func (pgd *PGDB) CreateTables() error {
 }
 
 func (pgd *PGDB) DeleteTables() error {
-	_, err := pgd.db.Exec(deleteSchemaV3)
+	_, err := pgd.db.Exec(deleteSchemaV4)
 	return err
 }
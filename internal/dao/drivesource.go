@@ -0,0 +1,96 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type DriveSourcePG struct {
+	db               *sqlx.DB
+	insertSourceStmt string
+	updateSourceStmt string
+	insertFolderStmt string
+}
+
+func NewDriveSourcePG(db *sqlx.DB) *DriveSourcePG {
+	fields := getStructFields(&DriveSource{})
+	iStmt := buildInsertNamed("drivesource", fields)
+	uStmt := buildUpdateNamed2("drivesource", fields, "id")
+	fStmt := buildInsertNamed("drivefolder", getStructFields(&DriveFolder{})) +
+		" ON CONFLICT (id) DO UPDATE SET sourceId = EXCLUDED.sourceId, parentId = EXCLUDED.parentId," +
+		" name = EXCLUDED.name, path = EXCLUDED.path, albumId = EXCLUDED.albumId"
+	return &DriveSourcePG{db, iStmt, uStmt, fStmt}
+}
+
+func (dao *DriveSourcePG) Add(source *DriveSource) (*DriveSource, error) {
+	source.Id = uuid.New()
+	if _, err := dao.db.NamedExec(dao.insertSourceStmt, source); err != nil {
+		return nil, err
+	}
+	return dao.Get(source.Id)
+}
+
+func (dao *DriveSourcePG) Delete(id uuid.UUID) error {
+	if _, err := dao.db.Exec("DELETE FROM drivefolder WHERE sourceId = $1", id); err != nil {
+		return err
+	}
+	_, err := dao.db.Exec("DELETE FROM drivesource WHERE id = $1", id)
+	return err
+}
+
+func (dao *DriveSourcePG) Get(id uuid.UUID) (*DriveSource, error) {
+	ret := DriveSource{}
+	if err := dao.db.Get(&ret, "SELECT * FROM drivesource WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *DriveSourcePG) GetByFolder(folderId string) (*DriveSource, error) {
+	ret := DriveSource{}
+	if err := dao.db.Get(&ret, "SELECT * FROM drivesource WHERE folderId = $1", folderId); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *DriveSourcePG) HasFolder(folderId string) bool {
+	return has(dao.db, "drivesource", "folderId", folderId)
+}
+
+func (dao *DriveSourcePG) List() ([]*DriveSource, error) {
+	ret := []*DriveSource{}
+	err := dao.db.Select(&ret, "SELECT * FROM drivesource ORDER BY folderName")
+	return ret, err
+}
+
+func (dao *DriveSourcePG) Update(source *DriveSource) (*DriveSource, error) {
+	if _, err := dao.db.NamedExec(dao.updateSourceStmt, source); err != nil {
+		return nil, err
+	}
+	return dao.Get(source.Id)
+}
+
+func (dao *DriveSourcePG) AddFolder(folder *DriveFolder) error {
+	_, err := dao.db.NamedExec(dao.insertFolderStmt, folder)
+	return err
+}
+
+func (dao *DriveSourcePG) DeleteFolder(id string) error {
+	_, err := dao.db.Exec("DELETE FROM drivefolder WHERE id = $1", id)
+	return err
+}
+
+func (dao *DriveSourcePG) Folder(id string) (*DriveFolder, error) {
+	ret := DriveFolder{}
+	if err := dao.db.Get(&ret, "SELECT * FROM drivefolder WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *DriveSourcePG) Folders(sourceId uuid.UUID) ([]*DriveFolder, error) {
+	ret := []*DriveFolder{}
+	err := dao.db.Select(&ret, "SELECT * FROM drivefolder WHERE sourceId = $1 ORDER BY path", sourceId)
+	return ret, err
+}
@@ -0,0 +1,60 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestDriveSources(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	src, err := pgdb.Drive.Add(&DriveSource{FolderId: "folder1", FolderName: "Photos", Recursive: true, Albums: true})
+	if err != nil {
+		t.Fatalf("could not add drive source: %s", err.Error())
+	}
+	if !pgdb.Drive.HasFolder("folder1") {
+		t.Errorf("expected drive source for folder1")
+	}
+	if _, err = pgdb.Drive.Add(&DriveSource{FolderId: "folder1", FolderName: "Photos"}); err == nil {
+		t.Errorf("expected error when adding the same folder twice")
+	}
+
+	src.Albums = false
+	if src, err = pgdb.Drive.Update(src); err != nil {
+		t.Errorf("could not update drive source: %s", err.Error())
+	} else if src.Albums || !src.Recursive {
+		t.Errorf("drive source not updated: %v", src)
+	}
+
+	folder := DriveFolder{Id: "sub1", SourceId: src.Id, ParentId: "2024", Name: "Trip-X", Path: "2024/Trip-X"}
+	if err = pgdb.Drive.AddFolder(&folder); err != nil {
+		t.Errorf("could not add drive folder: %s", err.Error())
+	}
+	folder.ParentId, folder.Path, folder.AlbumId = "2025", "2025/Trip-X", uuid.New()
+	if err = pgdb.Drive.AddFolder(&folder); err != nil {
+		t.Errorf("could not update drive folder: %s", err.Error())
+	}
+	if f, err := pgdb.Drive.Folder("sub1"); err != nil {
+		t.Errorf("could not get drive folder: %s", err.Error())
+	} else if *f != folder {
+		t.Errorf("expected %v got %v", folder, f)
+	}
+
+	if err = pgdb.Drive.AddFolder(&DriveFolder{Id: "sub2", SourceId: src.Id, ParentId: "sub1", Name: "Day1", Path: "2025/Trip-X/Day1"}); err != nil {
+		t.Errorf("could not add drive folder: %s", err.Error())
+	}
+	if err = pgdb.Drive.DeleteFolder("sub2"); err != nil {
+		t.Errorf("could not delete drive folder: %s", err.Error())
+	}
+	if folders, _ := pgdb.Drive.Folders(src.Id); len(folders) != 1 {
+		t.Errorf("expected 1 drive folder got %v", folders)
+	}
+
+	if err = pgdb.Drive.Delete(src.Id); err != nil {
+		t.Errorf("could not delete drive source: %s", err.Error())
+	}
+	if folders, _ := pgdb.Drive.Folders(src.Id); len(folders) != 0 {
+		t.Errorf("expected drive folders to be deleted with the source")
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -26,9 +26,11 @@ func (dao *GuestPG) Add(name, email string) (*Guest, error) {
 	}
 	return dao.Get(g.Id)
 }
+
+// Delete deletes the guest together with everything the guest has added
 func (dao *GuestPG) Delete(id uuid.UUID) error {
 	var cnt int64
-	if res, err := dao.db.Exec("DELETE FROM guests WHERE id = $1", id); err != nil {
+	if res, err := dao.db.Exec("DELETE FROM guest WHERE id = $1", id); err != nil {
 		return err
 	} else {
 		cnt, _ = res.RowsAffected()
@@ -37,7 +39,23 @@ func (dao *GuestPG) Delete(id uuid.UUID) error {
 		if _, err := dao.db.Exec("DELETE from reaction WHERE guestId = $1", id); err != nil {
 			return err
 		}
-		if _, err := dao.db.Exec("DELETE from comment WHERE guestId = $1", id); err != nil {
+		if _, err := dao.db.Exec(deleteGuestCommentsStmt, id); err != nil {
+			return err
+		}
+		if _, err := dao.db.Exec("DELETE from guesttoken WHERE guestId = $1", id); err != nil {
+			return err
+		}
+		const stmt = "DELETE from collectionphotos WHERE collectionId IN (SELECT id FROM collection WHERE guestId = $1)"
+		if _, err := dao.db.Exec(stmt, id); err != nil {
+			return err
+		}
+		if _, err := dao.db.Exec("DELETE from collection WHERE guestId = $1", id); err != nil {
+			return err
+		}
+		if _, err := dao.db.Exec("DELETE from proofselection WHERE guestId = $1", id); err != nil {
+			return err
+		}
+		if _, err := dao.db.Exec("DELETE from proofsubmission WHERE guestId = $1", id); err != nil {
 			return err
 		}
 	}
@@ -88,6 +106,26 @@ func (dao *GuestPG) HasByName(name string) bool {
 		return false
 	}
 }
+
+// List returns guests whose name or email contains query, or all guests if query is empty,
+// together with their activity. Ordered by name
+func (dao *GuestPG) List(query string) ([]*GuestActivity, error) {
+	const stmt = `SELECT guest.*,
+		(SELECT count(*) FROM comment WHERE comment.guestId = guest.id AND comment.owner = false) AS comments,
+		(SELECT count(*) FROM reaction WHERE reaction.guestId = guest.id) AS reactions
+	FROM guest WHERE $1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%' ORDER BY name`
+	ret := []*GuestActivity{}
+	err := dao.db.Select(&ret, stmt, query)
+	return ret, err
+}
+
+func (dao *GuestPG) SetBanned(id uuid.UUID, banned bool) (*Guest, error) {
+	if _, err := dao.db.Exec("UPDATE guest SET banned = $1 WHERE id = $2", banned, id); err != nil {
+		return nil, err
+	}
+	return dao.Get(id)
+}
+
 func (dao *GuestPG) Update(email string, name string, id uuid.UUID) (*Guest, error) {
 	const stmt = "UPDATE guest SET (email, name) = ($1, $2) WHERE id = $3"
 	if _, err := dao.db.Exec(stmt, email, name, id); err != nil {
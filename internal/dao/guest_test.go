@@ -0,0 +1,51 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestGuestActivityAndBan(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	anna, err := pgdb.Guest.Add("anna", "anna@example.com")
+	if err != nil {
+		t.Fatalf("could not add guest: %s", err.Error())
+	}
+	if _, err := pgdb.Guest.Add("bertil", "bertil@example.org"); err != nil {
+		t.Fatalf("could not add guest: %s", err.Error())
+	}
+	if err := pgdb.Reaction.Add(&Reaction{GuestId: anna.Id, PhotoId: uuid.New(), Kind: "like"}); err != nil {
+		t.Fatalf("could not add reaction: %s", err.Error())
+	}
+	if _, err := pgdb.Comment.Add(&Comment{GuestId: anna.Id, PhotoId: uuid.New(), Body: "nice", State: CommentApproved}); err != nil {
+		t.Fatalf("could not add comment: %s", err.Error())
+	}
+
+	if guests, err := pgdb.Guest.List(""); err != nil || len(guests) != 2 {
+		t.Fatalf("expected 2 guests got %v %v", guests, err)
+	} else if guests[0].Id != anna.Id || guests[0].Comments != 1 || guests[0].Reactions != 1 || guests[1].Comments != 0 {
+		t.Errorf("unexpected guest activity %v %v", guests[0], guests[1])
+	}
+	if guests, err := pgdb.Guest.List("EXAMPLE.ORG"); err != nil || len(guests) != 1 || guests[0].Name != "bertil" {
+		t.Errorf("expected to find bertil by email got %v %v", guests, err)
+	}
+
+	if g, err := pgdb.Guest.SetBanned(anna.Id, true); err != nil || !g.Banned {
+		t.Errorf("expected banned guest got %v %v", g, err)
+	}
+	if g, err := pgdb.Guest.SetBanned(anna.Id, false); err != nil || g.Banned {
+		t.Errorf("expected unbanned guest got %v %v", g, err)
+	}
+
+	if err := pgdb.Guest.Delete(anna.Id); err != nil {
+		t.Errorf("could not delete guest: %s", err.Error())
+	}
+	if pgdb.Guest.Has(anna.Id) {
+		t.Errorf("expected guest to be deleted")
+	}
+	if reactions, err := pgdb.Reaction.ListByGuest(anna.Id); err != nil || len(reactions) != 0 {
+		t.Errorf("expected guest reactions to be deleted got %v %v", reactions, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -0,0 +1,64 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type GuestTokenPG struct {
+	db         *sqlx.DB
+	insertStmt string
+}
+
+func NewGuestTokenPG(db *sqlx.DB) *GuestTokenPG {
+	fields := getStructFields(&GuestToken{})
+	return &GuestTokenPG{db, buildInsertNamed("guesttoken", fields)}
+}
+
+func (dao *GuestTokenPG) Add(token *GuestToken) error {
+	_, err := dao.db.NamedExec(dao.insertStmt, token)
+	return err
+}
+
+func (dao *GuestTokenPG) DeleteByGuest(guestId uuid.UUID) error {
+	_, err := dao.db.Exec("DELETE FROM guesttoken WHERE guestId = $1", guestId)
+	return err
+}
+
+// Latest returns the most recently created token for guestId
+func (dao *GuestTokenPG) Latest(guestId uuid.UUID) (*GuestToken, error) {
+	ret := GuestToken{}
+	const stmt = "SELECT * FROM guesttoken WHERE guestId = $1 ORDER BY created DESC LIMIT 1"
+	if err := dao.db.Get(&ret, stmt, guestId); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *GuestTokenPG) ListByGuest(guestId uuid.UUID) ([]*GuestToken, error) {
+	ret := []*GuestToken{}
+	err := dao.db.Select(&ret, "SELECT * FROM guesttoken WHERE guestId = $1 ORDER BY created DESC", guestId)
+	return ret, err
+}
+
+// Prune deletes tokens that expired before before
+func (dao *GuestTokenPG) Prune(before time.Time) (int, error) {
+	res, err := dao.db.Exec("DELETE FROM guesttoken WHERE expires < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+// Use marks the token with hash as used and returns it. Returns sql.ErrNoRows if the token does not
+// exist, has expired or has already been used
+func (dao *GuestTokenPG) Use(hash string) (*GuestToken, error) {
+	ret := GuestToken{}
+	const stmt = "UPDATE guesttoken SET used = true WHERE hash = $1 AND used = false AND expires > $2 RETURNING *"
+	if err := dao.db.Get(&ret, stmt, hash, time.Now()); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -0,0 +1,49 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGuestTokens(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+	guest, err := pgdb.Guest.Add("guest", "guest@example.com")
+	if err != nil {
+		t.Fatalf("could not add guest: %s", err.Error())
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	expired := GuestToken{Hash: "expired", GuestId: guest.Id, Purpose: TokenVerify, Created: now.Add(-time.Hour),
+		Expires: now.Add(-time.Minute)}
+	valid := GuestToken{Hash: "valid", GuestId: guest.Id, Purpose: TokenEmail, Email: "new@example.com", Created: now,
+		Expires: now.Add(time.Hour)}
+	for _, tok := range []*GuestToken{&expired, &valid} {
+		if err := pgdb.GuestToken.Add(tok); err != nil {
+			t.Fatalf("could not add token: %s", err.Error())
+		}
+	}
+	if latest, err := pgdb.GuestToken.Latest(guest.Id); err != nil || latest.Hash != valid.Hash {
+		t.Errorf("expected latest token %s got %v %v", valid.Hash, latest, err)
+	}
+	if tokens, err := pgdb.GuestToken.ListByGuest(guest.Id); err != nil || len(tokens) != 2 {
+		t.Errorf("expected 2 tokens got %v %v", tokens, err)
+	}
+	if _, err := pgdb.GuestToken.Use(expired.Hash); err == nil {
+		t.Errorf("expected expired token to be rejected")
+	}
+	if tok, err := pgdb.GuestToken.Use(valid.Hash); err != nil || tok.GuestId != guest.Id || !tok.Used || tok.Email != valid.Email {
+		t.Errorf("expected to use token got %v %v", tok, err)
+	}
+	if _, err := pgdb.GuestToken.Use(valid.Hash); err == nil {
+		t.Errorf("expected token to be single use")
+	}
+	if cnt, err := pgdb.GuestToken.Prune(now); err != nil || cnt != 1 {
+		t.Errorf("expected 1 pruned token got %d %v", cnt, err)
+	}
+	if err := pgdb.GuestToken.DeleteByGuest(guest.Id); err != nil {
+		t.Errorf("could not delete tokens: %s", err.Error())
+	}
+	if _, err := pgdb.GuestToken.Latest(guest.Id); err == nil {
+		t.Errorf("expected no tokens")
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -119,7 +119,12 @@ func CreateImageDir(dir string) error {
 */
 
 func GenerateImages(fName string) error {
-	srcFile := config.PhotoFilePath(config.Original, fName)
+	return GenerateImagesFrom(config.PhotoFilePath(config.Original, fName), fName)
+}
+
+// GenerateImagesFrom generates the images of fName from srcFile instead of the original, e.g.
+// from a new original that has not replaced the old one yet
+func GenerateImagesFrom(srcFile, fName string) error {
 	//base := filepath.Base(srcFile)
 	imgMap := map[string]img.Options{}
 
@@ -0,0 +1,68 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type JobPG struct {
+	db         *sqlx.DB
+	insertStmt string
+	updateStmt string
+}
+
+func NewJobPG(db *sqlx.DB) *JobPG {
+	fields := getStructFields(&Job{})
+	return &JobPG{db, buildInsertNamed("job", fields), buildUpdateNamed2("job", fields, "id")}
+}
+
+func (dao *JobPG) Add(job *Job) error {
+	_, err := dao.db.NamedExec(dao.insertStmt, job)
+	return err
+}
+
+func (dao *JobPG) Get(id uuid.UUID) (*Job, error) {
+	ret := Job{}
+	if err := dao.db.Get(&ret, "SELECT * FROM job WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// List returns the latest limit jobs, most recent first
+func (dao *JobPG) List(limit int) ([]*Job, error) {
+	ret := []*Job{}
+	err := dao.db.Select(&ret, "SELECT * FROM job ORDER BY created DESC LIMIT $1", limit)
+	return ret, err
+}
+
+// ListState returns all jobs in any of states, oldest first
+func (dao *JobPG) ListState(states ...string) ([]*Job, error) {
+	ret := []*Job{}
+	q, args, err := sqlx.In("SELECT * FROM job WHERE state IN (?) ORDER BY created", states)
+	if err != nil {
+		return nil, err
+	}
+	err = dao.db.Select(&ret, dao.db.Rebind(q), args...)
+	return ret, err
+}
+
+// Prune deletes jobs in any of states that were created before before
+func (dao *JobPG) Prune(states []string, before time.Time) (int, error) {
+	q, args, err := sqlx.In("DELETE FROM job WHERE state IN (?) AND created < ?", states, before)
+	if err != nil {
+		return 0, err
+	}
+	res, err := dao.db.Exec(dao.db.Rebind(q), args...)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+func (dao *JobPG) Update(job *Job) error {
+	_, err := dao.db.NamedExec(dao.updateStmt, job)
+	return err
+}
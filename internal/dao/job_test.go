@@ -0,0 +1,46 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := Job{Id: uuid.New(), Kind: "REINDEX", State: "FINISHED", Params: "null", Created: now.Add(-48 * time.Hour)}
+	job := Job{Id: uuid.New(), Kind: "REGENERATE", State: "SCHEDULED", Params: `{"photoIds":[]}`, Created: now}
+	for _, j := range []*Job{&old, &job} {
+		if err := pgdb.Job.Add(j); err != nil {
+			t.Fatalf("could not add job: %s", err.Error())
+		}
+	}
+	if jobs, err := pgdb.Job.ListState("SCHEDULED", "STARTED"); err != nil {
+		t.Errorf("could not list jobs: %s", err.Error())
+	} else if len(jobs) != 1 || jobs[0].Id != job.Id {
+		t.Errorf("expected 1 scheduled job got %v", jobs)
+	}
+
+	job.State, job.NumFiles, job.Results = "ABORTED", 2, `[{"name":"a.jpg"}]`
+	job.ErrorCode, job.ErrorMessage = 500, "failed"
+	if err := pgdb.Job.Update(&job); err != nil {
+		t.Errorf("could not update job: %s", err.Error())
+	}
+	if j, err := pgdb.Job.Get(job.Id); err != nil {
+		t.Errorf("could not get job: %s", err.Error())
+	} else if j.State != "ABORTED" || j.Results != job.Results || j.ErrorMessage != "failed" {
+		t.Errorf("expected %v got %v", job, j)
+	}
+
+	if n, err := pgdb.Job.Prune([]string{"FINISHED", "ABORTED"}, now.Add(-time.Hour)); err != nil {
+		t.Errorf("could not prune jobs: %s", err.Error())
+	} else if n != 1 {
+		t.Errorf("expected 1 pruned job got %d", n)
+	}
+	if jobs, _ := pgdb.Job.List(10); len(jobs) != 1 || jobs[0].Id != job.Id {
+		t.Errorf("expected only the recent job to remain got %v", jobs)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
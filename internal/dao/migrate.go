@@ -2,18 +2,37 @@ package dao
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 )
 
-// for now this is just hard coded
+// dbV3Description is the description of a db upgraded to version 3
+const dbV3Description = "Version 3 adds simple access control to albums and makes public access based on a default photo stream album"
+
+// canUpgradeDb returns true if the db can be upgraded to DbVersion. Version 2 and 3 dbs are
+// upgraded one version at a time
 func canUpgradeDb(pgdb *PGDB) bool {
 	if v, err := pgdb.Version.Get(); err != nil {
 		fmt.Println("could not get Version info: ", err)
 		return false
 	} else {
-		return v.VersionId+1 == DbVersion
+		return v.VersionId >= 2 && v.VersionId < DbVersion
 	}
 }
 
+// upgrade runs the upgrades from the current version of the db to DbVersion
+func upgrade(pgdb *PGDB) error {
+	v, err := pgdb.Version.Get()
+	if err != nil {
+		return err
+	}
+	if v.VersionId == 2 {
+		if err = upgradeToV3(pgdb); err != nil {
+			return err
+		}
+	}
+	return upgradeToV4(pgdb)
+}
+
 func UpgradeDb() error {
 	var err error
 	var pgdb *PGDB
@@ -29,7 +48,7 @@ func UpgradeDb() error {
 			fmt.Println("Database is up to date")
 			return nil
 		} else if canUpgradeDb(pgdb) {
-			return upgradeToV3(pgdb)
+			return upgrade(pgdb)
 		} else {
 			return fmt.Errorf("Cannot upgrade database, wrong current version")
 		}
@@ -44,46 +63,86 @@ func UpgradeDb() error {
 	return nil
 }
 
-func upgradeToV3(pgdb *PGDB) error {
+// checkDuplicateMd5 fails if several photos have the same md5, since version 4 adds a unique
+// index on md5. The duplicates are listed so that they can be deleted before upgrading
+func checkDuplicateMd5(pgdb *PGDB) error {
+	const stmt = "SELECT id, md5 FROM img WHERE md5 IN (SELECT md5 FROM img GROUP BY md5 HAVING COUNT(*) > 1) ORDER BY md5, uploadDate"
+	var dups []*Photo
+	if err := pgdb.db.Select(&dups, stmt); err != nil {
+		return err
+	}
+	if len(dups) == 0 {
+		return nil
+	}
+	fmt.Println("Photos with the same md5:")
+	for _, p := range dups {
+		fmt.Println(p.Md5, p.Id)
+	}
+	return fmt.Errorf("Cannot upgrade database, %d photos have duplicate md5s. Delete the duplicates and upgrade again", len(dups))
+}
+
+func upgradeToV4(pgdb *PGDB) error {
 	fmt.Println("Upgrading Db to Version: ", DbVersion)
+	if err := checkDuplicateMd5(pgdb); err != nil {
+		return err
+	}
+	if _, err := pgdb.db.Exec(schemaV3toV4); err != nil {
+		return err
+	}
+	fmt.Println("Db Updated. Change Version Info")
+	if v, err := pgdb.Version.Update(); err != nil {
+		return err
+	} else {
+		fmt.Println("Updated Db to version: ", v.VersionId)
+	}
+	return nil
+}
+
+func upgradeToV3(pgdb *PGDB) error {
+	fmt.Println("Upgrading Db to Version: ", 3)
 
 	var err error
 	var v *Version
-	var a *Album
-	var photos []*Photo
+	var photoIds []uuid.UUID
 
 	if _, err = pgdb.db.Exec(schemaV2toV3); err != nil {
 		return err
 	}
 
-	fmt.Println("Db Updated. Change Version Info")
-	if v, err = pgdb.Version.Update(); err != nil {
-		return err
-	} else {
-		fmt.Println("Updated Db to version: ", v.VersionId)
-	}
+	//the album table is still at version 3 so the album dao cannot be used
 	fmt.Println("create photo stream album")
-	if a, err = pgdb.Album.Add("photostream", "Default public photostream", ""); err != nil {
+	albumId := uuid.New()
+	const addAlbum = "INSERT INTO album (id, name, description, coverPic, code, orderBy) VALUES ($1, 'photostream', 'Default public photostream', '', '', 0)"
+	if _, err = pgdb.db.Exec(addAlbum, albumId); err != nil {
 		return err
 	}
 
 	fmt.Println("Add all public photos to it")
 
-	if err = pgdb.db.Select(&photos, "SELECT * FROM img WHERE private = false"); err != nil {
+	if err = pgdb.db.Select(&photoIds, "SELECT id FROM img WHERE private = false"); err != nil {
 		return err
 	}
 
 	const addAlbumPhoto = "INSERT INTO albumphotos (albumId, photoId) VALUES ($1, $2)"
-	for _, p := range photos {
-		if _, err := pgdb.db.Exec(addAlbumPhoto, a.Id, p.Id); err != nil {
-			return nil
+	for _, id := range photoIds {
+		if _, err := pgdb.db.Exec(addAlbumPhoto, albumId, id); err != nil {
+			return err
 		}
 	}
 
 	//finally delete the private column
 	fmt.Println("Drop the private column from image")
-	_, err = pgdb.db.Exec("ALTER TABLE img DROP COLUMN private")
-	return err
+	if _, err = pgdb.db.Exec("ALTER TABLE img DROP COLUMN private"); err != nil {
+		return err
+	}
+
+	//the version is changed last so that a failed upgrade is not taken for a version 3 db
+	fmt.Println("Db Updated. Change Version Info")
+	if v, err = pgdb.Version.Set(3, dbV3Description); err != nil {
+		return err
+	}
+	fmt.Println("Updated Db to version: ", v.VersionId)
+	return nil
 }
 
 func upgradeToV2(pgdb *PGDB) error {
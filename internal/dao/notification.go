@@ -0,0 +1,69 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type NotificationPG struct {
+	db         *sqlx.DB
+	insertStmt string
+}
+
+func NewNotificationPG(db *sqlx.DB) *NotificationPG {
+	fields := getStructFields(&Notification{})
+	return &NotificationPG{db, buildInsertNamed("notification", fields, "id") + " RETURNING ID"}
+}
+
+func (dao *NotificationPG) Add(n *Notification) error {
+	rows, err := dao.db.NamedQuery(dao.insertStmt, n)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	rows.Next()
+	return rows.Scan(&n.Id)
+}
+
+// DeleteSent deletes sent notifications created before before
+func (dao *NotificationPG) DeleteSent(before time.Time) (int, error) {
+	res, err := dao.db.Exec("DELETE FROM notification WHERE sent = true AND created < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+func (dao *NotificationPG) MarkSent(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("UPDATE notification SET sent = true WHERE id IN (?)", ids)
+	if err != nil {
+		return err
+	}
+	_, err = dao.db.Exec(dao.db.Rebind(query), args...)
+	return err
+}
+
+// Pending returns all notifications that have not been sent, oldest first
+func (dao *NotificationPG) Pending() ([]*Notification, error) {
+	ret := []*Notification{}
+	err := dao.db.Select(&ret, "SELECT * FROM notification WHERE sent = false ORDER BY created")
+	return ret, err
+}
+
+// ReachMilestone records that photo has count reactions of kind. Returns true if no count as
+// high has been recorded before, i.e. the first time a milestone is reached
+func (dao *NotificationPG) ReachMilestone(photoId uuid.UUID, kind string, count int) (bool, error) {
+	const stmt = "INSERT INTO milestone (photoId, kind, count) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (photoId, kind) DO UPDATE SET count = EXCLUDED.count WHERE milestone.count < EXCLUDED.count"
+	res, err := dao.db.Exec(stmt, photoId, kind, count)
+	if err != nil {
+		return false, err
+	}
+	cnt, err := res.RowsAffected()
+	return cnt > 0, err
+}
@@ -0,0 +1,51 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestNotifications(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := Notification{Kind: "comment", Message: "old", Created: now.Add(-48 * time.Hour)}
+	latest := Notification{Kind: "guest", Message: "latest", Created: now}
+	for _, n := range []*Notification{&old, &latest} {
+		if err := pgdb.Notification.Add(n); err != nil {
+			t.Fatalf("could not add notification: %s", err.Error())
+		}
+	}
+	if old.Id == 0 || latest.Id == old.Id {
+		t.Errorf("expected notification ids got %d %d", old.Id, latest.Id)
+	}
+	if pending, err := pgdb.Notification.Pending(); err != nil {
+		t.Errorf("could not list pending notifications: %s", err.Error())
+	} else if len(pending) != 2 || pending[0].Id != old.Id {
+		t.Errorf("expected 2 notifications, oldest first got %v", pending)
+	}
+	if err := pgdb.Notification.MarkSent([]int{old.Id}); err != nil {
+		t.Errorf("could not mark notification as sent: %s", err.Error())
+	}
+	if pending, err := pgdb.Notification.Pending(); err != nil || len(pending) != 1 || pending[0].Id != latest.Id {
+		t.Errorf("expected 1 pending notification got %v %v", pending, err)
+	}
+	if cnt, err := pgdb.Notification.DeleteSent(now.Add(-time.Hour)); err != nil || cnt != 1 {
+		t.Errorf("expected 1 deleted notification got %d %v", cnt, err)
+	}
+
+	photoId := uuid.New()
+	for _, m := range []struct {
+		count   int
+		reached bool
+	}{{1, true}, {1, false}, {5, true}, {1, false}, {5, false}} {
+		if reached, err := pgdb.Notification.ReachMilestone(photoId, "like", m.count); err != nil || reached != m.reached {
+			t.Errorf("expected milestone %d reached to be %v got %v %v", m.count, m.reached, reached, err)
+		}
+	}
+	if reached, _ := pgdb.Notification.ReachMilestone(photoId, "heart", 1); !reached {
+		t.Errorf("expected milestones to be per kind")
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
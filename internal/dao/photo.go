@@ -2,6 +2,7 @@ package dao
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -9,23 +10,29 @@ import (
 	"strings"
 )
 
+// ErrMd5Exists is returned by Add and Replace when another photo already has the same md5
+var ErrMd5Exists = errors.New("photo with md5 already exists")
+
 type PhotoPG struct {
 	db              *sqlx.DB
 	photoFields     []string
 	insertIntoPhoto string
+	updatePhoto     string
 }
 
 func NewPhotoPG(db *sqlx.DB) *PhotoPG {
 	p := &Photo{}
 	fields := getStructFields(p)
-	return &PhotoPG{db, fields, buildInsertNamed("img", fields)}
+	return &PhotoPG{db, fields, buildInsertNamed("img", fields), buildUpdateNamed2("img", fields, "id")}
 }
 
 func (dao *PhotoPG) Add(p *Photo, exif *metadata.Summary) error {
 	if p.Id == uuid.Nil {
 		p.Id = uuid.New()
 	}
-	if _, err := dao.db.NamedExec(dao.insertIntoPhoto, p); err != nil {
+	if _, err := dao.db.NamedExec(dao.insertIntoPhoto, p); isUniqueViolation(err) {
+		return ErrMd5Exists
+	} else if err != nil {
 		return err
 	}
 	data, err := json.Marshal(exif)
@@ -36,6 +43,23 @@ func (dao *PhotoPG) Add(p *Photo, exif *metadata.Summary) error {
 	return err
 }
 
+// Replace updates all fields and the exif data of an existing photo
+func (dao *PhotoPG) Replace(p *Photo, exif *metadata.Summary) error {
+	if res, err := dao.db.NamedExec(dao.updatePhoto, p); isUniqueViolation(err) {
+		return ErrMd5Exists
+	} else if err != nil {
+		return err
+	} else if cnt, _ := res.RowsAffected(); cnt == 0 {
+		return fmt.Errorf("Could not find photo")
+	}
+	data, err := json.Marshal(exif)
+	if err != nil {
+		return err
+	}
+	_, err = dao.db.Exec("UPDATE exifdata SET data = $1 WHERE id = $2", string(data), p.Id)
+	return err
+}
+
 func (dao *PhotoPG) Albums(id uuid.UUID) ([]*Album, error) {
 	if !dao.Has(id) {
 		return nil, fmt.Errorf("No Such Photo")
@@ -127,12 +151,21 @@ func (dao *PhotoPG) Delete(id uuid.UUID) (bool, error) {
 		if _, err := dao.db.Exec("DELETE from reaction WHERE photoId = $1", id); err != nil {
 			return deleted, err
 		}
+		if _, err := dao.db.Exec("DELETE from milestone WHERE photoId = $1", id); err != nil {
+			return deleted, err
+		}
 		if _, err := dao.db.Exec("DELETE from comment WHERE photoId = $1", id); err != nil {
 			return deleted, err
 		}
 		if _, err := dao.db.Exec("DELETE from albumphotos WHERE photoId = $1", id); err != nil {
 			return deleted, err
 		}
+		if _, err := dao.db.Exec("DELETE from collectionphotos WHERE photoId = $1", id); err != nil {
+			return deleted, err
+		}
+		if _, err := dao.db.Exec("DELETE from proofselection WHERE photoId = $1", id); err != nil {
+			return deleted, err
+		}
 
 	}
 	return deleted, nil
@@ -176,6 +209,18 @@ func (dao *PhotoPG) Get(id uuid.UUID) (*Photo, error) {
 	return ret, err
 }
 
+func (dao *PhotoPG) GetByMd5(md5 string) (*Photo, error) {
+	ret := &Photo{}
+	err := dao.db.Get(ret, "SELECT * FROM img WHERE md5 = $1 LIMIT 1", md5)
+	return ret, err
+}
+
+func (dao *PhotoPG) GetBySource(source, sourceId string) (*Photo, error) {
+	ret := &Photo{}
+	err := dao.db.Get(ret, "SELECT * FROM img WHERE source = $1 AND sourceId = $2 LIMIT 1", source, sourceId)
+	return ret, err
+}
+
 func (dao *PhotoPG) List() ([]*Photo, error) {
 	ret := []*Photo{}
 	err := dao.db.Select(&ret, "SELECT * FROM img ORDER BY uploaddate DESC")
@@ -231,13 +276,31 @@ func (dao *PhotoPG) Set(title string, description string, keywords []string, id
 			b.WriteByte(',')
 		}
 	}
-	stmt := "UPDATE img SET title = $1, description = $2, keywords = $3 WHERE id = $4"
+	stmt := "UPDATE img SET title = $1, description = $2, keywords = $3, edited = true WHERE id = $4"
 	if _, err := dao.db.Exec(stmt, title, description, b.String(), id); err != nil {
 		return nil, err
 	}
 	return dao.Get(id)
 }
 
+func (dao *PhotoPG) SetFavorite(favorite bool, id uuid.UUID) (*Photo, error) {
+	stmt := "UPDATE img SET favorite = $1, favoriteEdited = true WHERE id = $2"
+	if _, err := dao.db.Exec(stmt, favorite, id); err != nil {
+		return nil, err
+	}
+	return dao.Get(id)
+}
+
+// SetSource updates the fields that are synced from the photo source
+func (dao *PhotoPG) SetSource(p *Photo) (*Photo, error) {
+	const stmt = "UPDATE img SET title = :title, description = :description, keywords = :keywords, " +
+		"favorite = :favorite, sourcePath = :sourcepath, sourceModified = :sourcemodified WHERE id = :id"
+	if _, err := dao.db.NamedExec(stmt, p); err != nil {
+		return nil, err
+	}
+	return dao.Get(p.Id)
+}
+
 func (dao *PhotoPG) SetAlbums(id uuid.UUID, albumIds []uuid.UUID) (int, error) {
 	if _, err := dao.ClearAlbums(id); err != nil {
 		return 0, err
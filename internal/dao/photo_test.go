@@ -2,9 +2,12 @@ package dao
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"io/ioutil"
 	"strconv"
+	"testing"
 )
 
 var testPhotos []Photo
@@ -40,3 +43,27 @@ func loadPhotoTestData() error {
 	loadedTestData = true
 	return nil
 }
+
+func TestPhotoDuplicateMd5(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+	if err := loadPhotoTestData(); err != nil {
+		t.Fatalf("Could not load img test data: %v", err)
+	}
+	if err := pgdb.Photo.Add(&testPhotos[0], testExifs[0].Data); err != nil {
+		t.Fatalf("Could not create img: %v", err)
+	}
+	dup := testPhotos[0]
+	dup.Id = uuid.New()
+	if err := pgdb.Photo.Add(&dup, testExifs[0].Data); !errors.Is(err, ErrMd5Exists) {
+		t.Errorf("expected ErrMd5Exists got %v", err)
+	}
+	if err := pgdb.Photo.Add(&testPhotos[1], testExifs[1].Data); err != nil {
+		t.Fatalf("Could not create img: %v", err)
+	}
+	replaced := testPhotos[1]
+	replaced.Md5 = testPhotos[0].Md5
+	if err := pgdb.Photo.Replace(&replaced, testExifs[1].Data); !errors.Is(err, ErrMd5Exists) {
+		t.Errorf("expected ErrMd5Exists on replace got %v", err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -0,0 +1,88 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type ProofPG struct {
+	db *sqlx.DB
+}
+
+func NewProofPG(db *sqlx.DB) *ProofPG {
+	return &ProofPG{db}
+}
+
+// CountSelected returns the number of photos guestId has selected in albumId
+func (dao *ProofPG) CountSelected(albumId, guestId uuid.UUID) (int, error) {
+	var cnt int
+	const stmt = "SELECT count(*) FROM proofselection WHERE albumId = $1 AND guestId = $2 AND selected = true"
+	err := dao.db.Get(&cnt, stmt, albumId, guestId)
+	return cnt, err
+}
+
+func (dao *ProofPG) DeleteByGuest(guestId uuid.UUID) error {
+	if _, err := dao.db.Exec("DELETE FROM proofselection WHERE guestId = $1", guestId); err != nil {
+		return err
+	}
+	_, err := dao.db.Exec("DELETE FROM proofsubmission WHERE guestId = $1", guestId)
+	return err
+}
+
+// ListByAlbum returns the selections of all guests in albumId
+func (dao *ProofPG) ListByAlbum(albumId uuid.UUID) ([]*ProofSelection, error) {
+	ret := []*ProofSelection{}
+	err := dao.db.Select(&ret, "SELECT * FROM proofselection WHERE albumId = $1 ORDER BY guestId, updated", albumId)
+	return ret, err
+}
+
+// ListByGuest returns the selections of guestId in all albums
+func (dao *ProofPG) ListByGuest(guestId uuid.UUID) ([]*ProofSelection, error) {
+	ret := []*ProofSelection{}
+	err := dao.db.Select(&ret, "SELECT * FROM proofselection WHERE guestId = $1 ORDER BY albumId, updated", guestId)
+	return ret, err
+}
+
+func (dao *ProofPG) Selections(albumId, guestId uuid.UUID) ([]*ProofSelection, error) {
+	ret := []*ProofSelection{}
+	const stmt = "SELECT * FROM proofselection WHERE albumId = $1 AND guestId = $2 ORDER BY updated"
+	err := dao.db.Select(&ret, stmt, albumId, guestId)
+	return ret, err
+}
+
+// Set adds or replaces the selection of a photo
+func (dao *ProofPG) Set(sel *ProofSelection) error {
+	const stmt = `INSERT INTO proofselection (albumId, guestId, photoId, selected, note, updated)
+	VALUES (:albumid, :guestid, :photoid, :selected, :note, :updated)
+	ON CONFLICT (albumId, guestId, photoId) DO UPDATE SET selected = :selected, note = :note, updated = :updated`
+	_, err := dao.db.NamedExec(stmt, sel)
+	return err
+}
+
+func (dao *ProofPG) Submission(albumId, guestId uuid.UUID) (*ProofSubmission, error) {
+	ret := ProofSubmission{}
+	const stmt = "SELECT * FROM proofsubmission WHERE albumId = $1 AND guestId = $2"
+	if err := dao.db.Get(&ret, stmt, albumId, guestId); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *ProofPG) Submissions(albumId uuid.UUID) ([]*ProofSubmission, error) {
+	ret := []*ProofSubmission{}
+	err := dao.db.Select(&ret, "SELECT * FROM proofsubmission WHERE albumId = $1 ORDER BY submitted", albumId)
+	return ret, err
+}
+
+func (dao *ProofPG) Submit(sub *ProofSubmission) error {
+	const stmt = `INSERT INTO proofsubmission (albumId, guestId, submitted) VALUES (:albumid, :guestid, :submitted)
+	ON CONFLICT (albumId, guestId) DO UPDATE SET submitted = :submitted`
+	_, err := dao.db.NamedExec(stmt, sub)
+	return err
+}
+
+// Unlock removes the submission of guestId in albumId so that the guest can change their selection
+func (dao *ProofPG) Unlock(albumId, guestId uuid.UUID) error {
+	_, err := dao.db.Exec("DELETE FROM proofsubmission WHERE albumId = $1 AND guestId = $2", albumId, guestId)
+	return err
+}
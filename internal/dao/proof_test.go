@@ -0,0 +1,68 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestProofing(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	albumId, guestId, other := uuid.New(), uuid.New(), uuid.New()
+	photos := []uuid.UUID{uuid.New(), uuid.New()}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	selections := []*ProofSelection{
+		{AlbumId: albumId, GuestId: guestId, PhotoId: photos[0], Selected: true, Updated: now},
+		{AlbumId: albumId, GuestId: guestId, PhotoId: photos[1], Note: "maybe", Updated: now},
+		{AlbumId: albumId, GuestId: other, PhotoId: photos[0], Selected: true, Updated: now},
+	}
+	for _, sel := range selections {
+		if err := pgdb.Proof.Set(sel); err != nil {
+			t.Fatalf("could not set selection: %s", err.Error())
+		}
+	}
+	if cnt, err := pgdb.Proof.CountSelected(albumId, guestId); err != nil || cnt != 1 {
+		t.Errorf("expected 1 selected photo got %d %v", cnt, err)
+	}
+	//setting a selection again replaces it
+	selections[1].Selected, selections[1].Note = true, "crop tighter"
+	if err := pgdb.Proof.Set(selections[1]); err != nil {
+		t.Errorf("could not update selection: %s", err.Error())
+	}
+	if sels, err := pgdb.Proof.Selections(albumId, guestId); err != nil || len(sels) != 2 {
+		t.Errorf("expected 2 selections got %v %v", sels, err)
+	} else if cnt, _ := pgdb.Proof.CountSelected(albumId, guestId); cnt != 2 {
+		t.Errorf("expected 2 selected photos got %d", cnt)
+	}
+	if sels, err := pgdb.Proof.ListByAlbum(albumId); err != nil || len(sels) != 3 {
+		t.Errorf("expected 3 selections got %v %v", sels, err)
+	}
+
+	if _, err := pgdb.Proof.Submission(albumId, guestId); err == nil {
+		t.Errorf("expected no submission")
+	}
+	if err := pgdb.Proof.Submit(&ProofSubmission{AlbumId: albumId, GuestId: guestId, Submitted: now}); err != nil {
+		t.Errorf("could not submit: %s", err.Error())
+	}
+	if sub, err := pgdb.Proof.Submission(albumId, guestId); err != nil || !sub.Submitted.Equal(now) {
+		t.Errorf("expected submission got %v %v", sub, err)
+	}
+	if subs, err := pgdb.Proof.Submissions(albumId); err != nil || len(subs) != 1 {
+		t.Errorf("expected 1 submission got %v %v", subs, err)
+	}
+	if err := pgdb.Proof.Unlock(albumId, guestId); err != nil {
+		t.Errorf("could not unlock: %s", err.Error())
+	}
+	if _, err := pgdb.Proof.Submission(albumId, guestId); err == nil {
+		t.Errorf("expected submission to be removed")
+	}
+
+	if err := pgdb.Proof.DeleteByGuest(guestId); err != nil {
+		t.Errorf("could not delete selections: %s", err.Error())
+	}
+	if sels, err := pgdb.Proof.ListByGuest(guestId); err != nil || len(sels) != 0 {
+		t.Errorf("expected no selections got %v %v", sels, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -13,7 +13,7 @@ type ReactionPG struct {
 
 func NewReactionPG(db *sqlx.DB) *ReactionPG {
 	fields := getStructFields(&Reaction{})
-	stmt := buildInsertNamed("reaction", fields)
+	stmt := buildInsertNamed("reaction", fields) + " ON CONFLICT DO NOTHING"
 	return &ReactionPG{db, fields, stmt}
 }
 
@@ -22,8 +22,36 @@ func (dao *ReactionPG) Add(r *Reaction) error {
 	return err
 }
 
+// Counts returns the number of reactions of each kind for photoIds. Photos without reactions are left out
+func (dao *ReactionPG) Counts(photoIds ...uuid.UUID) (map[uuid.UUID]map[string]int, error) {
+	ret := make(map[uuid.UUID]map[string]int)
+	if len(photoIds) == 0 {
+		return ret, nil
+	}
+	type count struct {
+		PhotoId uuid.UUID
+		Kind    string
+		Count   int
+	}
+	query, args, err := sqlx.In("SELECT photoId, kind, count(*) AS count FROM reaction WHERE photoId IN (?) GROUP BY photoId, kind", photoIds)
+	if err != nil {
+		return nil, err
+	}
+	counts := []count{}
+	if err = dao.db.Select(&counts, dao.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if ret[c.PhotoId] == nil {
+			ret[c.PhotoId] = make(map[string]int)
+		}
+		ret[c.PhotoId][c.Kind] = c.Count
+	}
+	return ret, nil
+}
+
 func (dao *ReactionPG) Delete(r *Reaction) error {
-	_, err := dao.db.Exec("DELETE from reaction WHERE guestId = $1 AND photoId = $2", r.GuestId, r.PhotoId)
+	_, err := dao.db.Exec("DELETE from reaction WHERE guestId = $1 AND photoId = $2 AND kind = $3", r.GuestId, r.PhotoId, r.Kind)
 	return err
 }
 
@@ -42,9 +70,18 @@ func (dao *ReactionPG) List() ([]*Reaction, error) {
 	return ret, err
 }
 
-func (dao *ReactionPG) ListByGuest(guestId uuid.UUID) ([]uuid.UUID, error) {
-	ret := []uuid.UUID{}
-	err := dao.db.Select(&ret, "SELECT photoId FROM reaction WHERE guestId = $1", guestId)
+// ListByGuest returns the reactions of guestId of any of kinds, or of all kinds if none are given
+func (dao *ReactionPG) ListByGuest(guestId uuid.UUID, kinds ...string) ([]*Reaction, error) {
+	ret := []*Reaction{}
+	if len(kinds) == 0 {
+		err := dao.db.Select(&ret, "SELECT * FROM reaction WHERE guestId = $1", guestId)
+		return ret, err
+	}
+	query, args, err := sqlx.In("SELECT * FROM reaction WHERE guestId = ? AND kind IN (?)", guestId, kinds)
+	if err != nil {
+		return nil, err
+	}
+	err = dao.db.Select(&ret, dao.db.Rebind(query), args...)
 	return ret, err
 }
 
@@ -55,8 +92,9 @@ func (dao *ReactionPG) ListByPhoto(photoId uuid.UUID) ([]*GuestReaction, error)
 	return ret, err
 }
 
-func (dao *ReactionPG) Has(guestId uuid.UUID, photoId uuid.UUID) bool {
-	if rows, err := dao.db.Query("SELECT 1 FROM reaction WHERE guestId = $1 AND photoId = $2", guestId, photoId); err == nil {
+func (dao *ReactionPG) Has(r *Reaction) bool {
+	const stmt = "SELECT 1 FROM reaction WHERE guestId = $1 AND photoId = $2 AND kind = $3"
+	if rows, err := dao.db.Query(stmt, r.GuestId, r.PhotoId, r.Kind); err == nil {
 		defer rows.Close()
 		return rows.Next()
 	} else {
@@ -0,0 +1,49 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestReactionKinds(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	guestId, photoId, other := uuid.New(), uuid.New(), uuid.New()
+	reactions := []*Reaction{
+		{GuestId: guestId, PhotoId: photoId, Kind: "like"},
+		{GuestId: guestId, PhotoId: photoId, Kind: "heart"},
+		{GuestId: uuid.New(), PhotoId: photoId, Kind: "heart"},
+		{GuestId: guestId, PhotoId: other, Kind: "like"},
+	}
+	for _, r := range reactions {
+		if err := pgdb.Reaction.Add(r); err != nil {
+			t.Fatalf("could not add reaction: %s", err.Error())
+		}
+	}
+	//adding the same reaction again is a no-op
+	if err := pgdb.Reaction.Add(reactions[0]); err != nil {
+		t.Errorf("could not add duplicate reaction: %s", err.Error())
+	}
+	if !pgdb.Reaction.Has(reactions[1]) {
+		t.Errorf("expected heart reaction")
+	}
+
+	counts, err := pgdb.Reaction.Counts(photoId, other)
+	if err != nil {
+		t.Fatalf("could not count reactions: %s", err.Error())
+	}
+	if counts[photoId]["like"] != 1 || counts[photoId]["heart"] != 2 || counts[other]["like"] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+	if likes, err := pgdb.Reaction.ListByGuest(guestId, "like"); err != nil || len(likes) != 2 {
+		t.Errorf("expected 2 likes got %v %v", likes, err)
+	}
+
+	if err = pgdb.Reaction.Delete(reactions[1]); err != nil {
+		t.Errorf("could not delete reaction: %s", err.Error())
+	}
+	if pgdb.Reaction.Has(reactions[1]) || !pgdb.Reaction.Has(reactions[0]) {
+		t.Errorf("expected only the heart reaction to be deleted")
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
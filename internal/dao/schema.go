@@ -1,5 +1,160 @@
 package dao
 
+const schemaV3toV4 = `
+	ALTER TABLE usert ADD COLUMN IF NOT EXISTS drivePageToken TEXT NOT NULL DEFAULT '';
+	ALTER TABLE img ADD COLUMN IF NOT EXISTS sourcePath TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS sourceModified TIMESTAMP NOT NULL DEFAULT 'epoch',
+		ADD COLUMN IF NOT EXISTS favorite BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS favoriteEdited BOOLEAN NOT NULL DEFAULT false;
+	CREATE UNIQUE INDEX IF NOT EXISTS md5_idx ON img (md5);
+	ALTER TABLE comment ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'APPROVED',
+		ADD COLUMN IF NOT EXISTS parentId INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS owner BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS edited TIMESTAMP NOT NULL DEFAULT 'epoch',
+		ADD COLUMN IF NOT EXISTS flag TEXT NOT NULL DEFAULT '';
+	ALTER TABLE guest ADD COLUMN IF NOT EXISTS banned BOOLEAN NOT NULL DEFAULT false;
+	ALTER TABLE album ADD COLUMN IF NOT EXISTS proofing BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS maxSelections INTEGER NOT NULL DEFAULT 0;
+	UPDATE reaction SET kind = 'like' WHERE kind IS NULL;
+	ALTER TABLE reaction DROP CONSTRAINT IF EXISTS reaction_pkey, ADD PRIMARY KEY (guestId, photoId, kind);
+	CREATE TABLE IF NOT EXISTS drivesource (
+		id UUID PRIMARY KEY,
+		folderId TEXT NOT NULL,
+		folderName TEXT NOT NULL,
+		recursive BOOLEAN NOT NULL,
+		albums BOOLEAN NOT NULL,
+		CONSTRAINT drivesource_folder UNIQUE (folderId)
+	);
+
+	CREATE TABLE IF NOT EXISTS drivefolder (
+		id TEXT PRIMARY KEY,
+		sourceId UUID NOT NULL,
+		parentId TEXT NOT NULL,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		albumId UUID NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS syncrun (
+		id UUID PRIMARY KEY,
+		started TIMESTAMP NOT NULL,
+		finished TIMESTAMP NOT NULL,
+		state TEXT NOT NULL,
+		added INTEGER NOT NULL,
+		error TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS started_idx ON syncrun (started);
+
+	CREATE TABLE IF NOT EXISTS job (
+		id UUID PRIMARY KEY,
+		kind TEXT NOT NULL,
+		state TEXT NOT NULL,
+		params TEXT NOT NULL,
+		numFiles INTEGER NOT NULL,
+		numProcessed INTEGER NOT NULL,
+		percent INTEGER NOT NULL,
+		results TEXT NOT NULL,
+		errorCode INTEGER NOT NULL,
+		errorMessage TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		started TIMESTAMP NOT NULL,
+		finished TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS created_idx ON job (created);
+
+	CREATE TABLE IF NOT EXISTS webhook (
+		id UUID PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		active BOOLEAN NOT NULL,
+		created TIMESTAMP NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS webhookdelivery (
+		id UUID PRIMARY KEY,
+		webhookId UUID NOT NULL,
+		eventType TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		statusCode INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		lastAttempt TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS webhookId_idx ON webhookdelivery (webhookId, created);
+
+	CREATE TABLE IF NOT EXISTS notification (
+		id SERIAL PRIMARY KEY,
+		kind TEXT NOT NULL,
+		photoId UUID NOT NULL,
+		message TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		sent BOOLEAN NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS milestone (
+		photoId UUID,
+		kind TEXT,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (photoId, kind)
+	);
+
+	CREATE TABLE IF NOT EXISTS guesttoken (
+		hash TEXT PRIMARY KEY,
+		guestId UUID NOT NULL,
+		purpose TEXT NOT NULL,
+		email TEXT NOT NULL,
+		created TIMESTAMP NOT NULL,
+		expires TIMESTAMP NOT NULL,
+		used BOOLEAN NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS guestId_idx ON guesttoken (guestId, created);
+
+	CREATE TABLE IF NOT EXISTS collection (
+		id UUID PRIMARY KEY,
+		guestId UUID NOT NULL,
+		name TEXT NOT NULL,
+		shared BOOLEAN NOT NULL,
+		created TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS collection_guestId_idx ON collection (guestId);
+
+	CREATE TABLE IF NOT EXISTS collectionphotos (
+		collectionId UUID,
+		photoId UUID,
+		added TIMESTAMP NOT NULL,
+		PRIMARY KEY (collectionId, photoId)
+	);
+
+	CREATE TABLE IF NOT EXISTS proofselection (
+		albumId UUID,
+		guestId UUID,
+		photoId UUID,
+		selected BOOLEAN NOT NULL,
+		note TEXT NOT NULL,
+		updated TIMESTAMP NOT NULL,
+		PRIMARY KEY (albumId, guestId, photoId)
+	);
+
+	CREATE TABLE IF NOT EXISTS proofsubmission (
+		albumId UUID,
+		guestId UUID,
+		submitted TIMESTAMP NOT NULL,
+		PRIMARY KEY (albumId, guestId)
+	);
+
+	INSERT INTO drivesource (id, folderId, folderName, recursive, albums)
+		SELECT gen_random_uuid(), driveFolderId, driveFolderName, false, false FROM usert WHERE driveFolderId <> ''
+		ON CONFLICT DO NOTHING;
+`
 const schemaV2toV3 = `
 	ALTER TABLE album ADD COLUMN code TEXT, ADD COLUMN orderBy INTEGER;
 	UPDATE album SET code = '', orderBy = 0;
@@ -8,7 +163,7 @@ const schemaV2toV3 = `
 const schemaV1toV2 = `
 	ALTER TABLE albumphotos ADD COLUMN photoOrder INTEGER;
 `
-const schemaV3 = `
+const schemaV4 = `
 CREATE TABLE IF NOT EXISTS album (
 	Id UUID,
 	name TEXT,
@@ -16,6 +171,8 @@ CREATE TABLE IF NOT EXISTS album (
 	coverPic TEXT NOT NULL,
 	code TEXT NOT NULL,
 	orderBy INTEGER NOT NULL,
+	proofing BOOLEAN NOT NULL,
+	maxSelections INTEGER NOT NULL,
 	CONSTRAINT album_name UNIQUE (name)
 );
 
@@ -70,11 +227,63 @@ CREATE TABLE IF NOT EXISTS comment (
 	guestId UUID NOT NULL,
 	photoId UUID NOT NULL,
 	time TIMESTAMP NOT NULL,
-	body TEXT NOT NULL
+	body TEXT NOT NULL,
+	state TEXT NOT NULL,
+	parentId INTEGER NOT NULL,
+	owner BOOLEAN NOT NULL,
+	edited TIMESTAMP NOT NULL,
+	flag TEXT NOT NULL
 );
 
 CREATE INDEX IF NOT EXISTS driveId_idx ON comment (photoId);
 
+CREATE TABLE IF NOT EXISTS drivesource (
+	id UUID PRIMARY KEY,
+	folderId TEXT NOT NULL,
+	folderName TEXT NOT NULL,
+	recursive BOOLEAN NOT NULL,
+	albums BOOLEAN NOT NULL,
+	CONSTRAINT drivesource_folder UNIQUE (folderId)
+);
+
+CREATE TABLE IF NOT EXISTS drivefolder (
+	id TEXT PRIMARY KEY,
+	sourceId UUID NOT NULL,
+	parentId TEXT NOT NULL,
+	name TEXT NOT NULL,
+	path TEXT NOT NULL,
+	albumId UUID NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS job (
+	id UUID PRIMARY KEY,
+	kind TEXT NOT NULL,
+	state TEXT NOT NULL,
+	params TEXT NOT NULL,
+	numFiles INTEGER NOT NULL,
+	numProcessed INTEGER NOT NULL,
+	percent INTEGER NOT NULL,
+	results TEXT NOT NULL,
+	errorCode INTEGER NOT NULL,
+	errorMessage TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	started TIMESTAMP NOT NULL,
+	finished TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS created_idx ON job (created);
+
+CREATE TABLE IF NOT EXISTS syncrun (
+	id UUID PRIMARY KEY,
+	started TIMESTAMP NOT NULL,
+	finished TIMESTAMP NOT NULL,
+	state TEXT NOT NULL,
+	added INTEGER NOT NULL,
+	error TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS started_idx ON syncrun (started);
+
 CREATE TABLE IF NOT EXISTS exifdata (
 	id UUID PRIMARY KEY,
 	data TEXT NOT NULL
@@ -86,6 +295,7 @@ CREATE TABLE IF NOT EXISTS guest (
 	email TEXT NOT NULL,
 	verified BOOLEAN NOT NULL,
 	verifytime TIMESTAMP NOT NULL,
+	banned BOOLEAN NOT NULL,
 	CONSTRAINT guest_email UNIQUE (email),
 	CONSTRAINT guest_name UNIQUE (name)
 );
@@ -94,7 +304,7 @@ CREATE TABLE IF NOT EXISTS reaction (
 	guestId UUID,
 	photoId UUID,
 	kind TEXT,
-	PRIMARY KEY (guestId, photoId)
+	PRIMARY KEY (guestId, photoId, kind)
 );
 
 CREATE TABLE IF NOT EXISTS img (
@@ -106,10 +316,15 @@ CREATE TABLE IF NOT EXISTS img (
 	sourceDate TIMESTAMP,
 	uploadDate TIMESTAMP NOT NULL,
 	originalDate TIMESTAMP NOT NULL,
+	sourcePath TEXT NOT NULL DEFAULT '',
+	sourceModified TIMESTAMP NOT NULL DEFAULT 'epoch',
 	fileName TEXT NOT NULL,
 	title TEXT NOT NULL,
 	keywords TEXT,
 	description TEXT,
+	favorite BOOLEAN NOT NULL DEFAULT false,
+	edited BOOLEAN NOT NULL DEFAULT false,
+	favoriteEdited BOOLEAN NOT NULL DEFAULT false,
 	cameraMake TEXT NOT NULL,
 	cameraModel TEXT NOT NULL,
 	lensMake TEXT,
@@ -123,6 +338,8 @@ CREATE TABLE IF NOT EXISTS img (
 	height INTEGER NOT NULL
 );
 
+CREATE UNIQUE INDEX IF NOT EXISTS md5_idx ON img (md5);
+
 CREATE TABLE IF NOT EXISTS usert (
 	id INT PRIMARY KEY,
 	name TEXT NOT NULL,
@@ -130,7 +347,94 @@ CREATE TABLE IF NOT EXISTS usert (
 	pic TEXT NOT NULL,
 	driveFolderId TEXT NOT NULL,
 	driveFolderName TEXT NOT NULL,
-	config TEXT NOT NULL
+	config TEXT NOT NULL,
+	drivePageToken TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS webhook (
+	id UUID PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	active BOOLEAN NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhookdelivery (
+	id UUID PRIMARY KEY,
+	webhookId UUID NOT NULL,
+	eventType TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	state TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	statusCode INTEGER NOT NULL,
+	error TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	lastAttempt TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhookId_idx ON webhookdelivery (webhookId, created);
+
+CREATE TABLE IF NOT EXISTS notification (
+	id SERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	photoId UUID NOT NULL,
+	message TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	sent BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS milestone (
+	photoId UUID,
+	kind TEXT,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (photoId, kind)
+);
+
+CREATE TABLE IF NOT EXISTS guesttoken (
+	hash TEXT PRIMARY KEY,
+	guestId UUID NOT NULL,
+	purpose TEXT NOT NULL,
+	email TEXT NOT NULL,
+	created TIMESTAMP NOT NULL,
+	expires TIMESTAMP NOT NULL,
+	used BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS guestId_idx ON guesttoken (guestId, created);
+
+CREATE TABLE IF NOT EXISTS collection (
+	id UUID PRIMARY KEY,
+	guestId UUID NOT NULL,
+	name TEXT NOT NULL,
+	shared BOOLEAN NOT NULL,
+	created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS collection_guestId_idx ON collection (guestId);
+
+CREATE TABLE IF NOT EXISTS collectionphotos (
+	collectionId UUID,
+	photoId UUID,
+	added TIMESTAMP NOT NULL,
+	PRIMARY KEY (collectionId, photoId)
+);
+
+CREATE TABLE IF NOT EXISTS proofselection (
+	albumId UUID,
+	guestId UUID,
+	photoId UUID,
+	selected BOOLEAN NOT NULL,
+	note TEXT NOT NULL,
+	updated TIMESTAMP NOT NULL,
+	PRIMARY KEY (albumId, guestId, photoId)
+);
+
+CREATE TABLE IF NOT EXISTS proofsubmission (
+	albumId UUID,
+	guestId UUID,
+	submitted TIMESTAMP NOT NULL,
+	PRIMARY KEY (albumId, guestId)
 );
 
 CREATE TABLE version (
@@ -145,17 +449,30 @@ INSERT INTO version (versionId,description) VALUES (0,'no version set') ON CONFL
 INSERT INTO usert (id, name, bio, pic, driveFolderId, driveFolderName, config) VALUES (23657, '', '', '', '','','{}') ON CONFLICT (id) DO NOTHING;
 `
 
-const deleteSchemaV3 = `
+const deleteSchemaV4 = `
 DROP TABLE IF EXISTS album;
 DROP TABLE IF EXISTS albumphotos;
 DROP TABLE IF EXISTS camera;
 DROP TABLE IF EXISTS comment;
+DROP TABLE IF EXISTS drivesource;
+DROP TABLE IF EXISTS drivefolder;
 DROP TABLE IF EXISTS exifdata;
 DROP TABLE IF EXISTS guest;
+DROP TABLE IF EXISTS job;
+DROP TABLE IF EXISTS syncrun;
 DROP TABLE IF EXISTS reaction;
 DROP TABLE IF EXISTS img;
 DROP TABLE IF EXISTS usert;
 DROP TABLE IF EXISTS version;
+DROP TABLE IF EXISTS webhook;
+DROP TABLE IF EXISTS webhookdelivery;
+DROP TABLE IF EXISTS notification;
+DROP TABLE IF EXISTS milestone;
+DROP TABLE IF EXISTS guesttoken;
+DROP TABLE IF EXISTS collection;
+DROP TABLE IF EXISTS collectionphotos;
+DROP TABLE IF EXISTS proofselection;
+DROP TABLE IF EXISTS proofsubmission;
 `
 
 const deleteSchemaV0 = `
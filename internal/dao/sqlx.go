@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"reflect"
@@ -86,13 +87,23 @@ func containsField(str string, strs []string) bool {
 	return false
 }
 
+// getStructFields returns the lower case field names of p, skipping fields tagged with db:"-"
 func getStructFields(p interface{}) []string {
 	val := reflect.Indirect(reflect.ValueOf(p))
-	fields := make([]string, val.Type().NumField())
-	for idx := 0; idx < len(fields); idx++ {
+	fields := make([]string, 0, val.Type().NumField())
+	for idx := 0; idx < val.Type().NumField(); idx++ {
+		f := val.Type().Field(idx)
+		if f.Tag.Get("db") == "-" {
+			continue
+		}
 		//fields[idx] = lowerFirst(val.Type().Field(idx).Name)
-		fields[idx] = strings.ToLower(val.Type().Field(idx).Name)
-		//fields[idx] = val.Type().Field(idx).Name
+		fields = append(fields, strings.ToLower(f.Name))
 	}
 	return fields
 }
+
+// isUniqueViolation reports whether err is a postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
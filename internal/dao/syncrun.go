@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type SyncRunPG struct {
+	db         *sqlx.DB
+	insertStmt string
+	updateStmt string
+}
+
+func NewSyncRunPG(db *sqlx.DB) *SyncRunPG {
+	fields := getStructFields(&SyncRun{})
+	return &SyncRunPG{db, buildInsertNamed("syncrun", fields), buildUpdateNamed2("syncrun", fields, "id")}
+}
+
+func (dao *SyncRunPG) Add(run *SyncRun) error {
+	run.Id = uuid.New()
+	_, err := dao.db.NamedExec(dao.insertStmt, run)
+	return err
+}
+
+// Last returns the most recently started run
+func (dao *SyncRunPG) Last() (*SyncRun, error) {
+	ret := SyncRun{}
+	if err := dao.db.Get(&ret, "SELECT * FROM syncrun ORDER BY started DESC LIMIT 1"); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// List returns the latest limit runs, most recent first
+func (dao *SyncRunPG) List(limit int) ([]*SyncRun, error) {
+	ret := []*SyncRun{}
+	err := dao.db.Select(&ret, "SELECT * FROM syncrun ORDER BY started DESC LIMIT $1", limit)
+	return ret, err
+}
+
+func (dao *SyncRunPG) Update(run *SyncRun) error {
+	_, err := dao.db.NamedExec(dao.updateStmt, run)
+	return err
+}
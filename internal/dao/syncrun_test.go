@@ -0,0 +1,35 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncRuns(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	first := SyncRun{Started: now.Add(-time.Hour), Finished: now.Add(-time.Hour), State: "SKIPPED"}
+	run := SyncRun{Started: now, State: "STARTED"}
+	for _, r := range []*SyncRun{&first, &run} {
+		if err := pgdb.SyncRun.Add(r); err != nil {
+			t.Fatalf("could not add sync run: %s", err.Error())
+		}
+	}
+
+	run.Finished, run.State, run.Added = now.Add(time.Minute), "FINISHED", 3
+	if err := pgdb.SyncRun.Update(&run); err != nil {
+		t.Errorf("could not update sync run: %s", err.Error())
+	}
+	if last, err := pgdb.SyncRun.Last(); err != nil {
+		t.Errorf("could not get last sync run: %s", err.Error())
+	} else if last.Id != run.Id || last.State != "FINISHED" || last.Added != 3 {
+		t.Errorf("expected %v got %v", run, last)
+	}
+	if runs, err := pgdb.SyncRun.List(10); err != nil {
+		t.Errorf("could not list sync runs: %s", err.Error())
+	} else if len(runs) != 2 || runs[1].Id != first.Id {
+		t.Errorf("expected 2 runs, latest first got %v", runs)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
@@ -6,16 +6,24 @@ import (
 	"time"
 )
 
-const DbVersion = 3
-const DbDescription = "Version 3 adds simple access control to albums and makes public access based on a default photo stream album"
+const DbVersion = 4
+const DbDescription = "Version 4 adds incremental sync of multiple Google Drive folders with their folder tree " +
+	"and Drive metadata, unique photo md5s, favorite and edited photos, background jobs, sync runs, webhooks, " +
+	"owner notifications and reaction milestones, comment moderation and threads, reaction kinds, " +
+	"guest login tokens and bans, guest collections and album proofing"
 
+// Album is a named set of photos. An album with a Code is only shown to those who know it.
+// In a Proofing album guests select photos and submit their selection, at most MaxSelections
+// photos each if MaxSelections is set
 type Album struct {
-	Id          uuid.UUID  `json:"id"`
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	CoverPic    string     `json:"coverPic"`
-	Code        string     `json:"code"`
-	OrderBy     PhotoOrder `json:"orderBy"`
+	Id            uuid.UUID  `json:"id"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description"`
+	CoverPic      string     `json:"coverPic"`
+	Code          string     `json:"code"`
+	OrderBy       PhotoOrder `json:"orderBy"`
+	Proofing      bool       `json:"proofing"`
+	MaxSelections int        `json:"maxSelections"`
 }
 
 type Camera struct {
@@ -55,12 +63,95 @@ type Camera struct {
 	Image              string  `json:"image"`
 }
 
-type Comment struct {
-	Id      int       `json:"id"`
+// Collection is a guest's named selection of photos. The owner can only see shared collections
+type Collection struct {
+	Id      uuid.UUID `json:"id"`
 	GuestId uuid.UUID `json:"-"`
-	PhotoId uuid.UUID `json:"photoId"`
-	Time    time.Time `json:"time"`
-	Body    string    `json:"body"`
+	Name    string    `json:"name"`
+	Shared  bool      `json:"shared"`
+	Created time.Time `json:"created"`
+}
+
+// Comment states. Only approved comments are shown to guests. Held comments look like spam and
+// wait for the owner like pending ones
+const (
+	CommentPending  = "PENDING"
+	CommentHeld     = "HELD"
+	CommentApproved = "APPROVED"
+	CommentRejected = "REJECTED"
+	CommentSpam     = "SPAM"
+)
+
+// Comment is a comment by a guest or, if Owner is set, a reply by the owner. ParentId is the
+// id of the comment that this is a reply to, 0 for top level comments. Edited is when the
+// comment was last edited and is before Time if it never was. Flag is why the comment was held
+// or marked as spam when it was posted
+type Comment struct {
+	Id       int       `json:"id"`
+	GuestId  uuid.UUID `json:"-"`
+	PhotoId  uuid.UUID `json:"photoId"`
+	Time     time.Time `json:"time"`
+	Body     string    `json:"body"`
+	State    string    `json:"state"`
+	ParentId int       `json:"parentId"`
+	Owner    bool      `json:"owner"`
+	Edited   time.Time `json:"edited"`
+	Flag     string    `json:"flag,omitempty"`
+}
+
+// AuthoredComment is a comment with the name and email of the guest that wrote it. Both are
+// empty for comments by the owner
+type AuthoredComment struct {
+	Comment
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// DriveFolder is a subfolder of a DriveSource. AlbumId is uuid.Nil until the folder has
+// been mapped to an album
+type DriveFolder struct {
+	Id       string    `json:"id"`
+	SourceId uuid.UUID `json:"sourceId"`
+	ParentId string    `json:"parentId"`
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	AlbumId  uuid.UUID `json:"albumId"`
+}
+
+// DriveSource is a Drive folder that photos are imported from
+type DriveSource struct {
+	Id         uuid.UUID `json:"id"`
+	FolderId   string    `json:"folderId"`
+	FolderName string    `json:"folderName"`
+	Recursive  bool      `json:"recursive"`
+	Albums     bool      `json:"albums"`
+}
+
+// Job is the stored state of a background job. Params and Results are json encoded
+type Job struct {
+	Id           uuid.UUID `json:"id"`
+	Kind         string    `json:"kind"`
+	State        string    `json:"state"`
+	Params       string    `json:"-"`
+	NumFiles     int       `json:"numFiles"`
+	NumProcessed int       `json:"numProcessed"`
+	Percent      int       `json:"percent"`
+	Results      string    `json:"-"`
+	ErrorCode    int       `json:"errorCode"`
+	ErrorMessage string    `json:"errorMessage"`
+	Created      time.Time `json:"created"`
+	Started      time.Time `json:"started"`
+	Finished     time.Time `json:"finished"`
+}
+
+// SyncRun is the outcome of a scheduled Drive sync
+type SyncRun struct {
+	Id       uuid.UUID `json:"id"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	State    string    `json:"state"`
+	Added    int       `json:"added"`
+	Error    string    `json:"error,omitempty"`
 }
 
 type Exif struct {
@@ -74,6 +165,33 @@ type Guest struct {
 	Name       string    `json:"name"`
 	Verified   bool      `json:"verified"`
 	VerifyTime time.Time `json:"verifyTime"`
+	Banned     bool      `json:"banned"`
+}
+
+// GuestActivity is a guest with the number of comments and reactions the guest has made
+type GuestActivity struct {
+	Guest
+	Comments  int `json:"comments"`
+	Reactions int `json:"reactions"`
+}
+
+// Guest token purposes
+const (
+	TokenVerify = "VERIFY"
+	TokenLogin  = "LOGIN"
+	TokenEmail  = "EMAIL"
+)
+
+// GuestToken is a single use token that logs a guest in and verifies their email. Only the
+// hash of the token is stored. Email is the new address of an email change token
+type GuestToken struct {
+	Hash    string    `json:"-"`
+	GuestId uuid.UUID `json:"-"`
+	Purpose string    `json:"purpose"`
+	Email   string    `json:"email,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Used    bool      `json:"used"`
 }
 
 type GuestReaction struct {
@@ -82,6 +200,17 @@ type GuestReaction struct {
 	Kind  string `json:"kind"`
 }
 
+// Notification is a message to the owner about guest activity. Notifications are kept until
+// they have been sent, either immediately or as part of a digest
+type Notification struct {
+	Id      int       `json:"id"`
+	Kind    string    `json:"kind"`
+	PhotoId uuid.UUID `json:"photoId"`
+	Message string    `json:"message"`
+	Created time.Time `json:"created"`
+	Sent    bool      `json:"sent"`
+}
+
 type Photo struct {
 	Id           uuid.UUID `json:"id"`
 	Md5          string    `json:"md5"`
@@ -91,11 +220,19 @@ type Photo struct {
 	SourceDate   time.Time `json:"sourceDate"`
 	UploadDate   time.Time `json:"uploadDate"`
 	OriginalDate time.Time `json:"originalDate"`
+	//folder path of the source file and when it was last modified (Drive only)
+	SourcePath     string    `json:"sourcePath,omitempty"`
+	SourceModified time.Time `json:"sourceModified"`
 
 	FileName    string `json:"fileName"`
 	Title       string `json:"title"`
 	Keywords    string `json:"keywords"`
 	Description string `json:"description"`
+	Favorite    bool   `json:"favorite"`
+	//set when title, description or keywords have been edited locally
+	Edited bool `json:"edited"`
+	//set when favorite has been changed locally
+	FavoriteEdited bool `json:"favoriteEdited"`
 
 	CameraMake    string `json:"cameraMake"`
 	CameraModel   string `json:"cameraModel"`
@@ -110,6 +247,9 @@ type Photo struct {
 	Width    uint    `json:"width"`
 	Height   uint    `json:"height"`
 	//Private  bool    `json:"private"`
+
+	//number of guest reactions per kind, not stored with the photo
+	Reactions map[string]int `json:"reactions,omitempty" db:"-"`
 }
 
 type PhotoFilter struct {
@@ -131,10 +271,28 @@ type Range struct {
 	Limit  int
 }
 
+// ProofSelection is a guest's choice and note for a photo in a proofing album
+type ProofSelection struct {
+	AlbumId  uuid.UUID `json:"albumId"`
+	GuestId  uuid.UUID `json:"-"`
+	PhotoId  uuid.UUID `json:"photoId"`
+	Selected bool      `json:"selected"`
+	Note     string    `json:"note"`
+	Updated  time.Time `json:"updated"`
+}
+
+// ProofSubmission is when a guest submitted their selection in a proofing album. A submitted
+// selection is locked until the owner unlocks it
+type ProofSubmission struct {
+	AlbumId   uuid.UUID `json:"albumId"`
+	GuestId   uuid.UUID `json:"-"`
+	Submitted time.Time `json:"submitted"`
+}
+
 type Reaction struct {
-	GuestId uuid.UUID
-	PhotoId uuid.UUID
-	Kind    string
+	GuestId uuid.UUID `json:"-"`
+	PhotoId uuid.UUID `json:"photoId"`
+	Kind    string    `json:"kind"`
 }
 
 const SourceGoogle = "gdrive"
@@ -147,9 +305,35 @@ type User struct {
 	DriveFolderId   string `json:"driveFolderId,omitempty"`
 	DriveFolderName string `json:"driveFolderName,omitempty"`
 	Config          string `json:"config,omitempty"`
+	DrivePageToken  string `json:"-"`
 }
 
 type Version struct {
 	VersionId   int    `json:"versionId"`
 	Description string `json:"description"`
 }
+
+// Webhook receives library events. Events is a comma separated list of event types
+// (e.g. photo.added) or topics (e.g. album). An empty list matches all events
+type Webhook struct {
+	Id      uuid.UUID `json:"id"`
+	Url     string    `json:"url"`
+	Secret  string    `json:"-"`
+	Events  string    `json:"events"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+// WebhookDelivery is the delivery of a single event to a webhook
+type WebhookDelivery struct {
+	Id          uuid.UUID `json:"id"`
+	WebhookId   uuid.UUID `json:"webhookId"`
+	EventType   string    `json:"eventType"`
+	Payload     string    `json:"payload"`
+	State       string    `json:"state"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"statusCode"`
+	Error       string    `json:"error"`
+	Created     time.Time `json:"created"`
+	LastAttempt time.Time `json:"lastAttempt"`
+}
@@ -21,14 +21,19 @@ func NewVersionPG(db *sqlx.DB) *VersionPG {
 	return &VersionPG{db, fields, uStmt, gStmt}
 }
 
-func (dao *VersionPG) Update() (*Version, error) {
-	v := Version{DbVersion, DbDescription}
+// Set sets the version of the db, e.g. after one step of an upgrade
+func (dao *VersionPG) Set(versionId int, description string) (*Version, error) {
+	v := Version{versionId, description}
 	if _, err := dao.db.NamedExec(dao.updateVersionStmt, &v); err != nil {
 		return nil, err
 	}
 	return dao.Get()
 }
 
+func (dao *VersionPG) Update() (*Version, error) {
+	return dao.Set(DbVersion, DbDescription)
+}
+
 func (dao *VersionPG) Get() (*Version, error) {
 	v := Version{}
 	err := dao.db.Get(&v, dao.getVersionStmt)
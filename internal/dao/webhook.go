@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Webhook delivery states
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+type WebhookPG struct {
+	db                 *sqlx.DB
+	insertStmt         string
+	updateStmt         string
+	insertDeliveryStmt string
+	updateDeliveryStmt string
+}
+
+func NewWebhookPG(db *sqlx.DB) *WebhookPG {
+	fields := getStructFields(&Webhook{})
+	dfields := getStructFields(&WebhookDelivery{})
+	return &WebhookPG{db, buildInsertNamed("webhook", fields), buildUpdateNamed2("webhook", fields, "id"),
+		buildInsertNamed("webhookdelivery", dfields), buildUpdateNamed2("webhookdelivery", dfields, "id")}
+}
+
+func (dao *WebhookPG) Add(hook *Webhook) (*Webhook, error) {
+	hook.Id = uuid.New()
+	hook.Created = time.Now()
+	if _, err := dao.db.NamedExec(dao.insertStmt, hook); err != nil {
+		return nil, err
+	}
+	return dao.Get(hook.Id)
+}
+
+func (dao *WebhookPG) AddDelivery(delivery *WebhookDelivery) error {
+	delivery.Id = uuid.New()
+	_, err := dao.db.NamedExec(dao.insertDeliveryStmt, delivery)
+	return err
+}
+
+func (dao *WebhookPG) Delete(id uuid.UUID) error {
+	if _, err := dao.db.Exec("DELETE FROM webhookdelivery WHERE webhookId = $1", id); err != nil {
+		return err
+	}
+	_, err := dao.db.Exec("DELETE FROM webhook WHERE id = $1", id)
+	return err
+}
+
+// Deliveries returns the latest limit deliveries for the webhook with id, most recent first
+func (dao *WebhookPG) Deliveries(id uuid.UUID, limit int) ([]*WebhookDelivery, error) {
+	ret := []*WebhookDelivery{}
+	err := dao.db.Select(&ret, "SELECT * FROM webhookdelivery WHERE webhookId = $1 ORDER BY created DESC LIMIT $2", id, limit)
+	return ret, err
+}
+
+func (dao *WebhookPG) Get(id uuid.UUID) (*Webhook, error) {
+	ret := Webhook{}
+	if err := dao.db.Get(&ret, "SELECT * FROM webhook WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (dao *WebhookPG) List() ([]*Webhook, error) {
+	ret := []*Webhook{}
+	err := dao.db.Select(&ret, "SELECT * FROM webhook ORDER BY created")
+	return ret, err
+}
+
+// Pending returns all deliveries that have not been delivered or given up on, oldest first
+func (dao *WebhookPG) Pending() ([]*WebhookDelivery, error) {
+	ret := []*WebhookDelivery{}
+	err := dao.db.Select(&ret, "SELECT * FROM webhookdelivery WHERE state = $1 ORDER BY created", DeliveryPending)
+	return ret, err
+}
+
+// PruneDeliveries deletes finished deliveries created before before
+func (dao *WebhookPG) PruneDeliveries(before time.Time) (int, error) {
+	res, err := dao.db.Exec("DELETE FROM webhookdelivery WHERE state <> $1 AND created < $2", DeliveryPending, before)
+	if err != nil {
+		return 0, err
+	}
+	cnt, err := res.RowsAffected()
+	return int(cnt), err
+}
+
+func (dao *WebhookPG) Update(hook *Webhook) (*Webhook, error) {
+	if _, err := dao.db.NamedExec(dao.updateStmt, hook); err != nil {
+		return nil, err
+	}
+	return dao.Get(hook.Id)
+}
+
+func (dao *WebhookPG) UpdateDelivery(delivery *WebhookDelivery) error {
+	_, err := dao.db.NamedExec(dao.updateDeliveryStmt, delivery)
+	return err
+}
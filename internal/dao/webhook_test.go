@@ -0,0 +1,57 @@
+package dao
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	pgdb := openAndCreateTestDb(t)
+
+	hook, err := pgdb.Webhook.Add(&Webhook{Url: "http://localhost/hook", Secret: "secret", Events: "photo", Active: true})
+	if err != nil {
+		t.Fatalf("could not add webhook: %s", err.Error())
+	}
+	hook.Events = "photo,album.added"
+	if hook, err = pgdb.Webhook.Update(hook); err != nil {
+		t.Fatalf("could not update webhook: %s", err.Error())
+	} else if hook.Events != "photo,album.added" {
+		t.Errorf("webhook not updated: %v", hook)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	old := WebhookDelivery{WebhookId: hook.Id, EventType: "photo.added", Payload: "{}", State: DeliveryDelivered,
+		Created: now.Add(-48 * time.Hour)}
+	pending := WebhookDelivery{WebhookId: hook.Id, EventType: "photo.added", Payload: "{}", State: DeliveryPending,
+		Created: now}
+	for _, d := range []*WebhookDelivery{&old, &pending} {
+		if err := pgdb.Webhook.AddDelivery(d); err != nil {
+			t.Fatalf("could not add delivery: %s", err.Error())
+		}
+	}
+	if deliveries, err := pgdb.Webhook.Pending(); err != nil {
+		t.Errorf("could not list pending deliveries: %s", err.Error())
+	} else if len(deliveries) != 1 || deliveries[0].Id != pending.Id {
+		t.Errorf("expected 1 pending delivery got %v", deliveries)
+	}
+	pending.State, pending.Attempts, pending.StatusCode = DeliveryDelivered, 1, 200
+	if err := pgdb.Webhook.UpdateDelivery(&pending); err != nil {
+		t.Errorf("could not update delivery: %s", err.Error())
+	}
+	if cnt, err := pgdb.Webhook.PruneDeliveries(now.Add(-time.Hour)); err != nil || cnt != 1 {
+		t.Errorf("expected 1 pruned delivery got %d %v", cnt, err)
+	}
+	if deliveries, err := pgdb.Webhook.Deliveries(hook.Id, 10); err != nil {
+		t.Errorf("could not list deliveries: %s", err.Error())
+	} else if len(deliveries) != 1 || deliveries[0].Attempts != 1 {
+		t.Errorf("expected 1 delivery got %v", deliveries)
+	}
+
+	if err := pgdb.Webhook.Delete(hook.Id); err != nil {
+		t.Errorf("could not delete webhook: %s", err.Error())
+	}
+	if hooks, err := pgdb.Webhook.List(); err != nil || len(hooks) != 0 {
+		t.Errorf("expected no webhooks got %v %v", hooks, err)
+	}
+	deleteAndCloseTestDb(pgdb, t)
+}
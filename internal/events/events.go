@@ -0,0 +1,223 @@
+// Package events contains a broker that fans out library events (job progress, photo,
+// album, comment, reaction and guest changes) to subscribers such as server-sent event streams.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Topics that subscriptions can be filtered on. The topic of an event is the prefix of its type
+const (
+	TopicJob      = "job"
+	TopicPhoto    = "photo"
+	TopicAlbum    = "album"
+	TopicComment  = "comment"
+	TopicReaction = "reaction"
+	TopicGuest    = "guest"
+)
+
+var Topics = []string{TopicJob, TopicPhoto, TopicAlbum, TopicComment, TopicReaction, TopicGuest}
+
+// Event types
+const (
+	JobUpdated      = "job.updated"
+	PhotoAdded      = "photo.added"
+	PhotoUpdated    = "photo.updated"
+	PhotoDeleted    = "photo.deleted"
+	AlbumAdded      = "album.added"
+	AlbumUpdated    = "album.updated"
+	AlbumDeleted    = "album.deleted"
+	AlbumSubmitted  = "album.submitted"
+	CommentAdded    = "comment.added"
+	CommentUpdated  = "comment.updated"
+	CommentDeleted  = "comment.deleted"
+	ReactionAdded   = "reaction.added"
+	ReactionRemoved = "reaction.removed"
+	GuestVerified   = "guest.verified"
+)
+
+// subscriptionBuffer is the number of events a subscriber can lag behind before events are
+// dropped, see SubscribeQueued for subscribers that cannot miss events
+const subscriptionBuffer = 64
+
+type Event struct {
+	Id   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+	// Private events are only delivered to subscribers that are allowed to see them
+	Private bool `json:"-"`
+}
+
+// Topic returns the topic of the event, e.g. photo for photo.added
+func (e *Event) Topic() string {
+	return strings.SplitN(e.Type, ".", 2)[0]
+}
+
+// IsTopic returns true if topic is one of Topics
+func IsTopic(topic string) bool {
+	for _, t := range Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+type Subscription struct {
+	// C receives the events of the subscription. It is closed when the subscription or broker is closed
+	C       <-chan *Event
+	c       chan *Event
+	topics  map[string]bool
+	private bool
+	b       *Broker
+	//queued subscriptions keep the events that have not been sent on c in pending
+	queued  bool
+	pending []*Event
+	wake    chan struct{}
+	closed  bool
+}
+
+func (s *Subscription) accepts(e *Event) bool {
+	return (s.private || !e.Private) && (len(s.topics) == 0 || s.topics[e.Topic()])
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.b.remove(s)
+}
+
+// signal wakes up the pump of a queued subscription
+func (s *Subscription) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pump sends the pending events of a queued subscription on c. Once the subscription has
+// been closed the remaining events are sent before c is closed
+func (s *Subscription) pump() {
+	defer close(s.c)
+	for {
+		s.b.mu.Lock()
+		pending, closed := s.pending, s.closed
+		s.pending = nil
+		s.b.mu.Unlock()
+		for _, e := range pending {
+			s.c <- e
+		}
+		if len(pending) > 0 {
+			continue
+		} else if closed {
+			return
+		}
+		<-s.wake
+	}
+}
+
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	lastId uint64
+	closed bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription for topics, or for all topics if none are given. Private
+// events are only delivered if private is true
+func (b *Broker) Subscribe(topics []string, private bool) *Subscription {
+	c := make(chan *Event, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, topics: make(map[string]bool), private: private, b: b}
+	for _, t := range topics {
+		s.topics[t] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+	} else {
+		b.subs[s] = true
+	}
+	return s
+}
+
+// SubscribeQueued is like Subscribe but events are never dropped. Events that the subscriber
+// has not received yet are queued without limit, so the subscriber must keep reading C until
+// it is closed
+func (b *Broker) SubscribeQueued(topics []string, private bool) *Subscription {
+	c := make(chan *Event)
+	s := &Subscription{C: c, c: c, topics: make(map[string]bool), private: private, b: b,
+		queued: true, wake: make(chan struct{}, 1)}
+	for _, t := range topics {
+		s.topics[t] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+	} else {
+		b.subs[s] = true
+		go s.pump()
+	}
+	return s
+}
+
+// Publish sends an event to all matching subscribers. Subscribers that are not keeping up
+// miss the event rather than blocking the publisher, unless they are queued
+func (b *Broker) Publish(typ string, private bool, data interface{}) *Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastId++
+	e := &Event{Id: b.lastId, Type: typ, Time: time.Now(), Data: data, Private: private}
+	for s := range b.subs {
+		if !s.accepts(e) {
+			continue
+		}
+		if s.queued {
+			s.pending = append(s.pending, e)
+			s.signal()
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+		}
+	}
+	return e
+}
+
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[s] {
+		delete(b.subs, s)
+		b.stop(s)
+	}
+}
+
+// stop closes the channel of s, queued subscriptions close it once the pending events are sent
+func (b *Broker) stop(s *Subscription) {
+	if s.queued {
+		s.closed = true
+		s.signal()
+	} else {
+		close(s.c)
+	}
+}
+
+// Close closes all subscriptions. Events published after Close are dropped
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		b.stop(s)
+	}
+	b.subs = make(map[*Subscription]bool)
+	b.closed = true
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"testing"
+)
+
+func receive(s *Subscription) []string {
+	var types []string
+	for {
+		select {
+		case e := <-s.C:
+			types = append(types, e.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := NewBroker()
+	all := b.Subscribe(nil, true)
+	photos := b.Subscribe([]string{TopicPhoto}, true)
+	public := b.Subscribe(nil, false)
+
+	b.Publish(PhotoAdded, false, "a")
+	b.Publish(JobUpdated, true, "job")
+	e := b.Publish(CommentAdded, false, "comment")
+	if e.Id != 3 || e.Topic() != TopicComment {
+		t.Errorf("expected event 3 with topic comment got %d %s", e.Id, e.Topic())
+	}
+
+	if types := receive(all); len(types) != 3 {
+		t.Errorf("expected 3 events got %v", types)
+	}
+	if types := receive(photos); len(types) != 1 || types[0] != PhotoAdded {
+		t.Errorf("expected photo.added got %v", types)
+	}
+	if types := receive(public); len(types) != 2 || types[0] != PhotoAdded || types[1] != CommentAdded {
+		t.Errorf("expected public events only got %v", types)
+	}
+
+	photos.Close()
+	if _, ok := <-photos.C; ok {
+		t.Errorf("expected closed subscription")
+	}
+	b.Close()
+	if _, ok := <-all.C; ok {
+		t.Errorf("expected subscriptions to be closed with the broker")
+	}
+	b.Publish(PhotoAdded, false, "b")
+	if _, ok := <-b.Subscribe(nil, true).C; ok {
+		t.Errorf("expected subscription on closed broker to be closed")
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+	s := b.Subscribe(nil, true)
+	for i := 0; i < subscriptionBuffer+10; i++ {
+		b.Publish(PhotoUpdated, false, i)
+	}
+	if n := len(receive(s)); n != subscriptionBuffer {
+		t.Errorf("expected %d buffered events got %d", subscriptionBuffer, n)
+	}
+}
+
+func TestQueuedSubscriber(t *testing.T) {
+	b := NewBroker()
+	s := b.SubscribeQueued([]string{TopicPhoto}, true)
+	n := subscriptionBuffer * 4
+	for i := 0; i < n; i++ {
+		b.Publish(PhotoUpdated, false, i)
+		b.Publish(JobUpdated, true, i)
+	}
+	s.Close()
+	i := 0
+	for e := range s.C {
+		if e.Data != i {
+			t.Fatalf("expected event %d got %v", i, e.Data)
+		}
+		i++
+	}
+	if i != n {
+		t.Errorf("expected %d events before the subscription was closed got %d", n, i)
+	}
+}
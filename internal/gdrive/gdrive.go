@@ -1,6 +1,7 @@
 package gdrive
 
 import (
+	"errors"
     "fmt"
     "golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -8,6 +9,7 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"io"
+	"net/http"
 	"os"
 	"time"
 )
@@ -24,7 +26,9 @@ const (
 
 type DriveService struct {
 	service *drive.Service
+	ctx     context.Context
 	Root    *drive.File
+	Retry   RetryPolicy
 }
 
 /*
@@ -44,15 +48,29 @@ func ParseTime(tstr string) (time.Time, error) {
 
 func NewDriveService(token *oauth2.Token, config *oauth2.Config) (*DriveService, error) {
 	ctx := context.Background()
-	srv, err := drive.NewService(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+	return NewDriveServiceWithOptions(ctx, option.WithTokenSource(config.TokenSource(ctx, token)))
+}
+
+// NewDriveServiceWithOptions creates a DriveService from client options. Use option.WithEndpoint
+// to point it at another server, e.g. a fake Drive server in tests
+func NewDriveServiceWithOptions(ctx context.Context, opts ...option.ClientOption) (*DriveService, error) {
+	srv, err := drive.NewService(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
-	rf, err := srv.Files.Get("root").Do()
-	if err != nil {
+	ds := &DriveService{service: srv, ctx: ctx, Retry: DefaultRetryPolicy}
+	if ds.Root, err = do(ds, srv.Files.Get("root").Context(ctx).Do); err != nil {
 		return nil, err
 	}
-	return &DriveService{srv, rf}, nil
+	return ds, nil
+}
+
+// WithContext returns a copy of ds that uses ctx for all requests. Cancelling ctx aborts
+// ongoing requests and retries
+func (ds *DriveService) WithContext(ctx context.Context) *DriveService {
+	ret := *ds
+	ret.ctx = ctx
+	return &ret
 }
 
 func (ds *DriveService) About(fields ...googleapi.Field) (*drive.About, error) {
@@ -60,7 +78,7 @@ func (ds *DriveService) About(fields ...googleapi.Field) (*drive.About, error) {
 	if fields != nil {
 		acall = acall.Fields(fields...)
 	}
-	about, err := acall.Do()
+	about, err := do(ds, acall.Context(ds.ctx).Do)
 	if err != nil {
 		return nil, err
 	}
@@ -73,24 +91,98 @@ func (ds *DriveService) Check() error {
     return err
 }
 
+// Changes returns all changes since pageToken together with the token to use for the next
+// call. Removed files are included
+func (ds *DriveService) Changes(pageToken string, fileFields string) ([]*drive.Change, string, error) {
+	if pageToken == "" {
+		return nil, "", &googleapi.Error{Code: ErrorBadRequest, Message: "no page token provided"}
+	}
+	fields := fmt.Sprintf("nextPageToken, newStartPageToken, changes(changeType, removed, fileId, file(%s))", fileFields)
+	var changes []*drive.Change
+	for {
+		lcall := ds.service.Changes.List(pageToken).IncludeRemoved(true).Spaces("drive").Fields(googleapi.Field(fields))
+		r, err := do(ds, lcall.Context(ds.ctx).Do)
+		if err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, r.Changes...)
+		if r.NewStartPageToken != "" {
+			return changes, r.NewStartPageToken, nil
+		}
+		if r.NextPageToken == "" {
+			return nil, "", &googleapi.Error{Code: ErrorBackendError, Message: "no page token in changes response"}
+		}
+		pageToken = r.NextPageToken
+	}
+}
+
+// Download downloads the file with the given id to path. The content is written to path.part
+// and renamed into place when complete. An interrupted download is resumed from where it
+// stopped, both when retrying and when Download is called again for the same path
 func (ds *DriveService) Download(id string, path string) (int64, error) {
-	resp, err := ds.service.Files.Get(id).Download()
+	part := path + ".part"
+	err := ds.retry(func() error {
+		return ds.downloadPart(id, part)
+	})
 	if err != nil {
 		return 0, err
 	}
-	defer resp.Body.Close()
-
-	f, err := os.Create(path)
+	fi, err := os.Stat(part)
 	if err != nil {
 		return 0, err
 	}
+	if err = os.Rename(part, path); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// downloadPart appends the remaining content of file id to part
+func (ds *DriveService) downloadPart(id string, part string) error {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
-	return io.Copy(f, resp.Body)
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	gcall := ds.service.Files.Get(id).Context(ds.ctx)
+	if offset > 0 {
+		gcall.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := gcall.Download()
+	if err != nil {
+		var gerr *googleapi.Error
+		if offset > 0 && errors.As(err, &gerr) && gerr.Code == http.StatusRequestedRangeNotSatisfiable {
+			//the part file is already complete
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent && offset > 0 {
+		//range not honored, start over
+		if err = f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(f, resp.Body)
+	return err
 }
 
 func (ds *DriveService) Get(id string) (*drive.File, error) {
 	lcall := ds.service.Files.Get(id)
-	return lcall.Do()
+	return do(ds, lcall.Context(ds.ctx).Do)
+}
+
+// GetFields returns the file with the given id, fileFields selects which fields to return
+func (ds *DriveService) GetFields(id string, fileFields string) (*drive.File, error) {
+	return do(ds, ds.service.Files.Get(id).Fields(googleapi.Field(fileFields)).Context(ds.ctx).Do)
 }
 
 func (ds *DriveService) GetByName(name string, folder bool, trashed bool, fileFields string) (*drive.File, error) {
@@ -120,7 +212,7 @@ func (ds *DriveService) GetByQuery(q *Query, fileFields string) (*drive.File, er
 	}
 	lcall.PageSize(1)
 
-	r, err := lcall.Do()
+	r, err := do(ds, lcall.Context(ds.ctx).Do)
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +232,7 @@ func (ds *DriveService) List(pageSize int64, parentId string, fileFields string)
 		lcall.Q(NewQuery().Parents().In(parentId).String())
 	}
 	fields := fmt.Sprintf("nextPageToken, files(%s)", fileFields)
-	return lcall.Fields(googleapi.Field(fields)).Do()
+	return do(ds, lcall.Fields(googleapi.Field(fields)).Context(ds.ctx).Do)
 }
 
 func (ds *DriveService) ListAll(parentId string, fileFields string) ([]*drive.File, error) {
@@ -159,6 +251,15 @@ func (ds *DriveService) SearchFolder(parentId string, query *Query, fileFields s
 	return ds.SearchAll(query, fileFields)
 }
 
+// StartPageToken returns the token to use for the first call to Changes
+func (ds *DriveService) StartPageToken() (string, error) {
+	r, err := do(ds, ds.service.Changes.GetStartPageToken().Context(ds.ctx).Do)
+	if err != nil {
+		return "", err
+	}
+	return r.StartPageToken, nil
+}
+
 func (ds *DriveService) SearchAll(q *Query, fileFields string) ([]*drive.File, error) {
 	lcall := ds.service.Files.List()
 	if q.Err() != nil {
@@ -177,7 +278,7 @@ func (ds *DriveService) SearchAll(q *Query, fileFields string) ([]*drive.File, e
 		if nextToken != "" {
 			lcall.PageToken(nextToken)
 		}
-		r, err := lcall.Do()
+		r, err := do(ds, lcall.Context(ds.ctx).Do)
 		if err != nil {
 
 			return nil, err
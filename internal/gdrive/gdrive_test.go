@@ -0,0 +1,223 @@
+package gdrive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeDrive serves a minimal subset of the Drive v3 API
+func fakeDrive(t *testing.T) *httptest.Server {
+	pages := map[string]*drive.ChangeList{
+		"1": {NextPageToken: "2", Changes: []*drive.Change{
+			{FileId: "a", File: &drive.File{Id: "a", Name: "a.jpg", Md5Checksum: "md5a"}},
+			{FileId: "b", Removed: true},
+		}},
+		"2": {NewStartPageToken: "3", Changes: []*drive.Change{
+			{FileId: "c", File: &drive.File{Id: "c", Name: "c.jpg", Trashed: true}},
+		}},
+		"3": {NewStartPageToken: "3"},
+	}
+	mux := http.NewServeMux()
+	write := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			t.Error(err)
+		}
+	}
+	mux.HandleFunc("/files/root", func(w http.ResponseWriter, r *http.Request) {
+		write(w, &drive.File{Id: "root", Name: "My Drive"})
+	})
+	mux.HandleFunc("/changes/startPageToken", func(w http.ResponseWriter, r *http.Request) {
+		write(w, &drive.StartPageToken{StartPageToken: "1"})
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("includeRemoved") != "true" {
+			t.Errorf("expected removed files to be included")
+		}
+		if page, found := pages[r.URL.Query().Get("pageToken")]; found {
+			write(w, page)
+		} else {
+			http.Error(w, "invalid page token", http.StatusBadRequest)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestService(t *testing.T, srv *httptest.Server) *DriveService {
+	ds, err := NewDriveServiceWithOptions(context.Background(), option.WithEndpoint(srv.URL+"/"),
+		option.WithHTTPClient(srv.Client()))
+	if err != nil {
+		t.Fatalf("could not create drive service: %v", err)
+	}
+	return ds
+}
+
+func TestChanges(t *testing.T) {
+	srv := fakeDrive(t)
+	defer srv.Close()
+	ds := newTestService(t, srv)
+	if ds.Root.Id != "root" {
+		t.Errorf("expected root folder got %s", ds.Root.Id)
+	}
+
+	token, err := ds.StartPageToken()
+	if err != nil {
+		t.Fatalf("could not get start page token: %v", err)
+	}
+	if token != "1" {
+		t.Errorf("expected start page token 1 got %s", token)
+	}
+
+	changes, next, err := ds.Changes(token, "id, name, md5Checksum, trashed")
+	if err != nil {
+		t.Fatalf("could not list changes: %v", err)
+	}
+	if next != "3" {
+		t.Errorf("expected next page token 3 got %s", next)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes got %d", len(changes))
+	}
+	if changes[0].File.Md5Checksum != "md5a" || !changes[1].Removed || !changes[2].File.Trashed {
+		t.Errorf("unexpected changes: %v %v %v", changes[0], changes[1], changes[2])
+	}
+
+	//nothing has happened since the last call
+	if changes, next, err = ds.Changes(next, "id"); err != nil {
+		t.Fatalf("could not list changes: %v", err)
+	} else if len(changes) != 0 || next != "3" {
+		t.Errorf("expected no changes got %d, next token %s", len(changes), next)
+	}
+
+	if _, _, err = ds.Changes("", "id"); err == nil {
+		t.Errorf("expected error for empty page token")
+	}
+	if _, _, err = ds.Changes("bad", "id"); err == nil {
+		t.Errorf("expected error for invalid page token")
+	}
+}
+
+var testRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		max := p.BaseDelay << uint(attempt)
+		if max > p.MaxDelay {
+			max = p.MaxDelay
+		}
+		if d := p.backoff(attempt); d < max/2 || d > max {
+			t.Errorf("attempt %d: expected delay between %v and %v got %v", attempt, max/2, max, d)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/root", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&drive.File{Id: "root"})
+	})
+	mux.HandleFunc("/files/limited", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"error": {"code": 429, "message": "slow down"}}`, http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(&drive.File{Id: "limited"})
+	})
+	mux.HandleFunc("/files/denied", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, `{"error": {"code": 403, "message": "denied", "errors": [{"reason": "forbidden"}]}}`, http.StatusForbidden)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ds := newTestService(t, srv)
+	ds.Retry = testRetry
+
+	if f, err := ds.Get("limited"); err != nil {
+		t.Errorf("expected request to succeed after retries: %v", err)
+	} else if f.Id != "limited" || calls != 3 {
+		t.Errorf("expected 3 calls got %d", calls)
+	}
+
+	calls = 0
+	if _, err := ds.Get("denied"); err == nil {
+		t.Errorf("expected error")
+	} else if calls != 1 {
+		t.Errorf("expected permission errors to not be retried, got %d calls", calls)
+	} else if IsUnavailable(err) {
+		t.Errorf("expected a permission error to only concern the file")
+	}
+
+	calls = -10 //keep failing past the last attempt
+	if _, err := ds.Get("limited"); !IsUnavailable(err) {
+		t.Errorf("expected rate limit errors to make drive unavailable got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ds.WithContext(ctx).Get("limited"); err == nil {
+		t.Errorf("expected error for cancelled context")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/root", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&drive.File{Id: "root"})
+	})
+	mux.HandleFunc("/files/img", func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		ranges = append(ranges, rng)
+		if rng == "" {
+			//announce the full file but break the connection half way
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+			t.Errorf("unexpected range %s", rng)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start:])
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ds := newTestService(t, srv)
+	ds.Retry = testRetry
+
+	path := filepath.Join(t.TempDir(), "img.jpg")
+	n, err := ds.Download("img", path)
+	if err != nil {
+		t.Fatalf("could not download: %v", err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("expected %d bytes got %d", len(content), n)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, content) {
+		t.Errorf("expected %s got %s", content, b)
+	}
+	if len(ranges) != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", len(content)/2) {
+		t.Errorf("expected download to resume, got ranges %v", ranges)
+	}
+	if _, err = os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("expected part file to be removed")
+	}
+}
@@ -0,0 +1,132 @@
+package gdrive
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed Drive requests are retried. Rate limit errors and
+// transient server and network errors are retried with jittered exponential backoff.
+// A Retry-After header from the server takes precedence over the backoff
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 6, BaseDelay: 500 * time.Millisecond, MaxDelay: 32 * time.Second}
+
+// backoff returns a random delay between half and the full exponential delay for attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << uint(attempt)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func isRateLimit(e *googleapi.Error) bool {
+	if e.Code == ErrorTooManyRequests {
+		return true
+	}
+	if e.Code != ErrorLimitExceeded {
+		return false
+	}
+	for _, item := range e.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+func retryable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return isRateLimit(gerr) || gerr.Code >= ErrorBackendError
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// IsUnavailable reports whether err means that Drive as a whole could not be used, e.g.
+// because of rate limits, server or network errors or revoked credentials, as opposed to
+// an error that only concerns a single file
+func IsUnavailable(err error) bool {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == ErrorInvalidCredentials {
+		return true
+	}
+	return retryable(err)
+}
+
+// retryAfter returns the delay requested by the server, if any
+func retryAfter(err error) (time.Duration, bool) {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Header == nil {
+		return 0, false
+	}
+	v := gerr.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// retry calls op until it succeeds, fails with an error that is not retryable, the
+// attempts are exhausted or the service context is done
+func (ds *DriveService) retry(op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || ds.ctx.Err() != nil || !retryable(err) || attempt >= ds.Retry.MaxAttempts {
+			return err
+		}
+		delay, found := retryAfter(err)
+		if !found {
+			delay = ds.Retry.backoff(attempt - 1)
+		}
+		if err = sleep(ds.ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// do runs the Do method of a Drive call with retries
+func do[T any](ds *DriveService, call func(opts ...googleapi.CallOption) (T, error)) (T, error) {
+	var ret T
+	err := ds.retry(func() error {
+		var err error
+		ret, err = call()
+		return err
+	})
+	return ret, err
+}
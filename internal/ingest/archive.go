@@ -0,0 +1,125 @@
+package ingest
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StatusAdded     = "added"
+	StatusDuplicate = "duplicate"
+	StatusFailed    = "failed"
+)
+
+// Result is the outcome of importing a single file
+type Result struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	PhotoId uuid.UUID `json:"photoId"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type ArchiveOptions struct {
+	//Add all imported photos (including duplicates) to an album named after the archive
+	CreateAlbum bool
+	//Override the album name
+	AlbumName string
+}
+
+// Progress is called after each item in a source has been processed
+type Progress func(r *Result)
+
+// AlbumName derives an album name from an archive or directory path
+func AlbumName(path string) string {
+	base := filepath.Base(filepath.Clean(path))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// PathSource returns a DirSource if path is a directory and a ZipSource otherwise
+func PathSource(path string) (Source, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return DirSource(path), nil
+	}
+	return ZipSource(path), nil
+}
+
+// CountImages returns the number of images that ImportPath would process for path
+func CountImages(path string) (int, error) {
+	src, err := PathSource(path)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = src.Walk(func(_ Item) { count++ })
+	return count, err
+}
+
+// ImportSource imports all items in src. hooks are run for every added photo as well as for
+// photos that already existed. If the pipeline context is done the remaining items are skipped
+// and the context error is returned
+func (p *Pipeline) ImportSource(src Source, progress Progress, hooks ...Hook) ([]*Result, error) {
+	var results []*Result
+	err := src.Walk(func(item Item) {
+		if p.ctx.Err() != nil {
+			return
+		}
+		res := &Result{Name: item.Name()}
+		photo, err := p.Add(item, hooks...)
+		switch {
+		case err == nil:
+			res.Status, res.PhotoId = StatusAdded, photo.Id
+		case errors.Is(err, ErrExists):
+			res.Status = StatusDuplicate
+			if photo != nil {
+				res.PhotoId = photo.Id
+				for _, h := range hooks {
+					if e := h(photo); e != nil {
+						logger.Infow("import hook failed", "name", item.Name(), "error", e)
+					}
+				}
+			}
+		default:
+			res.Status, res.Error = StatusFailed, err.Error()
+			if photo != nil {
+				res.PhotoId = photo.Id
+			}
+			logger.Infow("could not import file", "name", item.Name(), "error", err)
+		}
+		results = append(results, res)
+		if progress != nil {
+			progress(res)
+		}
+	})
+	if err == nil {
+		err = p.ctx.Err()
+	}
+	return results, err
+}
+
+// ImportPath imports all jpegs in a zip archive or a directory (recursively)
+func (p *Pipeline) ImportPath(path string, opts ArchiveOptions, progress Progress) ([]*Result, error) {
+	src, err := PathSource(path)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []Hook
+	if opts.CreateAlbum {
+		name := opts.AlbumName
+		if name == "" {
+			name = AlbumName(path)
+		}
+		album, err := AlbumByName(p.db, name, "Imported from "+filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, AlbumHook(p.db, album))
+	}
+	return p.ImportSource(src, progress, hooks...)
+}
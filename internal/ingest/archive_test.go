@@ -0,0 +1,81 @@
+package ingest
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAlbumName(t *testing.T) {
+	cases := map[string]string{
+		"/tmp/shoot-2024.zip": "shoot-2024",
+		"shoot":               "shoot",
+		"/photos/trip-x/":     "trip-x",
+	}
+	for path, exp := range cases {
+		if act := AlbumName(path); act != exp {
+			t.Errorf("expected %s got %s", exp, act)
+		}
+	}
+}
+
+func TestCountImages(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "shoot.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"a.jpg", "sub/b.JPEG", "notes.txt", ".hidden.jpg", "__MACOSX/a.jpg"} {
+		if _, err = zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if n, err := CountImages(archive); err != nil {
+		t.Errorf("could not count images: %v", err)
+	} else if n != 2 {
+		t.Errorf("expected 2 images in archive got %d", n)
+	}
+
+	//directory
+	if err = os.MkdirAll(filepath.Join(dir, "sub"), 0744); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"c.jpg", "sub/d.jpg", "e.png"} {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := CountImages(dir); err != nil {
+		t.Errorf("could not count images: %v", err)
+	} else if n != 2 {
+		t.Errorf("expected 2 images in dir got %d", n)
+	}
+}
+
+func TestImportSourceCancelled(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := New(nil).WithContext(ctx)
+	results, err := p.ImportSource(DirSource(dir), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results got %d", len(results))
+	}
+}
@@ -0,0 +1,372 @@
+// Package ingest contains the import pipeline shared by all photo sources (Drive,
+// local uploads, the watched inbox folder and archives). The pipeline takes care of
+// dedupe, metadata extraction, generation of image versions, camera registration and
+// post import hooks.
+package ingest
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mimage/metadata"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const jpegMimeType = "image/jpeg"
+
+var (
+	ErrExists  = errors.New("photo already exists")
+	ErrNotJpeg = errors.New("image is not a jpeg")
+)
+
+var logger *zap.SugaredLogger
+
+func init() {
+	l, _ := zap.NewDevelopment()
+	logger = l.Sugar()
+}
+
+// Item is a single photo to import
+type Item interface {
+	// Name used when reporting results, e.g. the file name
+	Name() string
+	// Source is stored as Photo.Source, e.g dao.SourceLocal
+	Source() string
+	SourceId() string
+	SourceDate() time.Time
+	// Md5 returns the checksum if the source knows it up front, otherwise "". Allows
+	// duplicates to be skipped before they are fetched
+	Md5() string
+	// Fetch writes the content of the item to path
+	Fetch(path string) error
+}
+
+// MetaItem is implemented by items whose source has metadata of its own, e.g. Drive
+// descriptions. ApplyMeta is called after the image metadata has been set
+type MetaItem interface {
+	Item
+	ApplyMeta(photo *dao.Photo)
+}
+
+// Hook is called for every photo that has been imported
+type Hook func(photo *dao.Photo) error
+
+type Pipeline struct {
+	db    *dao.PGDB
+	hooks []Hook
+	ctx   context.Context
+}
+
+// New creates a pipeline. hooks are run for every photo added through the pipeline
+func New(db *dao.PGDB, hooks ...Hook) *Pipeline {
+	return &Pipeline{db: db, hooks: hooks, ctx: context.Background()}
+}
+
+// WithContext returns a copy of the pipeline that stops importing sources once ctx is done
+func (p *Pipeline) WithContext(ctx context.Context) *Pipeline {
+	ret := *p
+	ret.ctx = ctx
+	return &ret
+}
+
+// AddHook registers a hook that is run for every photo added through the pipeline
+func (p *Pipeline) AddHook(h Hook) {
+	p.hooks = append(p.hooks, h)
+}
+
+// Add imports item. hooks are run after the pipeline hooks. If a photo with the same md5
+// already exists ErrExists is returned together with the existing photo
+func (p *Pipeline) Add(item Item, hooks ...Hook) (*dao.Photo, error) {
+	if md5str := item.Md5(); md5str != "" && p.db.Photo.HasMd5(md5str) {
+		existing, _ := p.db.Photo.GetByMd5(md5str)
+		return existing, ErrExists
+	}
+	photo := dao.Photo{}
+	photo.Id = uuid.New()
+	photo.Source = item.Source()
+	photo.SourceId = item.SourceId()
+	photo.SourceDate = item.SourceDate()
+	photo.UploadDate = time.Now()
+	//use the same filename naming convention for all sources
+	photo.FileName = photo.Id.String() + ".jpg"
+
+	dstPath := config.PhotoFilePath(config.Original, photo.FileName)
+	tmpPath := dstPath + ".tmp"
+	md5str, err := fetch(item, tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	if p.db.Photo.HasMd5(md5str) {
+		_ = os.Remove(tmpPath)
+		existing, _ := p.db.Photo.GetByMd5(md5str)
+		return existing, ErrExists
+	}
+	if err = os.Rename(tmpPath, dstPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	photo.Md5 = md5str
+
+	if err = dao.GenerateImages(photo.FileName); err != nil {
+		_ = dao.DeleteImg(photo.FileName)
+		return nil, err
+	}
+
+	md, err := metadata.NewMetaDataFromFile(dstPath)
+	if err != nil {
+		_ = dao.DeleteImg(photo.FileName)
+		return nil, err
+	}
+	SetMetaData(&photo, md)
+	if mi, ok := item.(MetaItem); ok {
+		mi.ApplyMeta(&photo)
+	}
+
+	if err = p.db.Photo.Add(&photo, md.Summary()); err != nil {
+		_ = dao.DeleteImg(photo.FileName)
+		if errors.Is(err, dao.ErrMd5Exists) {
+			//another import of the same file won the race since the md5 check above
+			existing, _ := p.db.Photo.GetByMd5(md5str)
+			return existing, ErrExists
+		}
+		return nil, err
+	}
+	if err = p.registerCamera(&photo); err != nil {
+		logger.Errorw("could not register camera", "Id", photo.Id, zap.Error(err))
+	}
+	logger.Infow("added img", "Id", photo.Id, "Source", photo.Source, "SourceId", photo.SourceId)
+	p.runHooks(&photo, hooks)
+	return &photo, nil
+}
+
+// Replace re-imports photo from item, e.g. when the source file has been replaced. The photo
+// keeps its id (and thereby albums, comments and likes) as well as title, description and
+// keywords. Fails with ErrExists if the new content is already used by another photo. The
+// original is only replaced once the photo has been updated, if anything fails the photo is
+// left as it was
+func (p *Pipeline) Replace(photo *dao.Photo, item Item) (*dao.Photo, error) {
+	dstPath := config.PhotoFilePath(config.Original, photo.FileName)
+	//keep the extension so that exif data is copied to the generated images
+	tmpPath := strings.TrimSuffix(dstPath, filepath.Ext(dstPath)) + ".tmp" + filepath.Ext(dstPath)
+	md5str, err := fetch(item, tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	if md5str == photo.Md5 {
+		_ = os.Remove(tmpPath)
+		return photo, nil
+	}
+	if p.db.Photo.HasMd5(md5str) {
+		_ = os.Remove(tmpPath)
+		existing, _ := p.db.Photo.GetByMd5(md5str)
+		return existing, ErrExists
+	}
+	md, err := metadata.NewMetaDataFromFile(tmpPath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	oldExif, err := p.db.Photo.Exif(photo.Id)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	if err = dao.GenerateImagesFrom(tmpPath, photo.FileName); err != nil {
+		p.restore(photo, tmpPath)
+		return nil, err
+	}
+	replaced := *photo
+	replaced.Md5 = md5str
+	replaced.SourceDate = item.SourceDate()
+	SetMetaData(&replaced, md)
+	replaced.Title, replaced.Description, replaced.Keywords = photo.Title, photo.Description, photo.Keywords
+	if mi, ok := item.(MetaItem); ok {
+		mi.ApplyMeta(&replaced)
+	}
+	keepEdits(&replaced, photo)
+	if err = p.db.Photo.Replace(&replaced, md.Summary()); err != nil {
+		p.restore(photo, tmpPath)
+		if errors.Is(err, dao.ErrMd5Exists) {
+			existing, _ := p.db.Photo.GetByMd5(md5str)
+			return existing, ErrExists
+		}
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, dstPath); err != nil {
+		if e := p.db.Photo.Replace(photo, oldExif.Data); e != nil {
+			logger.Errorw("could not restore replaced img", "Id", photo.Id, zap.Error(e))
+		}
+		p.restore(photo, tmpPath)
+		return nil, err
+	}
+	if err = p.registerCamera(&replaced); err != nil {
+		logger.Errorw("could not register camera", "Id", replaced.Id, zap.Error(err))
+	}
+	logger.Infow("replaced img", "Id", replaced.Id, "Source", replaced.Source, "SourceId", replaced.SourceId)
+	return &replaced, nil
+}
+
+// restore removes the new original tmpPath of a failed replace and generates the images of
+// photo from its original again
+func (p *Pipeline) restore(photo *dao.Photo, tmpPath string) {
+	_ = os.Remove(tmpPath)
+	if err := dao.GenerateImages(photo.FileName); err != nil {
+		logger.Errorw("could not restore images", "Id", photo.Id, zap.Error(err))
+	}
+}
+
+// Reindex reads the metadata of the original image again and updates photo and its exif
+// data. Title, description and keywords are kept
+func (p *Pipeline) Reindex(photo *dao.Photo) (*dao.Photo, error) {
+	md, err := metadata.NewMetaDataFromFile(config.PhotoFilePath(config.Original, photo.FileName))
+	if err != nil {
+		return nil, err
+	}
+	reindexed := *photo
+	SetMetaData(&reindexed, md)
+	reindexed.Title, reindexed.Description, reindexed.Keywords = photo.Title, photo.Description, photo.Keywords
+	if err = p.db.Photo.Replace(&reindexed, md.Summary()); err != nil {
+		return nil, err
+	}
+	if err = p.registerCamera(&reindexed); err != nil {
+		return &reindexed, err
+	}
+	return &reindexed, nil
+}
+
+// UpdateMeta applies the source metadata of item to photo. Fields that have been edited
+// locally are kept. Returns the updated photo and whether anything changed
+func (p *Pipeline) UpdateMeta(photo *dao.Photo, item Item) (*dao.Photo, bool, error) {
+	mi, ok := item.(MetaItem)
+	if !ok {
+		return photo, false, nil
+	}
+	updated := *photo
+	mi.ApplyMeta(&updated)
+	keepEdits(&updated, photo)
+	if sameSourceMeta(&updated, photo) {
+		return photo, false, nil
+	}
+	ret, err := p.db.Photo.SetSource(&updated)
+	return ret, err == nil, err
+}
+
+func sameSourceMeta(a, b *dao.Photo) bool {
+	return a.Title == b.Title && a.Description == b.Description && a.Keywords == b.Keywords &&
+		a.Favorite == b.Favorite && a.SourcePath == b.SourcePath && a.SourceModified.Equal(b.SourceModified)
+}
+
+// keepEdits restores the fields of dst that have been edited locally in src
+func keepEdits(dst, src *dao.Photo) {
+	if src.Edited {
+		dst.Title, dst.Description, dst.Keywords = src.Title, src.Description, src.Keywords
+	}
+	if src.FavoriteEdited {
+		dst.Favorite = src.Favorite
+	}
+}
+
+func (p *Pipeline) registerCamera(photo *dao.Photo) error {
+	if photo.CameraModel == "" || p.db.Camera.HasModel(photo.CameraModel) {
+		return nil
+	}
+	if err := p.db.Camera.AddFromPhoto(photo); err != nil {
+		return fmt.Errorf("could not add camera model %s: %w", photo.CameraModel, err)
+	}
+	return nil
+}
+
+// runHooks runs the pipeline hooks followed by hooks. A failing hook is logged but does
+// not stop the remaining hooks
+func (p *Pipeline) runHooks(photo *dao.Photo, hooks []Hook) {
+	for _, h := range append(append([]Hook{}, p.hooks...), hooks...) {
+		if err := h(photo); err != nil {
+			logger.Errorw("import hook failed", "Id", photo.Id, zap.Error(err))
+		}
+	}
+}
+
+// fetch writes item to path and returns its md5 checksum. Fails with ErrNotJpeg if the
+// content is not a jpeg
+func fetch(item Item, path string) (string, error) {
+	if err := item.Fetch(path); err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if mt := http.DetectContentType(head[:n]); mt != jpegMimeType {
+		return "", fmt.Errorf("%w: %s", ErrNotJpeg, mt)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return md5Of(f)
+}
+
+func md5Of(src io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, src); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// SetMetaData maps image metadata to photo fields
+func SetMetaData(photo *dao.Photo, md *metadata.MetaData) {
+	photo.CameraMake = md.Summary().CameraMake
+	photo.CameraModel = md.Summary().CameraModel
+	photo.FocalLength = fmt.Sprintf("%v mm", md.Summary().FocalLength.Float32())
+	photo.FocalLength35 = fmt.Sprintf("%v mm", md.Summary().FocalLengthIn35mmFormat)
+	photo.LensMake = md.Summary().LensMake
+	photo.LensModel = md.Summary().LensModel
+	photo.Exposure = md.Summary().ExposureTime.String()
+	photo.Width = md.ImageWidth
+	photo.Height = md.ImageHeight
+	photo.FNumber = md.Summary().FNumber.Float32()
+	photo.Iso = uint(md.Summary().ISO)
+	photo.Title = md.Summary().Title
+	if len(md.Summary().Keywords) > 0 {
+		photo.Keywords = strings.Join(md.Summary().Keywords, ",")
+	}
+	if md.Summary().OriginalDate.IsZero() {
+		photo.OriginalDate = photo.SourceDate
+	} else {
+		photo.OriginalDate = md.Summary().OriginalDate
+	}
+}
+
+// AlbumByName returns the album with the given name, creating it if it does not exist
+func AlbumByName(db *dao.PGDB, name, description string) (*dao.Album, error) {
+	if db.Album.HasByName(name) {
+		return db.Album.GetByName(name)
+	}
+	return db.Album.Add(name, description, "")
+}
+
+// AlbumHook returns a hook that adds photos to album
+func AlbumHook(db *dao.PGDB, album *dao.Album) Hook {
+	return func(photo *dao.Photo) error {
+		_, err := db.Album.AddPhotos(album.Id, []uuid.UUID{photo.Id})
+		return err
+	}
+}
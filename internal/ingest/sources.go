@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"archive/zip"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/gdrive"
+	"google.golang.org/api/drive/v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const downloadDir = "downloads"
+
+// Source is a collection of items, e.g. a zip archive or a Drive folder
+type Source interface {
+	// Walk calls fn for every item in the source
+	Walk(fn func(item Item)) error
+}
+
+func copyTo(src io.Reader, path string) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+type localItem struct {
+	name       string
+	sourceId   string
+	sourceDate time.Time
+	open       func() (io.ReadCloser, error)
+}
+
+func (i *localItem) Name() string          { return i.name }
+func (i *localItem) Source() string        { return dao.SourceLocal }
+func (i *localItem) SourceId() string      { return i.sourceId }
+func (i *localItem) SourceDate() time.Time { return i.sourceDate }
+func (i *localItem) Md5() string           { return "" }
+
+func (i *localItem) Fetch(path string) error {
+	rc, err := i.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return copyTo(rc, path)
+}
+
+// NewReaderItem creates an item from an uploaded file. The item can only be fetched once
+func NewReaderItem(r io.Reader, sourceId string, sourceDate time.Time) Item {
+	return &localItem{name: sourceId, sourceId: sourceId, sourceDate: sourceDate, open: func() (io.ReadCloser, error) {
+		return io.NopCloser(r), nil
+	}}
+}
+
+// NewFileItem creates an item from a local file. The file modification time is used as source date
+func NewFileItem(path string) (Item, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &localItem{name: fi.Name(), sourceId: fi.Name(), sourceDate: fi.ModTime(), open: func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}}, nil
+}
+
+func isImageName(name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(base))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// DirSource contains all jpegs in a directory and its subdirectories
+type DirSource string
+
+func (d DirSource) names() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(string(d), func(p string, e os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() && isImageName(e.Name()) {
+			names = append(names, p)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func (d DirSource) Walk(fn func(item Item)) error {
+	names, err := d.names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		item, err := NewFileItem(name)
+		if err != nil {
+			logger.Infow("could not stat file", "name", name, "error", err)
+			continue
+		}
+		if rel, err := filepath.Rel(string(d), name); err == nil {
+			item.(*localItem).name = rel
+		}
+		fn(item)
+	}
+	return nil
+}
+
+// ZipSource contains all jpegs in a zip archive
+type ZipSource string
+
+func (z ZipSource) Walk(fn func(item Item)) error {
+	zr, err := zip.OpenReader(string(z))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isImageName(f.Name) {
+			continue
+		}
+		zf := f
+		fn(&localItem{name: zf.Name, sourceId: filepath.Base(zf.Name), sourceDate: zf.Modified, open: zf.Open})
+	}
+	return nil
+}
+
+// Drive file properties that are mapped to photo fields
+const (
+	DrivePropertyTitle    = "title"
+	DrivePropertyKeywords = "keywords"
+)
+
+type driveItem struct {
+	ds   *gdrive.DriveService
+	f    *drive.File
+	path string
+}
+
+// NewDriveItem creates an item from a file in Google Drive. path is the folder path of the file
+func NewDriveItem(ds *gdrive.DriveService, f *drive.File, path string) MetaItem {
+	return &driveItem{ds, f, path}
+}
+
+func (i *driveItem) Name() string     { return i.f.Name }
+func (i *driveItem) Source() string   { return dao.SourceGoogle }
+func (i *driveItem) SourceId() string { return i.f.Id }
+func (i *driveItem) Md5() string      { return i.f.Md5Checksum }
+
+func (i *driveItem) SourceDate() time.Time {
+	t, _ := gdrive.ParseTime(i.f.CreatedTime)
+	return t
+}
+
+// ApplyMeta maps the description, starred flag and title and keywords properties of
+// the Drive file to the photo. Metadata that is not set in Drive is left as is
+func (i *driveItem) ApplyMeta(photo *dao.Photo) {
+	if i.f.Description != "" {
+		photo.Description = i.f.Description
+	}
+	if t := i.f.Properties[DrivePropertyTitle]; t != "" {
+		photo.Title = t
+	}
+	if k := i.f.Properties[DrivePropertyKeywords]; k != "" {
+		photo.Keywords = k
+	}
+	photo.Favorite = i.f.Starred
+	photo.SourcePath = i.path
+	if t, err := gdrive.ParseTime(i.f.ModifiedTime); err == nil {
+		photo.SourceModified = t
+	}
+}
+
+// Fetch downloads to a path derived from the drive id and checksum so that an interrupted
+// download can be resumed by a later import
+func (i *driveItem) Fetch(path string) error {
+	dir := config.ServicePath(downloadDir)
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return err
+	}
+	dl := filepath.Join(dir, i.f.Id+"-"+i.f.Md5Checksum)
+	if _, err := i.ds.Download(i.f.Id, dl); err != nil {
+		return err
+	}
+	return os.Rename(dl, path)
+}
+
+// DriveSource contains a list of Drive files
+type DriveSource struct {
+	ds    *gdrive.DriveService
+	files []*drive.File
+}
+
+func NewDriveSource(ds *gdrive.DriveService, files []*drive.File) *DriveSource {
+	return &DriveSource{ds, files}
+}
+
+func (d *DriveSource) Walk(fn func(item Item)) error {
+	for _, f := range d.files {
+		fn(NewDriveItem(d.ds, f, ""))
+	}
+	return nil
+}
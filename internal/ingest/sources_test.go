@@ -0,0 +1,39 @@
+package ingest
+
+import (
+	"github.com/msvens/mphotos/internal/dao"
+	"google.golang.org/api/drive/v3"
+	"testing"
+)
+
+func TestDriveApplyMeta(t *testing.T) {
+	f := &drive.File{Id: "a", Description: "from drive", Starred: true, ModifiedTime: "2022-05-01T10:00:00.000Z",
+		Properties: map[string]string{DrivePropertyTitle: "drive title"}}
+	photo := dao.Photo{Title: "title", Description: "description", Keywords: "k1,k2"}
+	NewDriveItem(nil, f, "photos/2022").ApplyMeta(&photo)
+	if photo.Title != "drive title" || photo.Description != "from drive" || !photo.Favorite {
+		t.Errorf("expected drive metadata got %s, %s, %v", photo.Title, photo.Description, photo.Favorite)
+	}
+	if photo.Keywords != "k1,k2" {
+		t.Errorf("expected keywords to be kept got %s", photo.Keywords)
+	}
+	if photo.SourcePath != "photos/2022" || photo.SourceModified.IsZero() {
+		t.Errorf("expected source path and modified time got %s, %v", photo.SourcePath, photo.SourceModified)
+	}
+
+	edited := dao.Photo{Title: "title", Description: "description", Edited: true}
+	updated := edited
+	NewDriveItem(nil, f, "").ApplyMeta(&updated)
+	keepEdits(&updated, &edited)
+	if updated.Title != "title" || updated.Description != "description" || !updated.Favorite {
+		t.Errorf("expected local edits and the drive favorite got %s, %s, %v", updated.Title, updated.Description, updated.Favorite)
+	}
+
+	starred := dao.Photo{Title: "title", FavoriteEdited: true}
+	updated = starred
+	NewDriveItem(nil, f, "").ApplyMeta(&updated)
+	keepEdits(&updated, &starred)
+	if updated.Title != "drive title" || updated.Favorite {
+		t.Errorf("expected the drive title and the local favorite got %s, %v", updated.Title, updated.Favorite)
+	}
+}
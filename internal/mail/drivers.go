@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"fmt"
+	"github.com/msvens/mphotos/internal/gmail"
+	"go.uber.org/zap"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Gmail sends messages through the Gmail api of the connected Google account. Since the account
+// can be connected and disconnected at any time the service is looked up for each message
+type Gmail struct {
+	From    string
+	Service func() *gmail.GmailService
+}
+
+func (g *Gmail) Send(msg *Message) error {
+	srv := g.Service()
+	if srv == nil {
+		return ErrNotConnected
+	}
+	raw, err := msg.Bytes(g.From)
+	if err != nil {
+		return err
+	}
+	return srv.SendRawMessage(raw)
+}
+
+// SMTP sends messages to an SMTP server. STARTTLS is used if the server supports it and the
+// connection is authenticated if a username is given
+type SMTP struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(msg *Message) error {
+	raw, err := msg.Bytes(s.From)
+	if err != nil {
+		return err
+	}
+	from, to, err := msg.addresses(s.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), auth, from, []string{to}, raw)
+}
+
+// File writes each message as an .eml file to Dir instead of sending it
+type File struct {
+	From string
+	Dir  string
+	seq  uint64
+}
+
+func (f *File) Send(msg *Message) error {
+	raw, err := msg.Bytes(f.From)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(f.Dir, 0744); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102T150405"), atomic.AddUint64(&f.seq, 1))
+	return os.WriteFile(filepath.Join(f.Dir, name), raw, 0644)
+}
+
+// Log logs each message instead of sending it
+type Log struct {
+	From string
+	L    *zap.SugaredLogger
+}
+
+func (l *Log) Send(msg *Message) error {
+	raw, err := msg.Bytes(l.From)
+	if err != nil {
+		return err
+	}
+	l.L.Infow("outgoing email", "to", msg.To, "subject", msg.Subject, "message", string(raw))
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package mail sends email through one of several drivers: Gmail, plain SMTP or, for tests and
+// development setups, a file or log driver that captures outgoing messages instead of sending them.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"time"
+)
+
+// ErrNotConnected is returned by drivers that depend on a service that is not available
+var ErrNotConnected = errors.New("mail service is not connected")
+
+// Message is an email with a plain text and/or an html body
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Mailer sends messages from a configured sender
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// Bytes returns msg as an RFC 5322 message from from. If both a text and an html body are given
+// the message is multipart/alternative with the text part first
+func (msg *Message) Bytes(from string) ([]byte, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	toAddr, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	var b bytes.Buffer
+	b.WriteString("From: " + fromAddr.String() + "\r\n")
+	b.WriteString("To: " + toAddr.String() + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.Html == "" || msg.Text == "" {
+		contentType, body := "text/plain", msg.Text
+		if msg.Html != "" {
+			contentType, body = "text/html", msg.Html
+		}
+		b.WriteString("Content-Type: " + contentType + "; charset=UTF-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err = writeQuoted(&b, body); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&b)
+	b.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+	if err = writePart(mw, "text/plain", msg.Text); err != nil {
+		return nil, err
+	}
+	if err = writePart(mw, "text/html", msg.Html); err != nil {
+		return nil, err
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writePart(mw *multipart.Writer, contentType, body string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=UTF-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	w, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return writeQuoted(w, body)
+}
+
+func writeQuoted(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// addresses returns the bare sender and recipient addresses of msg, as used in an SMTP envelope
+func (msg *Message) addresses(from string) (string, string, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", "", err
+	}
+	toAddr, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return "", "", err
+	}
+	return fromAddr.Address, toAddr.Address, nil
+}
@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFrom = "Mellowtech Photos <photos@example.com>"
+
+func TestMultipart(t *testing.T) {
+	msg := Message{To: "guest@example.com", Subject: "Välkommen", Text: "Hello guest", Html: "<b>Hello guest</b>"}
+	raw, err := msg.Bytes(testFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("could not parse message: %v", err)
+	}
+	if from, _ := m.Header.AddressList("From"); len(from) != 1 || from[0].Address != "photos@example.com" {
+		t.Errorf("expected photos@example.com got %v", from)
+	}
+	if subj, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject")); subj != "Välkommen" {
+		t.Errorf("expected Välkommen got %s", subj)
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative got %s %v", mediaType, err)
+	}
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	for _, exp := range []string{"text/plain", "text/html"} {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("expected %s part got %v", exp, err)
+		}
+		if ct := p.Header.Get("Content-Type"); !strings.HasPrefix(ct, exp) {
+			t.Errorf("expected %s got %s", exp, ct)
+		}
+		body, _ := io.ReadAll(p)
+		if !strings.Contains(string(body), "Hello guest") {
+			t.Errorf("unexpected body %s", body)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("expected 2 parts")
+	}
+}
+
+func TestInvalidAddress(t *testing.T) {
+	msg := Message{To: "not an address", Subject: "subject", Text: "text"}
+	if _, err := msg.Bytes(testFrom); err == nil {
+		t.Errorf("expected invalid recipient error")
+	}
+}
+
+func TestFile(t *testing.T) {
+	f := File{From: testFrom, Dir: filepath.Join(t.TempDir(), "mail")}
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := f.Send(&Message{To: to, Subject: "subject", Text: "text"}); err != nil {
+			t.Fatalf("could not send: %v", err)
+		}
+	}
+	files, err := os.ReadDir(f.Dir)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected 2 messages got %v %v", files, err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(f.Dir, files[0].Name()))
+	if m, err := mail.ReadMessage(strings.NewReader(string(raw))); err != nil {
+		t.Errorf("could not parse message: %v", err)
+	} else if ct := m.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain got %s", ct)
+	}
+}
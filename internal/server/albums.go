@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
 	"net/http"
 )
 
@@ -100,7 +101,7 @@ func (s *mserver) handleAlbumPhotos(_ http.ResponseWriter, r *http.Request) (int
 	if photos, err := s.pg.Album.SelectPhotos(album.Id, filter, page, order); err != nil {
 		return nil, err
 	} else {
-		return PhotoFiles{Photos: photos, Length: len(photos)}, nil
+		return PhotoFiles{Photos: photos, Length: len(photos)}, s.withReactions(photos...)
 	}
 
 }
@@ -110,7 +111,13 @@ func (s *mserver) handleDeleteAlbum(r *http.Request) (interface{}, error) {
 		return nil, BadRequestError("Could not parse album id")
 	} else {
 		ret, _ := s.pg.Album.Get(id)
-		return ret, s.pg.Album.Delete(id)
+		if err = s.pg.Album.Delete(id); err != nil {
+			return nil, err
+		}
+		if ret != nil {
+			s.publishAlbum(events.AlbumDeleted, ret)
+		}
+		return ret, nil
 	}
 }
 
@@ -127,7 +134,12 @@ func (s *mserver) handleAddAlbum(r *http.Request) (interface{}, error) {
 	if s.pg.Album.HasByName(param.Name) {
 		return nil, BadRequestError("Album name in use")
 	}
-	return s.pg.Album.Add(param.Name, param.Description, param.CoverPic)
+	album, err := s.pg.Album.Add(param.Name, param.Description, param.CoverPic)
+	if err != nil {
+		return nil, err
+	}
+	s.publishAlbum(events.AlbumAdded, album)
+	return album, nil
 }
 
 func (s *mserver) handleAddAlbumPhotos(r *http.Request) (interface{}, error) {
@@ -146,6 +158,7 @@ func (s *mserver) handleAddAlbumPhotos(r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.albumChanged(id)
 
 	return AffectedItems{NumItems: rows}, err
 }
@@ -159,6 +172,7 @@ func (s *mserver) handleClearAlbumPhotos(r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.albumChanged(id)
 	return AffectedItems{NumItems: rows}, err
 }
 
@@ -178,6 +192,7 @@ func (s *mserver) handleDeleteAlbumPhotos(r *http.Request) (interface{}, error)
 	if err != nil {
 		return nil, err
 	}
+	s.albumChanged(id)
 	return AffectedItems{NumItems: rows}, err
 }
 
@@ -197,6 +212,7 @@ func (s *mserver) handleSetAlbumPhotos(r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.albumChanged(id)
 
 	return AffectedItems{NumItems: rows}, err
 }
@@ -206,11 +222,18 @@ func (s *mserver) handleUpdateAlbum(r *http.Request) (interface{}, error) {
 	if err := decodeRequest(r, &a); err != nil {
 		return nil, err
 	}
-	if s.pg.Album.Has(a.Id) {
-		return s.pg.Album.Update(&a)
-	} else {
+	if !s.pg.Album.Has(a.Id) {
 		return nil, NotFoundError("Album not found")
 	}
+	if a.MaxSelections < 0 {
+		return nil, BadRequestError("Max selections cannot be negative")
+	}
+	album, err := s.pg.Album.Update(&a)
+	if err != nil {
+		return nil, err
+	}
+	s.publishAlbum(events.AlbumUpdated, album)
+	return album, nil
 }
 
 func (s *mserver) handleUpdateOrder(r *http.Request) (interface{}, error) {
@@ -229,10 +252,14 @@ func (s *mserver) handleUpdateOrder(r *http.Request) (interface{}, error) {
 	}
 	fmt.Println("This is number of photos in order: ", len(param.Photos))
 	fmt.Println("This is photos: ", param.Photos)
-	if s.pg.Album.Has(id) {
-		return s.pg.Album.UpdateOrder(id, param.Photos)
-	} else {
+	if !s.pg.Album.Has(id) {
 		return nil, NotFoundError("Album not found")
 	}
+	album, err := s.pg.Album.UpdateOrder(id, param.Photos)
+	if err != nil {
+		return nil, err
+	}
+	s.publishAlbum(events.AlbumUpdated, album)
+	return album, nil
 
 }
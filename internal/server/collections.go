@@ -0,0 +1,264 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Guests can keep named collections of photos, e.g. to pick prints. A collection is private to
+// the guest until it is shared, after which the owner can view it, list its file names and turn
+// it into an album. The owner can never change a guest's collection
+
+// CollectionPhotos is a collection with its photos in the order they were added
+type CollectionPhotos struct {
+	*dao.Collection
+	Guest  string       `json:"guest,omitempty"`
+	Photos []*dao.Photo `json:"photos"`
+}
+
+// OwnerCollection is a shared collection as listed for the owner
+type OwnerCollection struct {
+	*dao.Collection
+	Guest     string `json:"guest"`
+	NumPhotos int    `json:"numPhotos"`
+}
+
+func (s *mserver) collection(r *http.Request) (*dao.Collection, error) {
+	var id uuid.UUID
+	if err := uid(r, "collectionid", &id); err != nil {
+		return nil, err
+	}
+	c, err := s.pg.Collection.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NotFoundError("Collection not found")
+	}
+	return c, err
+}
+
+// guestCollection returns the collection in the request if it belongs to guestId
+func (s *mserver) guestCollection(r *http.Request, guestId uuid.UUID) (*dao.Collection, error) {
+	c, err := s.collection(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.GuestId != guestId {
+		return nil, NotFoundError("Collection not found")
+	}
+	return c, nil
+}
+
+// sharedCollection returns the collection in the request if it has been shared with the owner
+func (s *mserver) sharedCollection(r *http.Request) (*dao.Collection, error) {
+	c, err := s.collection(r)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Shared {
+		return nil, NotFoundError("Collection not found")
+	}
+	return c, nil
+}
+
+func (s *mserver) collectionPhotos(c *dao.Collection) (*CollectionPhotos, error) {
+	photos, err := s.pg.Collection.Photos(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionPhotos{Collection: c, Photos: photos}, s.withReactions(photos...)
+}
+
+func (s *mserver) handleGuestCollections(_ *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.pg.Collection.ListByGuest(guestId)
+}
+
+func (s *mserver) handleGuestCollection(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	if c, err := s.guestCollection(r, guestId); err != nil {
+		return nil, err
+	} else {
+		return s.collectionPhotos(c)
+	}
+}
+
+func (s *mserver) handleAddCollection(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Name   string
+		Shared bool
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Name) == "" {
+		return nil, BadRequestError("Collection name cannot be empty")
+	}
+	c := dao.Collection{GuestId: guestId, Name: params.Name, Shared: params.Shared, Created: time.Now()}
+	if err := s.pg.Collection.Add(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// handleUpdateCollection renames a collection or changes whether it is shared with the owner
+func (s *mserver) handleUpdateCollection(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Name   string
+		Shared *bool
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	c, err := s.guestCollection(r, guestId)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Name) != "" {
+		c.Name = params.Name
+	}
+	if params.Shared != nil {
+		c.Shared = *params.Shared
+	}
+	return s.pg.Collection.Update(c)
+}
+
+func (s *mserver) handleDeleteCollection(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	c, err := s.guestCollection(r, guestId)
+	if err != nil {
+		return nil, err
+	}
+	return c, s.pg.Collection.Delete(c.Id)
+}
+
+func (s *mserver) handleAddCollectionPhotos(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.updateCollectionPhotos(r, guestId, true)
+}
+
+func (s *mserver) handleDeleteCollectionPhotos(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.updateCollectionPhotos(r, guestId, false)
+}
+
+func (s *mserver) updateCollectionPhotos(r *http.Request, guestId uuid.UUID, add bool) (interface{}, error) {
+	type request struct {
+		PhotoIds []uuid.UUID
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	c, err := s.guestCollection(r, guestId)
+	if err != nil {
+		return nil, err
+	}
+	var rows int
+	if add {
+		rows, err = s.pg.Collection.AddPhotos(c.Id, params.PhotoIds)
+	} else {
+		rows, err = s.pg.Collection.DeletePhotos(c.Id, params.PhotoIds)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AffectedItems{NumItems: rows}, nil
+}
+
+// handleCollections lists the collections guests have shared with the owner
+func (s *mserver) handleCollections(_ *http.Request) (interface{}, error) {
+	collections, err := s.pg.Collection.ListShared()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*OwnerCollection, len(collections))
+	for i, c := range collections {
+		ret[i] = &OwnerCollection{Collection: c}
+		if g, err := s.pg.Guest.Get(c.GuestId); err == nil {
+			ret[i].Guest = g.Name
+		}
+		if ids, err := s.pg.Collection.PhotoIds(c.Id); err == nil {
+			ret[i].NumPhotos = len(ids)
+		}
+	}
+	return ret, nil
+}
+
+func (s *mserver) handleCollection(r *http.Request) (interface{}, error) {
+	c, err := s.sharedCollection(r)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := s.collectionPhotos(c)
+	if err != nil {
+		return nil, err
+	}
+	if g, err := s.pg.Guest.Get(c.GuestId); err == nil {
+		ret.Guest = g.Name
+	}
+	return ret, nil
+}
+
+// handleCollectionFiles returns the file names of the photos in a shared collection
+func (s *mserver) handleCollectionFiles(r *http.Request) (interface{}, error) {
+	c, err := s.sharedCollection(r)
+	if err != nil {
+		return nil, err
+	}
+	photos, err := s.pg.Collection.Photos(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(photos))
+	for i, p := range photos {
+		ret[i] = p.FileName
+	}
+	return ret, nil
+}
+
+// handleCollectionAlbum creates an album with the photos of a shared collection
+func (s *mserver) handleCollectionAlbum(r *http.Request) (interface{}, error) {
+	type request struct {
+		Name        string
+		Description string
+		CoverPic    string
+		Code        string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	c, err := s.sharedCollection(r)
+	if err != nil {
+		return nil, err
+	}
+	if params.Name == "" {
+		params.Name = c.Name
+	}
+	if s.pg.Album.HasByName(params.Name) {
+		return nil, BadRequestError("Album name in use")
+	}
+	photoIds, err := s.pg.Collection.PhotoIds(c.Id)
+	if err != nil {
+		return nil, err
+	}
+	album, err := s.pg.Album.Add(params.Name, params.Description, params.CoverPic)
+	if err != nil {
+		return nil, err
+	}
+	if params.Code != "" {
+		album.Code = params.Code
+		if album, err = s.pg.Album.Update(album); err != nil {
+			return nil, err
+		}
+	}
+	if len(photoIds) > 0 {
+		if _, err = s.pg.Album.AddPhotos(album.Id, photoIds); err != nil {
+			return nil, err
+		}
+	}
+	s.publishAlbum(events.AlbumAdded, album)
+	return album, nil
+}
@@ -0,0 +1,381 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Guest comments are moderated according to the owner's ModerationPolicy. Comments that are not
+// approved are only visible to the owner, who can approve, reject, edit and delete them
+
+const moderationKey = "moderation"
+
+// ModerationPolicy decides the state of new comments. Comments by verified guests are approved
+// if ApproveVerified is set, unless HoldFirst is set and the guest has no approved comments yet.
+// All other comments are held as pending. Guests can edit their comments for EditMinutes after
+// posting them, or at any time if EditMinutes is 0. Spam controls the checks that refuse or hold
+// comments that look like spam
+type ModerationPolicy struct {
+	ApproveVerified bool       `json:"approveVerified"`
+	HoldFirst       bool       `json:"holdFirst"`
+	EditMinutes     int        `json:"editMinutes"`
+	Spam            SpamPolicy `json:"spam"`
+}
+
+// ThreadComment is a comment with its replies, oldest reply first
+type ThreadComment struct {
+	Id      int              `json:"id"`
+	Name    string           `json:"name"`
+	PhotoId uuid.UUID        `json:"photoId"`
+	Time    time.Time        `json:"time"`
+	Body    string           `json:"body"`
+	State   string           `json:"state,omitempty"`
+	Owner   bool             `json:"owner"`
+	Edited  time.Time        `json:"edited"`
+	Replies []*ThreadComment `json:"replies"`
+}
+
+// ReplyEmail is sent to a guest when the owner replies to their comment
+type ReplyEmail struct {
+	Name    string
+	Owner   string
+	Comment string
+	Reply   string
+}
+
+// ModerationComment is a comment as seen by the owner
+type ModerationComment struct {
+	*dao.Comment
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// commentAuthor returns the name of the owner or guest that wrote c
+func (s *mserver) commentAuthor(c *dao.Comment) string {
+	if c.Owner {
+		return s.ownerName()
+	} else if g, err := s.pg.Guest.Get(c.GuestId); err == nil {
+		return g.Name
+	}
+	return ""
+}
+
+// ownerName returns the name of the owner, or "" if the user can not be read
+func (s *mserver) ownerName() string {
+	if u, err := s.pg.User.Get(); err == nil {
+		return u.Name
+	}
+	return ""
+}
+
+// authorName returns the name of the author of c given the name of the owner
+func authorName(c *dao.AuthoredComment, owner string) string {
+	if c.Owner {
+		return owner
+	}
+	return c.Name
+}
+
+// commentThreads arranges comments, latest first, into threads. Replies to comments that are
+// not in comments are left out. States are only included for the owner
+func (s *mserver) commentThreads(comments []*dao.AuthoredComment, owner bool) []*ThreadComment {
+	ownerName := s.ownerName()
+	nodes := make(map[int]*ThreadComment, len(comments))
+	for _, c := range comments {
+		tc := &ThreadComment{Id: c.Id, Name: authorName(c, ownerName), PhotoId: c.PhotoId, Time: c.Time, Body: c.Body,
+			Owner: c.Owner, Edited: c.Edited, Replies: []*ThreadComment{}}
+		if owner {
+			tc.State = c.State
+		}
+		nodes[c.Id] = tc
+	}
+	ret := []*ThreadComment{}
+	//walk from the oldest comment so that replies are in order
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if c.ParentId == 0 {
+			ret = append([]*ThreadComment{nodes[c.Id]}, ret...)
+		} else if parent, found := nodes[c.ParentId]; found {
+			parent.Replies = append(parent.Replies, nodes[c.Id])
+		}
+	}
+	return ret
+}
+
+func (s *mserver) moderationPolicy() (*ModerationPolicy, error) {
+	policy := &ModerationPolicy{ApproveVerified: true, Spam: defaultSpamPolicy}
+	if err := s.configValue(moderationKey, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// newCommentState returns the state of a new comment by guestId
+func (s *mserver) newCommentState(guestId uuid.UUID) string {
+	policy, err := s.moderationPolicy()
+	if err != nil {
+		s.l.Errorw("could not read moderation policy", zap.Error(err))
+		return dao.CommentPending
+	}
+	guest, err := s.pg.Guest.Get(guestId)
+	if err != nil || !guest.Verified || !policy.ApproveVerified {
+		return dao.CommentPending
+	}
+	if policy.HoldFirst {
+		if cnt, err := s.pg.Comment.CountByGuest(guestId, dao.CommentApproved); err != nil || cnt == 0 {
+			return dao.CommentPending
+		}
+	}
+	return dao.CommentApproved
+}
+
+func (s *mserver) comment(r *http.Request) (*dao.Comment, error) {
+	id, err := strconv.Atoi(Var(r, "commentid"))
+	if err != nil {
+		return nil, BadRequestError("Could not parse comment id")
+	}
+	c, err := s.pg.Comment.Get(id)
+	if err != nil {
+		return nil, NotFoundError("Comment not found")
+	}
+	return c, nil
+}
+
+// handleComments lists comments for moderation. The state query parameter is a comma separated
+// list of states, all states if empty
+func (s *mserver) handleComments(r *http.Request) (interface{}, error) {
+	var states []string
+	if st := r.URL.Query().Get("state"); st != "" {
+		states = strings.Split(strings.ToUpper(st), ",")
+	}
+	for _, st := range states {
+		if !isCommentState(st) {
+			return nil, BadRequestError("Unknown comment state: " + st)
+		}
+	}
+	comments, err := s.pg.Comment.List(states...)
+	if err != nil {
+		return nil, err
+	}
+	ownerName := s.ownerName()
+	ret := []*ModerationComment{}
+	for _, c := range comments {
+		ret = append(ret, &ModerationComment{Comment: &c.Comment, Name: authorName(c, ownerName), Email: c.Email})
+	}
+	return ret, nil
+}
+
+func isCommentState(state string) bool {
+	switch state {
+	case dao.CommentPending, dao.CommentHeld, dao.CommentApproved, dao.CommentRejected, dao.CommentSpam:
+		return true
+	}
+	return false
+}
+
+func (s *mserver) setCommentState(r *http.Request, state string) (interface{}, error) {
+	c, err := s.comment(r)
+	if err != nil {
+		return nil, err
+	}
+	c.State = state
+	if c, err = s.pg.Comment.Update(c); err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentUpdated, c)
+	return c, nil
+}
+
+func (s *mserver) handleApproveComment(r *http.Request) (interface{}, error) {
+	return s.setCommentState(r, dao.CommentApproved)
+}
+
+func (s *mserver) handleRejectComment(r *http.Request) (interface{}, error) {
+	return s.setCommentState(r, dao.CommentRejected)
+}
+
+func (s *mserver) handleSpamComment(r *http.Request) (interface{}, error) {
+	return s.setCommentState(r, dao.CommentSpam)
+}
+
+func (s *mserver) handleEditComment(r *http.Request) (interface{}, error) {
+	type request struct {
+		Body string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Body) == "" {
+		return nil, BadRequestError("Comment cannot be empty")
+	}
+	c, err := s.comment(r)
+	if err != nil {
+		return nil, err
+	}
+	c.Body, c.Edited = params.Body, time.Now()
+	if c, err = s.pg.Comment.Update(c); err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentUpdated, c)
+	return c, nil
+}
+
+// handleReplyComment adds a reply by the owner. If notify is set the guest that wrote the comment
+// is emailed the reply
+func (s *mserver) handleReplyComment(r *http.Request) (interface{}, error) {
+	type request struct {
+		Body   string
+		Notify bool
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Body) == "" {
+		return nil, BadRequestError("Comment cannot be empty")
+	}
+	parent, err := s.comment(r)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.pg.Comment.Add(&dao.Comment{PhotoId: parent.PhotoId, Body: params.Body, State: dao.CommentApproved,
+		ParentId: parent.Id, Owner: true})
+	if err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentAdded, reply)
+	if params.Notify && !parent.Owner {
+		if err = s.sendReplyEmail(parent, reply); err != nil {
+			s.l.Errorw("could not email comment reply", "comment", parent.Id, zap.Error(err))
+		}
+	}
+	return reply, nil
+}
+
+func (s *mserver) sendReplyEmail(parent, reply *dao.Comment) error {
+	guest, err := s.pg.Guest.Get(parent.GuestId)
+	if err != nil {
+		return err
+	}
+	if !guest.Verified {
+		return fmt.Errorf("guest has not verified their email")
+	}
+	re := ReplyEmail{Name: guest.Name, Owner: s.commentAuthor(reply), Comment: parent.Body, Reply: reply.Body}
+	return s.sendMail(guest.Email, "New reply to your comment on Mellowtech Photos", "reply-email", re)
+}
+
+func (s *mserver) handleDeleteComment(r *http.Request) (interface{}, error) {
+	c, err := s.comment(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.pg.Comment.Delete(c.Id); err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentDeleted, c)
+	return c, nil
+}
+
+func (s *mserver) handleGuestComments(_ *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.pg.Comment.ListByGuest(guestId)
+}
+
+// guestComment returns the comment in the request if it was written by guestId
+func (s *mserver) guestComment(r *http.Request, guestId uuid.UUID) (*dao.Comment, error) {
+	c, err := s.comment(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.Owner || c.GuestId != guestId {
+		return nil, NotFoundError("Comment not found")
+	}
+	return c, nil
+}
+
+// handleGuestEditComment lets a guest edit their own comment. An approved comment is moderated
+// again as if it was new
+func (s *mserver) handleGuestEditComment(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Body string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(params.Body) == "" {
+		return nil, BadRequestError("Comment cannot be empty")
+	}
+	c, err := s.guestComment(r, guestId)
+	if err != nil {
+		return nil, err
+	}
+	if c.State == dao.CommentRejected || c.State == dao.CommentSpam {
+		return nil, BadRequestError("Comment cannot be edited")
+	}
+	policy, err := s.moderationPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy.EditMinutes > 0 && time.Since(c.Time) > time.Duration(policy.EditMinutes)*time.Minute {
+		return nil, BadRequestError(fmt.Sprintf("Comments can only be edited within %d minutes", policy.EditMinutes))
+	}
+	state, flag, err := s.screenComment(r, guestId, c.Id, params.Body, "")
+	if err != nil {
+		return nil, err
+	}
+	c.Body, c.Edited = params.Body, time.Now()
+	if flag != "" || c.State != dao.CommentPending {
+		c.State, c.Flag = state, flag
+	}
+	if c, err = s.pg.Comment.Update(c); err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentUpdated, c)
+	return c, nil
+}
+
+// handleGuestDeleteComment lets a guest delete their own comment, including any replies to it
+func (s *mserver) handleGuestDeleteComment(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	c, err := s.guestComment(r, guestId)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.pg.Comment.Delete(c.Id); err != nil {
+		return nil, err
+	}
+	s.publishComment(events.CommentDeleted, c)
+	return c, nil
+}
+
+func (s *mserver) handleModerationPolicy(_ *http.Request) (interface{}, error) {
+	return s.moderationPolicy()
+}
+
+// handleUpdateModerationPolicy updates the fields of the policy that are in the request
+func (s *mserver) handleUpdateModerationPolicy(r *http.Request) (interface{}, error) {
+	policy, err := s.moderationPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeRequest(r, policy); err != nil {
+		return nil, err
+	}
+	if policy.EditMinutes < 0 {
+		return nil, BadRequestError("Edit minutes cannot be negative")
+	}
+	if sp := policy.Spam; sp.MaxLength < 0 || sp.GuestPerHour < 0 || sp.IpPerHour < 0 || sp.MaxLinks < 0 {
+		return nil, BadRequestError("Spam limits cannot be negative")
+	}
+	if err := s.setConfigValue(moderationKey, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -1,24 +1,20 @@
 package server
 
 import (
-	"fmt"
+	"errors"
 	"github.com/google/uuid"
-	"github.com/msvens/mimage/metadata"
-	"github.com/msvens/mphotos/internal/config"
 	"github.com/msvens/mphotos/internal/dao"
 	"github.com/msvens/mphotos/internal/gdrive"
+	"github.com/msvens/mphotos/internal/ingest"
 	"go.uber.org/zap"
 	"google.golang.org/api/drive/v3"
-	"math"
 	"net/http"
 	"os"
-	"strings"
-	"sync"
 	"time"
 )
 
 const (
-	fileFields = "id, name, kind, mimeType, md5Checksum, createdTime"
+	fileFields = "id, name, kind, mimeType, md5Checksum, createdTime, modifiedTime, parents, trashed, description, starred, properties"
 )
 
 type DriveFile struct {
@@ -78,115 +74,33 @@ func (s *mserver) handleDisconnectDrive(_ *http.Request) (interface{}, error) {
 }
 
 func (s *mserver) handleCheckDrive(_ *http.Request) (interface{}, error) {
-	if files, err := checkDrivePhotos(s); err != nil {
+	if c, err := pendingDriveChanges(s); err != nil {
 		return nil, err
 	} else {
-		return toDriveFiles(files), nil
+		return toDriveFiles(c.added), nil
 	}
 }
 
-func addDrivePhoto(s *mserver, f *drive.File) (bool, error) {
-	var err error
-	if s.pg.Photo.HasMd5(f.Md5Checksum) {
-		return false, nil
-	}
-	photo := dao.Photo{}
-	photo.Id = uuid.New()
-	photo.SourceId = f.Id
-	photo.Md5 = f.Md5Checksum
-	photo.Source = dao.SourceGoogle
-
-	//photo.FileName = f.Id + ".jpg"
-	photo.FileName = photo.Id.String() + ".jpg" //use the same filename naming convention for gdrive and local files
-	if t, err := gdrive.ParseTime(f.CreatedTime); err == nil {
-		photo.SourceDate = t
-	}
-	photo.UploadDate = time.Now()
-
-	if err = downloadDrivePhoto(s, &photo); err != nil {
-		s.l.Errorw("error downloading img", zap.Error(err))
-		return false, err
-	}
-	var md *metadata.MetaData
-
-	if md, err = metadata.NewMetaDataFromFile(config.PhotoFilePath(config.Original, photo.FileName)); err == nil {
-		photo.CameraMake = md.Summary().CameraMake
-		photo.CameraModel = md.Summary().CameraModel
-		photo.FocalLength = fmt.Sprintf("%v mm", md.Summary().FocalLength.Float32())
-		photo.FocalLength35 = fmt.Sprintf("%v mm", md.Summary().FocalLengthIn35mmFormat)
-		photo.LensMake = md.Summary().LensMake
-		photo.LensModel = md.Summary().LensModel
-		photo.Exposure = md.Summary().ExposureTime.String()
-		photo.Width = md.ImageWidth
-		photo.Height = md.ImageHeight
-		photo.FNumber = md.Summary().FNumber.Float32()
-		photo.Iso = uint(md.Summary().ISO)
-		photo.Title = md.Summary().Title
-		if len(md.Summary().Keywords) > 0 {
-			photo.Keywords = strings.Join(md.Summary().Keywords, ",")
-		}
-		if md.Summary().OriginalDate.IsZero() {
-			photo.OriginalDate = photo.SourceDate
-		} else {
-			photo.OriginalDate = md.Summary().OriginalDate
+// addDrivePhoto imports f through the ingest pipeline, downloading it with ds. Failures that
+// only concern f are reported in the result, an error is returned if Drive is unavailable
+func addDrivePhoto(s *mserver, ds *gdrive.DriveService, f *drive.File, path string) (*ingest.Result, error) {
+	res := &ingest.Result{Name: f.Name}
+	photo, err := s.ingest.Add(ingest.NewDriveItem(ds, f, path))
+	switch {
+	case err == nil:
+		res.Status, res.PhotoId = ingest.StatusAdded, photo.Id
+	case errors.Is(err, ingest.ErrExists):
+		res.Status = ingest.StatusDuplicate
+		if photo != nil {
+			res.PhotoId = photo.Id
 		}
-	} else {
-		return false, err
-	}
-
-	if err = s.pg.Photo.Add(&photo, md.Summary()); err != nil {
-		s.l.Errorw("error adding img: ", zap.Error(err))
-		return false, err
-	}
-
-	if !s.pg.Camera.HasModel(photo.CameraModel) {
-		if err = s.pg.Camera.AddFromPhoto(&photo); err != nil {
-			s.l.Fatalw("error adding camera model: ", zap.Error(err))
-		}
-	}
-	s.l.Infow("added img", "driveId", photo.Id)
-	return true, nil
-}
-
-func addDrivePhotos(s *mserver) (*DriveFiles, error) {
-	fl, err := listDriveFiles(s)
-	if err != nil {
+	case gdrive.IsUnavailable(err):
 		return nil, err
+	default:
+		s.l.Errorw("error adding drive img", "driveId", f.Id, zap.Error(err))
+		res.Status, res.Error = ingest.StatusFailed, err.Error()
 	}
-
-	var files []*drive.File
-	for _, f := range fl {
-		added, err := addDrivePhoto(s, f)
-		if err != nil {
-			return nil, err
-		}
-		if added {
-			files = append(files, f)
-		}
-	}
-	return toDriveFiles(files), nil
-}
-
-func downloadDrivePhoto(s *mserver, photo *dao.Photo) error {
-	if _, err := s.ds.Download(photo.SourceId, config.PhotoFilePath(config.Original, photo.FileName)); err != nil {
-		return err
-	}
-	//create img versions
-	return dao.GenerateImages(photo.FileName)
-}
-
-func checkDrivePhotos(s *mserver) ([]*drive.File, error) {
-	fl, err := listDriveFiles(s)
-	if err != nil {
-		return nil, err
-	}
-	var ret []*drive.File
-	for _, f := range fl {
-		if !s.pg.Photo.HasMd5(f.Md5Checksum) {
-			ret = append(ret, f)
-		}
-	}
-	return ret, nil
+	return res, nil
 }
 
 func listDriveFiles(s *mserver) ([]*drive.File, error) {
@@ -238,94 +152,147 @@ func toDriveFiles(files []*drive.File) *DriveFiles {
 
 // async
 func (s *mserver) handleScheduleDriveJob(_ *http.Request) (interface{}, error) {
-	fl, err := checkDrivePhotos(s)
+	job, err := s.scheduleDriveImport()
 	if err != nil {
 		return nil, err
 	}
-	job := Job{}
-	job.Id = uuid.New().String()
-	job.files = fl
-	job.s = s
-	job.NumFiles = len(fl)
-	job.State = StateScheduled
-	jobMap[job.Id] = &job
-	jobChan <- &job
-	return &job, nil
+	return job.Status(), nil
 }
 
-func (s *mserver) handleStatusDriveJob(r *http.Request) (interface{}, error) {
-	if job, found := jobMap[Var(r, "jobid")]; found {
-		return job, nil
-	} else {
-		return nil, NotFoundError("job not found")
+// errDriveSyncRunning is returned when a Drive sync is requested while another one is
+// scheduled or running
+var errDriveSyncRunning = newError(http.StatusConflict, "A Drive sync is already running")
+
+// lockDriveSync takes the lock that is held while Drive changes are listed and applied, so
+// that only one sync runs at a time
+func (s *mserver) lockDriveSync() error {
+	if !s.driveSync.TryLock() {
+		return errDriveSyncRunning
 	}
+	return nil
 }
 
-const StateScheduled = "SCHEDULED"
-const StateStarted = "STARTED"
-const StateFinished = "FINISHED"
-const StateAborted = "ABORTED"
-
-type Job struct {
-	Id           string `json:"id"`
-	State        string `json:"state"`
-	Percent      int    `json:"percent"`
-	files        []*drive.File
-	s            *mserver
-	NumFiles     int       `json:"numFiles"`
-	NumProcessed int       `json:"numProcessed"`
-	Err          *ApiError `json:"error,omitempty"`
+// driveSyncRunning reports whether a Drive sync is running or a Drive import job is scheduled
+func (s *mserver) driveSyncRunning() bool {
+	if s.jobs.hasActive(JobDriveImport) || !s.driveSync.TryLock() {
+		return true
+	}
+	s.driveSync.Unlock()
+	return false
 }
 
-var jobChan = make(chan *Job, 10)
-var wg sync.WaitGroup
-var jobMap = make(map[string]*Job)
-
-func worker(jobChan <-chan *Job) {
-
-	defer wg.Done()
-
-	for job := range jobChan {
-		job.s.l.Infow("Processing job", "jobid", job.Id, "files", job.NumFiles)
-		process(job)
+// scheduleDriveImport schedules a Drive import job unless a Drive sync is already scheduled
+// or running
+func (s *mserver) scheduleDriveImport() (*Job, error) {
+	if s.ds == nil {
+		return nil, UnauthorizedError("No Drive Service Connected")
+	}
+	if s.driveSyncRunning() {
+		return nil, errDriveSyncRunning
 	}
+	job, err := newJob(s, JobDriveImport, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.jobs.schedule(job); err != nil {
+		return nil, err
+	}
+	return job, nil
 }
 
-func process(job *Job) {
-
-	/*
-		tool, err := mexif.NewMExifTool()
-		defer tool.Close()
+func driveImportTask(job *Job) error {
+	if err := job.s.lockDriveSync(); err != nil {
+		return err
+	}
+	defer job.s.driveSync.Unlock()
+	c, err := pendingDriveChanges(job.s)
+	if err != nil {
+		return err
+	}
+	job.setNumFiles(len(c.added))
+	_, err = applyDriveChanges(job.ctx, job.s, c, func(res *ingest.Result) {
+		job.addResult(res)
+		job.progress()
+	})
+	return err
+}
 
-		if err != nil {
-			finishJob(job, err)
-			return
-		}
-	*/
+func (s *mserver) handleDriveSources(_ *http.Request) (interface{}, error) {
+	return s.pg.Drive.List()
+}
 
-	job.State = StateStarted
+func (s *mserver) handleAddDriveSource(r *http.Request) (interface{}, error) {
+	type request struct {
+		FolderId   string
+		FolderName string
+		Recursive  bool
+		Albums     bool
+	}
+	var param request
+	if err := decodeRequest(r, &param); err != nil {
+		return nil, err
+	}
+	if s.ds == nil {
+		return nil, UnauthorizedError("No Drive Service Connected")
+	}
+	var f *drive.File
+	var err error
+	if param.FolderId != "" {
+		f, err = s.ds.Get(param.FolderId)
+	} else if param.FolderName != "" {
+		f, err = s.ds.GetByName(param.FolderName, true, false, fileFields)
+	} else {
+		return nil, BadRequestError("folderId or folderName needed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.pg.Drive.HasFolder(f.Id) {
+		return nil, BadRequestError("Drive folder already added")
+	}
+	src, err := s.pg.Drive.Add(&dao.DriveSource{FolderId: f.Id, FolderName: f.Name, Recursive: param.Recursive, Albums: param.Albums})
+	if err != nil {
+		return nil, err
+	}
+	return src, resetDrivePageToken(s)
+}
 
-	for _, f := range job.files {
-		if _, err := addDrivePhoto(job.s, f); err != nil {
-			finishJob(job, err)
-			return
-		}
-		job.NumProcessed = job.NumProcessed + 1
-		percent := float64(job.NumProcessed) / float64(job.NumFiles)
-		job.Percent = int(math.Round(percent * 100))
-		job.s.l.Debugw("", "jobid", job.Id, "progress", job.Percent)
+func (s *mserver) handleUpdateDriveSource(r *http.Request) (interface{}, error) {
+	type request struct {
+		Recursive bool
+		Albums    bool
+	}
+	var id uuid.UUID
+	if err := uid(r, "sourceid", &id); err != nil {
+		return nil, err
 	}
-	finishJob(job, nil)
+	var param request
+	if err := decodeRequest(r, &param); err != nil {
+		return nil, err
+	}
+	src, err := s.pg.Drive.Get(id)
+	if err != nil {
+		return nil, NotFoundError("Drive source not found")
+	}
+	src.Recursive = param.Recursive
+	src.Albums = param.Albums
+	if src, err = s.pg.Drive.Update(src); err != nil {
+		return nil, err
+	}
+	return src, resetDrivePageToken(s)
 }
 
-func finishJob(job *Job, err error) {
-	job.files = nil
-	job.s = nil
+func (s *mserver) handleDeleteDriveSource(r *http.Request) (interface{}, error) {
+	var id uuid.UUID
+	if err := uid(r, "sourceid", &id); err != nil {
+		return nil, err
+	}
+	src, err := s.pg.Drive.Get(id)
 	if err != nil {
-		job.State = StateAborted
-		job.Err = ResolveError(err)
-	} else {
-		job.Percent = 100
-		job.State = StateFinished
+		return nil, NotFoundError("Drive source not found")
+	}
+	if err = s.pg.Drive.Delete(id); err != nil {
+		return nil, err
 	}
+	return src, resetDrivePageToken(s)
 }
@@ -0,0 +1,516 @@
+package server
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"github.com/msvens/mphotos/internal/gdrive"
+	"github.com/msvens/mphotos/internal/ingest"
+	"go.uber.org/zap"
+	"google.golang.org/api/drive/v3"
+	"path"
+)
+
+// Drive is synced incrementally using the Drive changes api. The page token is stored with
+// the user once all changes have been applied. Without a token (first sync or after the
+// drive sources have changed) all source folders are listed. The folders of the sources are
+// stored in the drivefolder table and kept up to date from the folder changes, so Drive is
+// only searched for folders that are new to the tree
+
+// driveFolder is a folder covered by one of the drive sources
+type driveFolder struct {
+	source  *dao.DriveSource
+	id      string
+	parent  string
+	name    string
+	path    string    //relative to the source folder, "" for the source folder itself
+	albumId uuid.UUID //uuid.Nil if the folder has not been mapped to an album
+}
+
+// fullPath returns the folder path including the name of the source folder
+func (df *driveFolder) fullPath() string {
+	return path.Join(df.source.FolderName, df.path)
+}
+
+func (df *driveFolder) record() *dao.DriveFolder {
+	return &dao.DriveFolder{Id: df.id, SourceId: df.source.Id, ParentId: df.parent, Name: df.name,
+		Path: df.path, AlbumId: df.albumId}
+}
+
+// driveTree holds the folders covered by the drive sources
+type driveTree struct {
+	folders map[string]*driveFolder
+	albums  map[uuid.UUID]bool //albums that folders are mapped to
+}
+
+func newDriveTree() *driveTree {
+	return &driveTree{folders: map[string]*driveFolder{}, albums: map[uuid.UUID]bool{}}
+}
+
+func (t *driveTree) add(df *driveFolder) {
+	t.folders[df.id] = df
+	if df.albumId != uuid.Nil {
+		t.albums[df.albumId] = true
+	}
+}
+
+// remove removes the folder id and all its subfolders from the tree. Returns the removed
+// folders. The albums of the removed folders are kept as folder albums
+func (t *driveTree) remove(id string) []*driveFolder {
+	df, found := t.folders[id]
+	if !found {
+		return nil
+	}
+	delete(t.folders, id)
+	removed := []*driveFolder{df}
+	for _, c := range t.children(id) {
+		removed = append(removed, t.remove(c.id)...)
+	}
+	return removed
+}
+
+func (t *driveTree) children(id string) []*driveFolder {
+	var ret []*driveFolder
+	for _, df := range t.folders {
+		if df.parent == id {
+			ret = append(ret, df)
+		}
+	}
+	return ret
+}
+
+// repath updates the path of all subfolders of df. Returns the updated folders
+func (t *driveTree) repath(df *driveFolder) []*driveFolder {
+	var updated []*driveFolder
+	for _, c := range t.children(df.id) {
+		c.source, c.path = df.source, path.Join(df.path, c.name)
+		updated = append(updated, c)
+		updated = append(updated, t.repath(c)...)
+	}
+	return updated
+}
+
+// folder returns the first folder in the tree that f is placed in
+func (t *driveTree) folder(f *drive.File) *driveFolder {
+	for _, p := range f.Parents {
+		if df, found := t.folders[p]; found {
+			return df
+		}
+	}
+	return nil
+}
+
+// folderPath returns the path of the folder f is placed in, or "" if it is not in the tree
+func (t *driveTree) folderPath(f *drive.File) string {
+	if df := t.folder(f); df != nil {
+		return df.fullPath()
+	}
+	return ""
+}
+
+// driveChanges are the Drive changes relevant for the library since the last sync
+type driveChanges struct {
+	tree     *driveTree
+	added    []*drive.File   //jpegs in the source folders that are not in the library
+	modified []*drive.File   //imported files whose content has changed in Drive
+	removed  []string        //ids of imported files that were trashed or removed
+	moved    []string        //ids of imported files that were moved out of the source folders
+	placed   []*drive.File   //all jpegs seen in the source folders, used to keep folder albums in sync
+	folders  []*driveFolder  //new or changed folders to store
+	dropped  []string        //ids of folders that are no longer in the tree
+	seen     map[string]bool //ids of the placed files
+	token    string          //page token to store once the changes have been applied
+}
+
+func newDriveChanges(tree *driveTree, token string) *driveChanges {
+	return &driveChanges{tree: tree, token: token, seen: map[string]bool{}}
+}
+
+// place records a jpeg in one of the source folders, and as added if add is set. Files are
+// only recorded once
+func (c *driveChanges) place(f *drive.File, add bool) {
+	if c.seen[f.Id] {
+		return
+	}
+	c.seen[f.Id] = true
+	if add {
+		c.added = append(c.added, f)
+	}
+	c.placed = append(c.placed, f)
+}
+
+// loadDriveTree reads the folders of the drive sources from the database
+func loadDriveTree(s *mserver) (*driveTree, error) {
+	sources, err := s.pg.Drive.List()
+	if err != nil {
+		return nil, err
+	}
+	tree := newDriveTree()
+	for _, src := range sources {
+		tree.add(&driveFolder{source: src, id: src.FolderId, name: src.FolderName})
+		if !src.Recursive {
+			continue
+		}
+		folders, err := s.pg.Drive.Folders(src.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range folders {
+			tree.add(&driveFolder{source: src, id: f.Id, parent: f.ParentId, name: f.Name, path: f.Path, albumId: f.AlbumId})
+		}
+	}
+	return tree, nil
+}
+
+// scanDriveFolder searches Drive for the subfolders of root and adds them to the tree.
+// Returns root and all its subfolders
+func scanDriveFolder(s *mserver, tree *driveTree, root *driveFolder) ([]*driveFolder, error) {
+	scanned := []*driveFolder{root}
+	if !root.source.Recursive {
+		return scanned, nil
+	}
+	seen := map[string]bool{root.id: true}
+	for i := 0; i < len(scanned); i++ {
+		parent := scanned[i]
+		q := gdrive.NewQuery().Parents().In(parent.id).And().MimeType().Eq(gdrive.Folder).TrashedEq(false)
+		children, err := s.ds.SearchAll(q, "id, name")
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if seen[c.Id] {
+				continue
+			}
+			seen[c.Id] = true
+			df, found := tree.folders[c.Id]
+			if found && df.path == "" {
+				continue //the folder of another source
+			} else if !found {
+				df = &driveFolder{id: c.Id}
+				tree.add(df)
+			}
+			df.source, df.parent, df.name, df.path = root.source, parent.id, c.Name, path.Join(parent.path, c.Name)
+			scanned = append(scanned, df)
+		}
+	}
+	return scanned, nil
+}
+
+// listDriveFolders lists the jpegs in folders
+func listDriveFolders(s *mserver, c *driveChanges, folders []*driveFolder) error {
+	for _, df := range folders {
+		fl, err := searchDriveFiles(s, df.id, "")
+		if err != nil {
+			return err
+		}
+		for _, f := range fl {
+			c.place(f, !s.pg.Photo.HasMd5(f.Md5Checksum))
+		}
+	}
+	return nil
+}
+
+func pendingDriveChanges(s *mserver) (*driveChanges, error) {
+	if s.ds == nil {
+		return nil, UnauthorizedError("No Drive Service Connected")
+	}
+	u, err := s.pg.User.Get()
+	if err != nil {
+		return nil, InternalError("user not found")
+	}
+	tree, err := loadDriveTree(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tree.folders) == 0 {
+		return nil, NotFoundError("No Drive folders have been set")
+	}
+	if u.DrivePageToken == "" {
+		return listDriveChanges(s, tree)
+	}
+	changes, token, err := s.ds.Changes(u.DrivePageToken, fileFields)
+	if err != nil {
+		return nil, err
+	}
+	c := newDriveChanges(tree, token)
+	//apply the folder changes first so that files are matched against the current tree
+	for _, ch := range changes {
+		if ch.File != nil && ch.File.MimeType != gdrive.Folder {
+			continue
+		}
+		if err = folderChanged(s, c, ch); err != nil {
+			return nil, err
+		}
+	}
+	for _, ch := range changes {
+		f := ch.File
+		if f != nil && f.MimeType == gdrive.Folder {
+			continue
+		}
+		inFolder := !ch.Removed && f != nil && !f.Trashed && tree.folder(f) != nil
+		photo, err := s.pg.Photo.GetBySource(dao.SourceGoogle, ch.FileId)
+		imported := err == nil
+		switch {
+		case !inFolder:
+			if !imported {
+				continue
+			}
+			if ch.Removed || f == nil || f.Trashed {
+				c.removed = append(c.removed, ch.FileId)
+			} else {
+				c.moved = append(c.moved, ch.FileId)
+			}
+			continue
+		case f.MimeType != gdrive.Jpeg:
+			continue
+		case imported:
+			if photo.Md5 != f.Md5Checksum {
+				c.modified = append(c.modified, f)
+			}
+			c.place(f, false)
+		default:
+			c.place(f, !s.pg.Photo.HasMd5(f.Md5Checksum))
+		}
+	}
+	return c, nil
+}
+
+// folderChanged updates the tree from a change to a folder (or a removed file). Folders that
+// are new to the tree are searched for subfolders and jpegs
+func folderChanged(s *mserver, c *driveChanges, ch *drive.Change) error {
+	t := c.tree
+	df, found := t.folders[ch.FileId]
+	if found && df.path == "" {
+		return nil //source folders are only changed through the drive sources
+	}
+	f := ch.File
+	var parent *driveFolder
+	if !ch.Removed && f != nil && !f.Trashed {
+		parent = t.folder(f)
+	}
+	switch {
+	case parent == nil || !parent.source.Recursive:
+		for _, r := range t.remove(ch.FileId) {
+			c.dropped = append(c.dropped, r.id)
+		}
+	case found:
+		if df.parent == parent.id && df.name == f.Name {
+			return nil
+		}
+		df.source, df.parent, df.name, df.path = parent.source, parent.id, f.Name, path.Join(parent.path, f.Name)
+		c.folders = append(c.folders, df)
+		c.folders = append(c.folders, t.repath(df)...)
+	default:
+		df = &driveFolder{source: parent.source, id: f.Id, parent: parent.id, name: f.Name, path: path.Join(parent.path, f.Name)}
+		t.add(df)
+		scanned, err := scanDriveFolder(s, t, df)
+		if err != nil {
+			return err
+		}
+		c.folders = append(c.folders, scanned...)
+		return listDriveFolders(s, c, scanned)
+	}
+	return nil
+}
+
+// listDriveChanges searches all source folders for subfolders and lists all folders in the
+// tree. The start token is retrieved first so that no changes are missed while listing
+func listDriveChanges(s *mserver, tree *driveTree) (*driveChanges, error) {
+	token, err := s.ds.StartPageToken()
+	if err != nil {
+		return nil, err
+	}
+	var roots []*driveFolder
+	for _, df := range tree.folders {
+		if df.path == "" {
+			roots = append(roots, df)
+		}
+	}
+	c := newDriveChanges(tree, token)
+	visited := map[string]bool{}
+	for _, root := range roots {
+		scanned, err := scanDriveFolder(s, tree, root)
+		if err != nil {
+			return nil, err
+		}
+		for _, df := range scanned {
+			visited[df.id] = true
+		}
+		c.folders = append(c.folders, scanned[1:]...)
+		if err = listDriveFolders(s, c, scanned); err != nil {
+			return nil, err
+		}
+	}
+	for id := range tree.folders {
+		if !visited[id] {
+			delete(tree.folders, id)
+			c.dropped = append(c.dropped, id)
+		}
+	}
+	return c, nil
+}
+
+// storeDriveTree stores the changed folders of c
+func storeDriveTree(s *mserver, c *driveChanges) error {
+	for _, df := range c.folders {
+		if err := s.pg.Drive.AddFolder(df.record()); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.dropped {
+		if err := s.pg.Drive.DeleteFolder(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDriveChanges imports added files, applies the configured policies to modified and
+// removed files, syncs folder albums and stores the new page token. progress (if not nil) is
+// called with the result of each added file. Files that cannot be imported are recorded as
+// failed and skipped. If ctx is done or Drive is unavailable the token is not stored so the
+// changes are picked up again by the next sync
+func applyDriveChanges(ctx context.Context, s *mserver, c *driveChanges, progress ingest.Progress) ([]*drive.File, error) {
+	var added []*drive.File
+	ds := s.ds.WithContext(ctx)
+	for _, f := range c.added {
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+		res, err := addDrivePhoto(s, ds, f, c.tree.folderPath(f))
+		if err != nil {
+			return added, err
+		} else if ctx.Err() != nil {
+			return added, ctx.Err()
+		}
+		if res.Status == ingest.StatusAdded {
+			added = append(added, f)
+		}
+		if progress != nil {
+			progress(res)
+		}
+	}
+	for _, f := range c.modified {
+		if photo, err := s.pg.Photo.GetBySource(dao.SourceGoogle, f.Id); err == nil {
+			driveModified(s, photo, f)
+		}
+	}
+	for _, id := range c.removed {
+		if photo, err := s.pg.Photo.GetBySource(dao.SourceGoogle, id); err == nil {
+			syncFolderAlbums(s, c.tree, photo, nil)
+			driveRemoved(s, photo)
+		}
+	}
+	for _, id := range c.moved {
+		if photo, err := s.pg.Photo.GetBySource(dao.SourceGoogle, id); err == nil {
+			syncFolderAlbums(s, c.tree, photo, nil)
+		}
+	}
+	for _, f := range c.placed {
+		photo, err := s.pg.Photo.GetBySource(dao.SourceGoogle, f.Id)
+		if err != nil {
+			continue
+		}
+		syncFolderAlbums(s, c.tree, photo, c.tree.folder(f))
+		if p, updated, err := s.ingest.UpdateMeta(photo, ingest.NewDriveItem(s.ds, f, c.tree.folderPath(f))); err != nil {
+			s.l.Errorw("could not update drive metadata", "id", photo.Id, zap.Error(err))
+		} else if updated {
+			s.l.Infow("updated drive metadata", "id", photo.Id, "driveId", f.Id)
+			s.publishPhoto(events.PhotoUpdated, p)
+		}
+	}
+	if err := storeDriveTree(s, c); err != nil {
+		s.l.Errorw("could not store drive folders", zap.Error(err))
+		return added, err
+	}
+	u, err := s.pg.User.Get()
+	if err != nil {
+		return added, err
+	}
+	u.DrivePageToken = c.token
+	if _, err = s.pg.User.Update(u); err != nil {
+		s.l.Errorw("could not store drive page token", zap.Error(err))
+		return added, err
+	}
+	return added, nil
+}
+
+// folderAlbum returns the album for df, creating it if needed. Returns uuid.Nil if the
+// source does not map folders to albums
+func folderAlbum(s *mserver, t *driveTree, df *driveFolder) (uuid.UUID, error) {
+	if !df.source.Albums || df.path == "" {
+		return uuid.Nil, nil
+	}
+	if df.albumId != uuid.Nil && s.pg.Album.Has(df.albumId) {
+		return df.albumId, nil
+	}
+	album, err := ingest.AlbumByName(s.pg, df.path, "Photos from the Drive folder "+df.path)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	df.albumId = album.Id
+	t.albums[album.Id] = true
+	return album.Id, s.pg.Drive.AddFolder(df.record())
+}
+
+// syncFolderAlbums makes sure that photo is in the album of df (if any) and in no other
+// folder album of t. A nil df removes photo from all folder albums. Only albums whose
+// membership changes are updated
+func syncFolderAlbums(s *mserver, t *driveTree, photo *dao.Photo, df *driveFolder) {
+	target := uuid.Nil
+	if df != nil {
+		var err error
+		if target, err = folderAlbum(s, t, df); err != nil {
+			s.l.Errorw("could not create folder album", "folder", df.path, zap.Error(err))
+		}
+	}
+	albums, err := s.pg.Photo.Albums(photo.Id)
+	if err != nil {
+		s.l.Errorw("could not list photo albums", "id", photo.Id, zap.Error(err))
+		return
+	}
+	ids := []uuid.UUID{photo.Id}
+	inTarget := false
+	for _, a := range albums {
+		if a.Id == target {
+			inTarget = true
+		} else if t.albums[a.Id] {
+			if _, err = s.pg.Album.DeletePhotos(a.Id, ids); err != nil {
+				s.l.Errorw("could not remove photo from folder album", "album", a.Name, zap.Error(err))
+			}
+		}
+	}
+	if target != uuid.Nil && !inTarget {
+		if _, err = s.pg.Album.AddPhotos(target, ids); err != nil {
+			s.l.Errorw("could not add photo to folder album", "folder", df.path, zap.Error(err))
+		}
+	}
+}
+
+// resetDrivePageToken forces the next sync to list all source folders
+func resetDrivePageToken(s *mserver) error {
+	u, err := s.pg.User.Get()
+	if err != nil {
+		return err
+	}
+	u.DrivePageToken = ""
+	_, err = s.pg.User.Update(u)
+	return err
+}
+
+func addDrivePhotos(s *mserver) (*DriveFiles, error) {
+	if err := s.lockDriveSync(); err != nil {
+		return nil, err
+	}
+	defer s.driveSync.Unlock()
+	c, err := pendingDriveChanges(s)
+	if err != nil {
+		return nil, err
+	}
+	added, err := applyDriveChanges(context.Background(), s, c, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toDriveFiles(added), nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"google.golang.org/api/drive/v3"
+	"testing"
+)
+
+func TestDriveTree(t *testing.T) {
+	src := &dao.DriveSource{Id: uuid.New(), FolderId: "root", FolderName: "Photos", Recursive: true}
+	tree := newDriveTree()
+	tree.add(&driveFolder{source: src, id: "root", name: "Photos"})
+	tree.add(&driveFolder{source: src, id: "2024", parent: "root", name: "2024", path: "2024"})
+	trip := &driveFolder{source: src, id: "trip", parent: "2024", name: "Trip", path: "2024/Trip", albumId: uuid.New()}
+	tree.add(trip)
+	tree.add(&driveFolder{source: src, id: "day1", parent: "trip", name: "Day1", path: "2024/Trip/Day1"})
+
+	if !tree.albums[trip.albumId] {
+		t.Errorf("expected the album of trip to be a folder album")
+	}
+	if p := tree.folderPath(&drive.File{Parents: []string{"other", "day1"}}); p != "Photos/2024/Trip/Day1" {
+		t.Errorf("expected Photos/2024/Trip/Day1 got %s", p)
+	}
+
+	trip.name, trip.path = "Vacation", "2024/Vacation"
+	if updated := tree.repath(trip); len(updated) != 1 || updated[0].path != "2024/Vacation/Day1" {
+		t.Errorf("expected day1 to be moved got %v", updated)
+	}
+
+	if removed := tree.remove("2024"); len(removed) != 3 {
+		t.Errorf("expected 3 removed folders got %d", len(removed))
+	}
+	if len(tree.folders) != 1 || tree.folder(&drive.File{Parents: []string{"day1"}}) != nil {
+		t.Errorf("expected only the source folder to be left got %v", tree.folders)
+	}
+	if !tree.albums[trip.albumId] {
+		t.Errorf("expected the album of a removed folder to stay a folder album")
+	}
+}
+
+func TestDriveSyncLock(t *testing.T) {
+	s := &mserver{jobs: &jobQueue{active: map[string]*Job{}}}
+	if s.driveSyncRunning() {
+		t.Errorf("expected no running drive sync")
+	}
+	if err := s.lockDriveSync(); err != nil {
+		t.Fatalf("could not lock drive sync: %v", err)
+	}
+	if err := s.lockDriveSync(); err != errDriveSyncRunning {
+		t.Errorf("expected errDriveSyncRunning got %v", err)
+	}
+	if !s.driveSyncRunning() {
+		t.Errorf("expected a running drive sync")
+	}
+	s.driveSync.Unlock()
+
+	job := &Job{status: JobStatus{Id: "job1", Kind: JobDriveImport, State: StateScheduled}}
+	s.jobs.active[job.status.Id] = job
+	if !s.driveSyncRunning() {
+		t.Errorf("expected a scheduled drive import to count as running")
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Library changes and job progress are published to s.events and streamed to clients as
+// server-sent events. Anonymous subscribers only get events for public data, i.e. not job
+// progress, guest events, comments awaiting moderation or changes to albums that are protected by a code
+
+const eventKeepAlive = 30 * time.Second
+
+// CommentEvent is the data of comment events
+type CommentEvent struct {
+	Id       int       `json:"id"`
+	Name     string    `json:"name"`
+	PhotoId  uuid.UUID `json:"photoId"`
+	Time     time.Time `json:"time"`
+	Body     string    `json:"body"`
+	State    string    `json:"state"`
+	ParentId int       `json:"parentId"`
+	Owner    bool      `json:"owner"`
+}
+
+// ReactionEvent is the data of reaction events
+type ReactionEvent struct {
+	PhotoId uuid.UUID `json:"photoId"`
+	Name    string    `json:"name"`
+	Kind    string    `json:"kind"`
+}
+
+// GuestEvent is the data of guest events
+type GuestEvent struct {
+	Name string `json:"name"`
+}
+
+func (s *mserver) publishPhoto(typ string, photo *dao.Photo) {
+	s.events.Publish(typ, false, photo)
+}
+
+func (s *mserver) publishAlbum(typ string, album *dao.Album) {
+	s.events.Publish(typ, album.Code != "", album)
+}
+
+// albumChanged publishes an album.updated event for the album with id
+func (s *mserver) albumChanged(id uuid.UUID) {
+	if album, err := s.pg.Album.Get(id); err == nil {
+		s.publishAlbum(events.AlbumUpdated, album)
+	}
+}
+
+func (s *mserver) publishReaction(typ string, r *dao.Reaction) {
+	ev := ReactionEvent{PhotoId: r.PhotoId, Kind: r.Kind}
+	if g, err := s.pg.Guest.Get(r.GuestId); err == nil {
+		ev.Name = g.Name
+	}
+	s.events.Publish(typ, false, &ev)
+}
+
+// publishComment publishes a comment event. Events for comments that are not approved are private
+func (s *mserver) publishComment(typ string, c *dao.Comment) {
+	ev := CommentEvent{Id: c.Id, Name: s.commentAuthor(c), PhotoId: c.PhotoId, Time: c.Time, Body: c.Body,
+		State: c.State, ParentId: c.ParentId, Owner: c.Owner}
+	s.events.Publish(typ, c.State != dao.CommentApproved, &ev)
+}
+
+// handleEvents streams events as server-sent events. The topics query parameter is a comma
+// separated list of topics to subscribe to, all topics if empty
+func (s *mserver) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		psResponse(nil, InternalError("Streaming not supported"), w)
+		return
+	}
+	var topics []string
+	if t := r.URL.Query().Get("topics"); t != "" {
+		topics = strings.Split(t, ",")
+	}
+	for _, t := range topics {
+		if !events.IsTopic(t) {
+			psResponse(nil, BadRequestError("Unknown topic: "+t), w)
+			return
+		}
+	}
+	sub := s.events.Subscribe(topics, ctxLoggedIn(r.Context()))
+	defer sub.Close()
+
+	w.Header().Set(contentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.l.Errorw("could not encode event", "type", e.Type, "error", err)
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
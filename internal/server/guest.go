@@ -6,10 +6,9 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/msvens/mphotos/internal/config"
 	"github.com/msvens/mphotos/internal/dao"
-	"html/template"
+	"github.com/msvens/mphotos/internal/events"
 	"net/http"
 	"strings"
-	"time"
 )
 
 type SessionGuest struct {
@@ -27,9 +26,19 @@ type WelcomeEmail struct {
 	VerifyUrl string
 	Code      string
 	Name      string
+	Email     string
 }
 
-var templates = template.Must(template.ParseFiles("tmpl/welcome-email.html"))
+// GuestExport is everything stored about a guest
+type GuestExport struct {
+	Id          uuid.UUID             `json:"id"`
+	Guest       *dao.Guest            `json:"guest"`
+	Comments    []*dao.Comment        `json:"comments"`
+	Reactions   []*dao.Reaction       `json:"reactions"`
+	Tokens      []*dao.GuestToken     `json:"tokens"`
+	Collections []*CollectionPhotos   `json:"collections"`
+	Proofing    []*dao.ProofSelection `json:"proofing"`
+}
 
 func sessionGuest(session *sessions.Session) (SessionGuest, bool) {
 	val := session.Values["guest"]
@@ -85,37 +94,52 @@ func (s *mserver) handleCommentPhoto(r *http.Request, uid uuid.UUID) (interface{
 		return nil, BadRequestError("Could not parse img id")
 	} else {
 		type request struct {
-			Body string
+			Body     string
+			ParentId int
+			Website  string
 		}
 		var params request
 		if err := decodeRequest(r, &params); err != nil {
 			return nil, err
 		}
-		return s.pg.Comment.Add(uid, photoId, params.Body)
+		if params.ParentId != 0 {
+			if parent, err := s.pg.Comment.Get(params.ParentId); err != nil || parent.PhotoId != photoId || parent.State != dao.CommentApproved {
+				return nil, BadRequestError("Cannot reply to comment")
+			}
+		}
+		if strings.TrimSpace(params.Body) == "" {
+			return nil, BadRequestError("Comment cannot be empty")
+		}
+		state, flag, err := s.screenComment(r, uid, 0, params.Body, params.Website)
+		if err != nil {
+			return nil, err
+		}
+		c := dao.Comment{GuestId: uid, PhotoId: photoId, Body: params.Body, State: state, ParentId: params.ParentId,
+			Flag: flag}
+		comment, err := s.pg.Comment.Add(&c)
+		if err != nil {
+			return nil, err
+		}
+		s.publishComment(events.CommentAdded, comment)
+		return comment, nil
 	}
 }
 
+// handlePhotoComments returns the comment threads of a photo, latest first
 func (s *mserver) handlePhotoComments(r *http.Request, loggedIn bool) (interface{}, error) {
 	if photoId, err := uuid.Parse(Var(r, "img")); err != nil {
 		return nil, BadRequestError("Could not parse img id")
 	} else {
-		type resp struct {
-			Id      int       `json:"id"`
-			Name    string    `json:"name"`
-			PhotoId uuid.UUID `json:"photoId"`
-			Time    time.Time `json:"time"`
-			Body    string    `json:"body"`
+		//only the owner sees comments that are not approved
+		var states []string
+		if !loggedIn {
+			states = append(states, dao.CommentApproved)
 		}
-		comments, err := s.pg.Comment.ListByPhoto(photoId)
+		comments, err := s.pg.Comment.ListByPhoto(photoId, states...)
 		if err != nil {
 			return nil, err
 		}
-		ret := []*resp{}
-		for _, c := range comments {
-			u, _ := s.pg.Guest.Get(c.GuestId)
-			ret = append(ret, &resp{Id: c.Id, Name: u.Name, PhotoId: c.PhotoId, Time: c.Time, Body: c.Body})
-		}
-		return ret, nil
+		return s.commentThreads(comments, loggedIn), nil
 	}
 }
 
@@ -124,57 +148,78 @@ func (s *mserver) handleGuest(r *http.Request, uuid uuid.UUID) (interface{}, err
 }
 
 func (s *mserver) handleLikePhoto(r *http.Request, guestId uuid.UUID) (interface{}, error) {
-	if photoId, err := uuid.Parse(Var(r, "photoid")); err != nil {
-		return nil, BadRequestError("Could not parse img id")
+	if reaction, err := s.react(r, guestId, likeKind, true); err != nil {
+		return nil, err
 	} else {
-		if s.pg.Photo.Has(photoId) {
-			return photoId, s.pg.Reaction.Add(&dao.Reaction{GuestId: guestId, PhotoId: photoId, Kind: "like"})
-		} else {
-			return nil, NotFoundError("img not found")
-		}
+		return reaction.PhotoId, nil
 	}
 }
 
 func (s *mserver) handleUnlikePhoto(r *http.Request, guestId uuid.UUID) (interface{}, error) {
-	var photoId uuid.UUID
-	if err := uid(r, "photoid", &photoId); err != nil {
+	if reaction, err := s.react(r, guestId, likeKind, false); err != nil {
 		return nil, err
-	}
-	if s.pg.Photo.Has(photoId) {
-		return photoId, s.pg.Reaction.Delete(&dao.Reaction{GuestId: guestId, PhotoId: photoId, Kind: "like"})
 	} else {
-		return nil, NotFoundError("img not found")
+		return reaction.PhotoId, nil
 	}
 }
 
+// handlePhotoLikes returns the guests that like a photo. Guest emails are only included for the owner
 func (s *mserver) handlePhotoLikes(r *http.Request, loggedIn bool) (interface{}, error) {
-	if photoId, err := uuid.Parse(Var(r, "photoid")); err != nil {
+	photoId, err := uuid.Parse(Var(r, "photoid"))
+	if err != nil {
 		return nil, BadRequestError("Could not parse img id")
-	} else {
-		return s.pg.Reaction.ListByPhoto(photoId)
 	}
+	reactions, err := s.pg.Reaction.ListByPhoto(photoId)
+	if err != nil {
+		return nil, err
+	}
+	likes := []*dao.GuestReaction{}
+	for _, g := range reactions {
+		if g.Kind != likeKind {
+			continue
+		}
+		if !loggedIn {
+			g.Email = ""
+		}
+		likes = append(likes, g)
+	}
+	return likes, nil
 }
 
+// handleVerifyGuest logs in the guest with a verify or login code sent by email. Using the code
+// verifies the guest's email
 func (s *mserver) handleVerifyGuest(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	type request struct {
 		Code string
 	}
 	var params request
-	var err error
-	var id uuid.UUID
-	if err = decodeRequest(r, &params); err != nil {
+	if err := decodeRequest(r, &params); err != nil {
 		return nil, err
 	}
-	if id, err = uuid.Parse(params.Code); err != nil {
-		return nil, BadRequestError("could not parse guest id: " + err.Error())
+	token, err := s.useGuestToken(params.Code)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := s.pg.Guest.Get(token.GuestId)
+	if err != nil {
+		return nil, NotFoundError("guest not found")
+	}
+	if token.Purpose == dao.TokenEmail {
+		if s.pg.Guest.HasByEmail(token.Email) {
+			return nil, BadRequestError("email is already in use")
+		}
+		if _, err = s.pg.Guest.Update(token.Email, prev.Name, prev.Id); err != nil {
+			return nil, err
+		}
 	}
-	if ver, err := s.pg.Guest.Verify(id); err != nil {
+	ver, err := s.pg.Guest.Verify(token.GuestId)
+	if err != nil {
 		return nil, err
-	} else if ver.Verified {
-		return ver, s.saveGuestCookie(w, r, id, Session_Year)
-	} else {
-		return ver, nil
 	}
+	if !prev.Verified {
+		s.events.Publish(events.GuestVerified, true, &GuestEvent{Name: ver.Name})
+	}
+	return ver, s.saveGuestCookie(w, r, ver.Id, Session_Year)
 }
 
 func (s *mserver) handleIsGuest(w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -188,10 +233,14 @@ func (s *mserver) handleIsGuest(w http.ResponseWriter, r *http.Request) (interfa
 }
 
 func (s *mserver) handleGuestLikes(r *http.Request, guestId uuid.UUID) (interface{}, error) {
-	if likes, err := s.pg.Reaction.ListByGuest(guestId); err != nil {
+	if likes, err := s.pg.Reaction.ListByGuest(guestId, likeKind); err != nil {
 		return nil, err
 	} else {
-		return likes, nil
+		photoIds := make([]uuid.UUID, len(likes))
+		for i, l := range likes {
+			photoIds[i] = l.PhotoId
+		}
+		return photoIds, nil
 	}
 }
 
@@ -206,26 +255,22 @@ func (s *mserver) handleGuestLikePhoto(r *http.Request, guestId uuid.UUID) (inte
 		type ret struct {
 			Like bool `json:"like"`
 		}
-		return ret{s.pg.Reaction.Has(guestId, photoId)}, nil
+		return ret{s.pg.Reaction.Has(&dao.Reaction{GuestId: guestId, PhotoId: photoId, Kind: likeKind})}, nil
 	}
 }
 
+// handleCreateGuest creates a guest and sends a verification email. If a guest with the email
+// already exists a login link is sent instead. The guest is logged in by following the link
 func (s *mserver) handleCreateGuest(w http.ResponseWriter, r *http.Request) (interface{}, error) {
 
-	sendEmail := func(g *dao.Guest) error {
-		var b strings.Builder
-		we := WelcomeEmail{Name: g.Name, Code: g.Id.String(), VerifyUrl: config.VerifyUrl()}
-		if err := templates.ExecuteTemplate(&b, "welcome-email.html", we); err != nil {
-			return err
-		}
-		_, err := s.ms.SendHtmlMessage(g.Email, "Mellowtech Guest Verification", b.String())
-		return err
-	}
-
 	type request struct {
 		Email string
 		Name  string
 	}
+	type response struct {
+		Sent  bool   `json:"sent"`
+		Email string `json:"email"`
+	}
 
 	var params request
 
@@ -233,17 +278,16 @@ func (s *mserver) handleCreateGuest(w http.ResponseWriter, r *http.Request) (int
 		return nil, err
 	}
 	if s.pg.Guest.HasByEmail(params.Email) {
-		s.l.Debugw("guest already exists send a new verify email", "email", params.Email)
 		guest, _ := s.pg.Guest.GetByEmail(params.Email)
 
 		if guest.Name != params.Name {
 			return nil, UnauthorizedError("name does not match provided email")
 		}
-		//here we should send a notification email...not a verify email
-		if err := sendEmail(guest); err != nil {
+		s.l.Debugw("guest already exists send a login email", "email", params.Email)
+		if err := s.sendGuestToken(guest, dao.TokenLogin); err != nil {
 			return nil, err
 		}
-		return guest, s.saveGuestCookie(w, r, guest.Id, Session_Year)
+		return response{true, guest.Email}, nil
 	}
 	//user email not found try to create new user
 	if s.pg.Guest.HasByName(params.Name) {
@@ -253,15 +297,39 @@ func (s *mserver) handleCreateGuest(w http.ResponseWriter, r *http.Request) (int
 	if guest, err := s.pg.Guest.Add(params.Name, params.Email); err != nil {
 		return nil, err
 	} else {
-		if err := sendEmail(guest); err != nil {
+		if err := s.sendGuestToken(guest, dao.TokenVerify); err != nil {
 			_ = s.pg.Guest.Delete(guest.Id)
 			return nil, err
 		}
-		return guest, s.saveGuestCookie(w, r, guest.Id, Session_Year)
+		return response{true, guest.Email}, nil
 	}
 
 }
 
+// sendGuestToken emails a new verify or login link to guest
+func (s *mserver) sendGuestToken(guest *dao.Guest, purpose string) error {
+	token, err := s.newGuestToken(guest.Id, purpose, "")
+	if err != nil {
+		return err
+	}
+	we := WelcomeEmail{Name: guest.Name, Code: token, VerifyUrl: config.VerifyUrl()}
+	if purpose == dao.TokenLogin {
+		return s.sendMail(guest.Email, "Mellowtech Photos Login", "login-email", we)
+	}
+	return s.sendMail(guest.Email, "Mellowtech Guest Verification", "welcome-email", we)
+}
+
+// sendEmailChange emails a confirmation link to the new email of guest. The email is changed
+// when the link is followed
+func (s *mserver) sendEmailChange(guest *dao.Guest, email string) error {
+	token, err := s.newGuestToken(guest.Id, dao.TokenEmail, email)
+	if err != nil {
+		return err
+	}
+	we := WelcomeEmail{Name: guest.Name, Code: token, VerifyUrl: config.VerifyUrl(), Email: email}
+	return s.sendMail(email, "Mellowtech Photos Email Change", "change-email", we)
+}
+
 func (s *mserver) handleUpdateGuest(r *http.Request, guestId uuid.UUID) (interface{}, error) {
 
 	type request struct {
@@ -278,10 +346,66 @@ func (s *mserver) handleUpdateGuest(r *http.Request, guestId uuid.UUID) (interfa
 	if strings.TrimSpace(params.Name) == "" {
 		return nil, BadRequestError("name cannot contain only white space characters")
 	}
-	guest, _ := s.pg.Guest.Get(guestId)
-
+	guest, err := s.pg.Guest.Get(guestId)
+	if err != nil {
+		return nil, err
+	}
+	if params.Name != guest.Name && s.pg.Guest.HasByName(params.Name) {
+		return nil, BadRequestError("name already exists")
+	}
+	//a new email is only used once it has been confirmed
 	if params.Email != "" && params.Email != guest.Email {
-		return nil, BadRequestError("change of email is not yet supported")
+		if s.pg.Guest.HasByEmail(params.Email) {
+			return nil, BadRequestError("email is already in use")
+		}
+		if err := s.sendEmailChange(guest, params.Email); err != nil {
+			return nil, err
+		}
+	}
+	return s.pg.Guest.Update(guest.Email, params.Name, guest.Id)
+}
+
+// handleDeleteGuest deletes the current guest with all comments, reactions and tokens and logs
+// the guest out
+func (s *mserver) handleDeleteGuest(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	guestId := ctxGuest(r.Context())
+	if guestId == emptyuuid {
+		return nil, UnauthorizedError("guest not found")
+	}
+	if err := s.pg.Guest.Delete(guestId); err != nil {
+		return nil, err
+	}
+	return AuthUser{false}, s.saveGuestCookie(w, r, emptyuuid, -1)
+}
+
+// handleExportGuest returns everything stored about the current guest
+func (s *mserver) handleExportGuest(_ *http.Request, guestId uuid.UUID) (interface{}, error) {
+	var err error
+	ret := GuestExport{Id: guestId}
+	if ret.Guest, err = s.pg.Guest.Get(guestId); err != nil {
+		return nil, err
+	}
+	if ret.Comments, err = s.pg.Comment.ListByGuest(guestId); err != nil {
+		return nil, err
+	}
+	if ret.Reactions, err = s.pg.Reaction.ListByGuest(guestId); err != nil {
+		return nil, err
+	}
+	if ret.Tokens, err = s.pg.GuestToken.ListByGuest(guestId); err != nil {
+		return nil, err
+	}
+	collections, err := s.pg.Collection.ListByGuest(guestId)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Proofing, err = s.pg.Proof.ListByGuest(guestId); err != nil {
+		return nil, err
+	}
+	ret.Collections = make([]*CollectionPhotos, len(collections))
+	for i, c := range collections {
+		if ret.Collections[i], err = s.collectionPhotos(c); err != nil {
+			return nil, err
+		}
 	}
-	return s.pg.Guest.Update(params.Email, params.Name, guest.Id)
+	return &ret, nil
 }
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"net/http"
+)
+
+// OwnerGuest is a guest as seen by the owner. Unlike the guest's own view it includes the id
+type OwnerGuest struct {
+	Id uuid.UUID `json:"id"`
+	*dao.GuestActivity
+}
+
+// handleGuests lists guests with their activity. The optional query parameter q searches
+// guest names and emails
+func (s *mserver) handleGuests(r *http.Request) (interface{}, error) {
+	guests, err := s.pg.Guest.List(r.URL.Query().Get("q"))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*OwnerGuest, len(guests))
+	for i, g := range guests {
+		ret[i] = &OwnerGuest{Id: g.Id, GuestActivity: g}
+	}
+	return ret, nil
+}
+
+func (s *mserver) ownerGuest(r *http.Request) (*dao.Guest, error) {
+	var id uuid.UUID
+	if err := uid(r, "guestid", &id); err != nil {
+		return nil, err
+	}
+	if guest, err := s.pg.Guest.Get(id); err != nil {
+		return nil, NotFoundError("Could not find guest")
+	} else {
+		return guest, nil
+	}
+}
+
+func (s *mserver) handleOwnerGuest(r *http.Request) (interface{}, error) {
+	guest, err := s.ownerGuest(r)
+	if err != nil {
+		return nil, err
+	}
+	ret := &OwnerGuest{Id: guest.Id, GuestActivity: &dao.GuestActivity{Guest: *guest}}
+	if ret.Comments, err = s.pg.Comment.CountByGuest(guest.Id); err != nil {
+		return nil, err
+	}
+	if reactions, err := s.pg.Reaction.ListByGuest(guest.Id); err != nil {
+		return nil, err
+	} else {
+		ret.Reactions = len(reactions)
+	}
+	return ret, nil
+}
+
+// handleOwnerDeleteGuest deletes a guest with all comments and reactions
+func (s *mserver) handleOwnerDeleteGuest(r *http.Request) (interface{}, error) {
+	if guest, err := s.ownerGuest(r); err != nil {
+		return nil, err
+	} else {
+		return guest, s.pg.Guest.Delete(guest.Id)
+	}
+}
+
+func (s *mserver) handleBanGuest(r *http.Request) (interface{}, error) {
+	return s.banGuest(r, true)
+}
+
+func (s *mserver) handleUnbanGuest(r *http.Request) (interface{}, error) {
+	return s.banGuest(r, false)
+}
+
+// banGuest bans or unbans a guest. A banned guest is refused by all guest only routes
+func (s *mserver) banGuest(r *http.Request, banned bool) (*dao.Guest, error) {
+	if guest, err := s.ownerGuest(r); err != nil {
+		return nil, err
+	} else {
+		return s.pg.Guest.SetBanned(guest.Id, banned)
+	}
+}
+
+// handleResendVerification sends a new verification email to an unverified guest
+func (s *mserver) handleResendVerification(r *http.Request) (interface{}, error) {
+	guest, err := s.ownerGuest(r)
+	if err != nil {
+		return nil, err
+	}
+	if guest.Verified {
+		return nil, BadRequestError("Guest is already verified")
+	}
+	return guest, s.sendGuestToken(guest, dao.TokenVerify)
+}
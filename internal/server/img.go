@@ -8,6 +8,7 @@ import (
 	"github.com/msvens/mimage/img"
 	"github.com/msvens/mphotos/internal/config"
 	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
 	"image"
 	"io"
 	"net/http"
@@ -63,6 +64,7 @@ func (s *mserver) handleEditImage(r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, InternalError("Could not generate image versions")
 	}
+	s.publishPhoto(events.PhotoUpdated, p)
 	return p, nil
 }
 
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/ingest"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	inboxDoneDir       = "done"
+	inboxDuplicatesDir = "duplicates"
+	inboxFailedDir     = "failed"
+	inboxErrSuffix     = ".error"
+	//time a file has to be left alone before it is imported
+	inboxSettleTime = 2 * time.Second
+)
+
+type InboxFile struct {
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"modTime"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type InboxFiles struct {
+	Pending    []*InboxFile `json:"pending"`
+	Imported   []*InboxFile `json:"imported"`
+	Duplicates []*InboxFile `json:"duplicates"`
+	Failed     []*InboxFile `json:"failed"`
+}
+
+// inbox watches a local folder and imports any jpegs that are dropped in it. Imported
+// files are moved to done/, files that were already imported to duplicates/ and files that
+// could not be imported to failed/
+type inbox struct {
+	s       *mserver
+	dir     string
+	album   string
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	timers  map[string]*time.Timer
+	wg      sync.WaitGroup
+	//serializes imports so that md5 dedupe works for files dropped at the same time
+	importMu sync.Mutex
+}
+
+func newInbox(s *mserver, dir, album string) (*inbox, error) {
+	for _, d := range []string{dir, filepath.Join(dir, inboxDoneDir), filepath.Join(dir, inboxDuplicatesDir), filepath.Join(dir, inboxFailedDir)} {
+		if err := os.MkdirAll(d, 0744); err != nil {
+			return nil, err
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = w.Add(dir); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	in := &inbox{s: s, dir: dir, album: album, watcher: w, timers: make(map[string]*time.Timer)}
+	in.wg.Add(1)
+	go in.watch()
+	//pick up anything that was dropped while the server was down
+	if pending, err := in.list(in.dir); err == nil {
+		for _, f := range pending {
+			in.schedule(filepath.Join(in.dir, f.Name))
+		}
+	}
+	return in, nil
+}
+
+func isJpegName(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+func (in *inbox) watch() {
+	defer in.wg.Done()
+	for {
+		select {
+		case e, ok := <-in.watcher.Events:
+			if !ok {
+				return
+			}
+			if (e.Has(fsnotify.Create) || e.Has(fsnotify.Write)) && isJpegName(filepath.Base(e.Name)) {
+				in.schedule(e.Name)
+			}
+		case err, ok := <-in.watcher.Errors:
+			if !ok {
+				return
+			}
+			in.s.l.Errorw("inbox watcher error", zap.Error(err))
+		}
+	}
+}
+
+// schedule (re)starts the settle timer for path. Files are only imported once they
+// have not been written to for inboxSettleTime
+func (in *inbox) schedule(path string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if t, found := in.timers[path]; found {
+		t.Reset(inboxSettleTime)
+		return
+	}
+	in.timers[path] = time.AfterFunc(inboxSettleTime, func() {
+		in.mu.Lock()
+		delete(in.timers, path)
+		in.mu.Unlock()
+		in.process(path)
+	})
+}
+
+func (in *inbox) process(path string) {
+	in.importMu.Lock()
+	defer in.importMu.Unlock()
+	name := filepath.Base(path)
+	photo, err := in.importFile(path)
+	switch {
+	case err == nil:
+		in.s.l.Infow("imported inbox file", "file", name, "id", photo.Id)
+	case errors.Is(err, ingest.ErrExists):
+		in.s.l.Infow("inbox file already imported", "file", name)
+		msg := "photo already exists"
+		if photo != nil {
+			msg = fmt.Sprintf("photo already exists as %s", photo.Id)
+		}
+		in.move(path, inboxDuplicatesDir, msg)
+		return
+	case os.IsNotExist(err):
+		return
+	default:
+		in.s.l.Errorw("could not import inbox file", "file", name, zap.Error(err))
+		in.move(path, inboxFailedDir, err.Error())
+		return
+	}
+	in.move(path, inboxDoneDir, "")
+}
+
+// move moves path to the sub folder dir of the inbox. A non empty msg is stored next to the
+// moved file and listed as its error
+func (in *inbox) move(path, dir, msg string) {
+	dst, err := moveFile(path, filepath.Join(in.dir, dir))
+	if err != nil {
+		in.s.l.Errorw("could not move inbox file", "file", filepath.Base(path), zap.Error(err))
+	} else if msg != "" {
+		_ = os.WriteFile(dst+inboxErrSuffix, []byte(msg), 0644)
+	}
+}
+
+func (in *inbox) importFile(path string) (*dao.Photo, error) {
+	item, err := ingest.NewFileItem(path)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []ingest.Hook
+	if in.album != "" {
+		if album, err := ingest.AlbumByName(in.s.pg, in.album, "Photos imported from the inbox folder"); err != nil {
+			in.s.l.Errorw("could not get inbox album", "album", in.album, zap.Error(err))
+		} else {
+			hooks = append(hooks, ingest.AlbumHook(in.s.pg, album))
+		}
+	}
+	return in.s.ingest.Add(item, hooks...)
+}
+
+// moveFile moves src into dir. If a file with the same name already exists in dir a
+// timestamp is added to the name
+func moveFile(src, dir string) (string, error) {
+	name := filepath.Base(src)
+	dst := filepath.Join(dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		ext := filepath.Ext(name)
+		dst = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), time.Now().UnixNano(), ext))
+	}
+	return dst, os.Rename(src, dst)
+}
+
+func (in *inbox) list(dir string) ([]*InboxFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ret := []*InboxFile{}
+	for _, e := range entries {
+		if e.IsDir() || !isJpegName(e.Name()) {
+			continue
+		}
+		f := InboxFile{Name: e.Name()}
+		if fi, err := e.Info(); err == nil {
+			f.ModTime = fi.ModTime()
+		}
+		if b, err := os.ReadFile(filepath.Join(dir, e.Name()+inboxErrSuffix)); err == nil {
+			f.Error = string(b)
+		}
+		ret = append(ret, &f)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ModTime.After(ret[j].ModTime) })
+	return ret, nil
+}
+
+func (in *inbox) check() (*InboxFiles, error) {
+	var ret InboxFiles
+	var err error
+	if ret.Pending, err = in.list(in.dir); err != nil {
+		return nil, err
+	}
+	if ret.Imported, err = in.list(filepath.Join(in.dir, inboxDoneDir)); err != nil {
+		return nil, err
+	}
+	if ret.Duplicates, err = in.list(filepath.Join(in.dir, inboxDuplicatesDir)); err != nil {
+		return nil, err
+	}
+	if ret.Failed, err = in.list(filepath.Join(in.dir, inboxFailedDir)); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (in *inbox) Close() error {
+	err := in.watcher.Close()
+	in.wg.Wait()
+	in.mu.Lock()
+	for p, t := range in.timers {
+		t.Stop()
+		delete(in.timers, p)
+	}
+	in.mu.Unlock()
+	return err
+}
@@ -0,0 +1,578 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"github.com/msvens/mphotos/internal/ingest"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Jobs are stored in the job table and run by a pool of workers. A job is described by its
+// kind and (json encoded) params so that it can be retried, and so that jobs that were still
+// scheduled or running when the server stopped are picked up again on startup
+
+const StateScheduled = "SCHEDULED"
+const StateStarted = "STARTED"
+const StateFinished = "FINISHED"
+const StateAborted = "ABORTED"
+const StateCancelled = "CANCELLED"
+
+const (
+	JobDriveImport    = "DRIVE_IMPORT"
+	JobDriveReconcile = "DRIVE_RECONCILE"
+	JobArchiveImport  = "ARCHIVE_IMPORT"
+	JobRegenerate     = "REGENERATE"
+	JobReindex        = "REINDEX"
+)
+
+const (
+	numJobWorkers = 2
+	maxQueuedJobs = 100
+	jobRetention  = 30 * 24 * time.Hour
+)
+
+// jobTasks maps each job kind to the function that runs it
+var jobTasks = map[string]func(job *Job) error{
+	JobDriveImport:    driveImportTask,
+	JobDriveReconcile: driveReconcileTask,
+	JobArchiveImport:  archiveImportTask,
+	JobRegenerate:     regenerateTask,
+	JobReindex:        reindexTask,
+}
+
+// JobStatus is the state of a job as reported by the api
+type JobStatus struct {
+	Id           string           `json:"id"`
+	Kind         string           `json:"kind"`
+	State        string           `json:"state"`
+	Percent      int              `json:"percent"`
+	NumFiles     int              `json:"numFiles"`
+	NumProcessed int              `json:"numProcessed"`
+	Results      []*ingest.Result `json:"results,omitempty"`
+	Err          *ApiError        `json:"error,omitempty"`
+	Created      time.Time        `json:"created"`
+	Started      time.Time        `json:"started"`
+	Finished     time.Time        `json:"finished"`
+}
+
+// Job is a scheduled or running job. Tasks report progress through its methods, which
+// makes it safe to read the status while the job is running
+type Job struct {
+	s           *mserver
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{} //closed once the job has been run or cancelled
+	params      string
+	mu          sync.Mutex
+	status      JobStatus
+	interrupted bool //cancelled by the queue being closed rather than by the user
+}
+
+// PhotoJobParams selects the photos for regenerate and reindex jobs. No ids means all photos
+type PhotoJobParams struct {
+	PhotoIds []uuid.UUID `json:"photoIds"`
+}
+
+func newJob(s *mserver, kind string, params interface{}) (*Job, error) {
+	if _, found := jobTasks[kind]; !found {
+		return nil, BadRequestError("Unknown job kind: " + kind)
+	}
+	p, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{s: s, params: string(p), done: make(chan struct{}),
+		status: JobStatus{Id: uuid.New().String(), Kind: kind, State: StateScheduled, Created: time.Now()}}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	return job, nil
+}
+
+func jobFromRecord(s *mserver, rec *dao.Job) *Job {
+	job := &Job{s: s, params: rec.Params, done: make(chan struct{}), status: jobStatusFromRecord(rec)}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	return job
+}
+
+func jobStatusFromRecord(rec *dao.Job) JobStatus {
+	st := JobStatus{Id: rec.Id.String(), Kind: rec.Kind, State: rec.State, Percent: rec.Percent,
+		NumFiles: rec.NumFiles, NumProcessed: rec.NumProcessed, Created: rec.Created,
+		Started: rec.Started, Finished: rec.Finished}
+	if rec.Results != "" {
+		_ = json.Unmarshal([]byte(rec.Results), &st.Results)
+	}
+	if rec.ErrorMessage != "" {
+		st.Err = newError(rec.ErrorCode, rec.ErrorMessage)
+	}
+	return st
+}
+
+// Status returns a copy of the current job status
+func (job *Job) Status() *JobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	st := job.status
+	st.Results = append([]*ingest.Result(nil), job.status.Results...)
+	return &st
+}
+
+func (job *Job) record() *dao.Job {
+	st := job.Status()
+	rec := dao.Job{Id: uuid.MustParse(st.Id), Kind: st.Kind, State: st.State, Params: job.params,
+		NumFiles: st.NumFiles, NumProcessed: st.NumProcessed, Percent: st.Percent, Created: st.Created,
+		Started: st.Started, Finished: st.Finished}
+	if len(st.Results) > 0 {
+		b, _ := json.Marshal(st.Results)
+		rec.Results = string(b)
+	}
+	if st.Err != nil {
+		rec.ErrorCode, rec.ErrorMessage = st.Err.Code, st.Err.Message
+	}
+	return &rec
+}
+
+func (job *Job) decodeParams(v interface{}) error {
+	return json.Unmarshal([]byte(job.params), v)
+}
+
+// cancelled returns the context error once the job has been cancelled
+func (job *Job) cancelled() error {
+	return job.ctx.Err()
+}
+
+// interrupt cancels the job so that it can be run again when the server starts
+func (job *Job) interrupt() {
+	job.mu.Lock()
+	job.interrupted = true
+	job.mu.Unlock()
+	job.cancel()
+}
+
+// isInterrupted reports whether the job was cancelled by interrupt
+func (job *Job) isInterrupted() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.interrupted
+}
+
+// setNumFiles sets the number of files the job will process
+func (job *Job) setNumFiles(n int) {
+	job.mu.Lock()
+	job.status.NumFiles = n
+	job.mu.Unlock()
+}
+
+// addResult records the outcome of processing a single file
+func (job *Job) addResult(res *ingest.Result) {
+	job.mu.Lock()
+	job.status.Results = append(job.status.Results, res)
+	job.mu.Unlock()
+}
+
+// progress marks one more file as processed. An event is published when the percentage changes
+func (job *Job) progress() {
+	job.mu.Lock()
+	job.status.NumProcessed = job.status.NumProcessed + 1
+	percent := job.status.Percent
+	if job.status.NumFiles > 0 {
+		p := float64(job.status.NumProcessed) / float64(job.status.NumFiles)
+		job.status.Percent = int(math.Round(p * 100))
+	}
+	changed := percent != job.status.Percent
+	job.s.l.Debugw("", "jobid", job.status.Id, "progress", job.status.Percent)
+	job.mu.Unlock()
+	if changed {
+		job.publish()
+	}
+}
+
+// publish sends the job status, without results, as a job.updated event
+func (job *Job) publish() {
+	st := job.Status()
+	st.Results = nil
+	job.s.events.Publish(events.JobUpdated, true, st)
+}
+
+func (job *Job) setState(state string, err error) {
+	defer job.publish()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.State = state
+	switch state {
+	case StateScheduled:
+		//the job is run from the start again
+		job.status.Started, job.status.Finished = time.Time{}, time.Time{}
+		job.status.NumProcessed, job.status.Percent, job.status.Results = 0, 0, nil
+	case StateStarted:
+		job.status.Started = time.Now()
+	case StateFinished:
+		job.status.Percent = 100
+		fallthrough
+	default:
+		job.status.Finished = time.Now()
+	}
+	if err != nil {
+		job.status.Err = ResolveError(err)
+	}
+}
+
+// jobQueue runs jobs with a fixed number of workers. Jobs that are scheduled or running are
+// kept in memory, all other jobs are read from the database
+type jobQueue struct {
+	s      *mserver
+	queue  chan *Job
+	done   chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	active map[string]*Job
+}
+
+func newJobQueue(s *mserver) *jobQueue {
+	return &jobQueue{s: s, queue: make(chan *Job, maxQueuedJobs), done: make(chan struct{}),
+		active: make(map[string]*Job)}
+}
+
+// Start starts workers workers. Jobs that were scheduled or interrupted when the server
+// stopped are scheduled again, jobs that were running when the server crashed are aborted
+// and can be retried. Start should be called once the services that jobs use, e.g. Drive,
+// have been set up
+func (q *jobQueue) Start(workers int) {
+	q.prune()
+	if recs, err := q.s.pg.Job.ListState(StateScheduled, StateStarted); err != nil {
+		q.s.l.Errorw("could not list unfinished jobs", zap.Error(err))
+	} else {
+		for _, rec := range recs {
+			if _, found := q.activeJob(rec.Id.String()); found {
+				continue //scheduled before the queue was started
+			}
+			job := jobFromRecord(q.s, rec)
+			if rec.State == StateStarted {
+				job.setState(StateAborted, InternalError("job was interrupted by a server restart"))
+				q.store(job)
+			} else if err := q.push(job); err != nil {
+				q.s.l.Errorw("could not reschedule job", "jobid", rec.Id, zap.Error(err))
+			}
+		}
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// Close stops the workers. Running jobs are cancelled and, like the jobs that have not
+// started, left scheduled so that they are run again when the server starts
+func (q *jobQueue) Close() {
+	close(q.done)
+	q.mu.Lock()
+	for _, job := range q.active {
+		if job.cancelled() == nil {
+			job.interrupt()
+		}
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// closed reports whether Close has been called
+func (q *jobQueue) closed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *jobQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.done:
+			return
+		case job := <-q.queue:
+			q.run(job)
+		}
+	}
+}
+
+func (q *jobQueue) run(job *Job) {
+	defer q.remove(job)
+	if job.cancelled() != nil || q.closed() {
+		return
+	}
+	st := job.Status()
+	q.s.l.Infow("Processing job", "jobid", st.Id, "kind", st.Kind)
+	job.setState(StateStarted, nil)
+	q.store(job)
+	err := jobTasks[st.Kind](job)
+	switch {
+	case job.isInterrupted():
+		q.s.l.Infow("job interrupted, it will be run again on startup", "jobid", st.Id, "kind", st.Kind)
+		job.setState(StateScheduled, nil)
+	case job.cancelled() != nil:
+		job.setState(StateCancelled, nil)
+	case err != nil:
+		q.s.l.Errorw("job failed", "jobid", st.Id, "kind", st.Kind, zap.Error(err))
+		job.setState(StateAborted, err)
+	default:
+		job.setState(StateFinished, nil)
+	}
+	q.store(job)
+	q.prune()
+}
+
+func (q *jobQueue) push(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- job:
+		q.active[job.status.Id] = job
+		return nil
+	default:
+		return InternalError("Too many queued jobs")
+	}
+}
+
+func (q *jobQueue) remove(job *Job) {
+	q.mu.Lock()
+	delete(q.active, job.status.Id)
+	q.mu.Unlock()
+	job.cancel()
+	close(job.done)
+}
+
+// wait blocks until job has been run or the queue has been closed
+func (q *jobQueue) wait(job *Job) {
+	select {
+	case <-job.done:
+	case <-q.done:
+	}
+}
+
+// hasActive reports whether a job of kind is scheduled or running
+func (q *jobQueue) hasActive(kind string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.active {
+		if job.status.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *jobQueue) store(job *Job) {
+	if err := q.s.pg.Job.Update(job.record()); err != nil {
+		q.s.l.Errorw("could not store job", "jobid", job.status.Id, zap.Error(err))
+	}
+}
+
+// prune deletes finished jobs that are older than jobRetention
+func (q *jobQueue) prune() {
+	states := []string{StateFinished, StateAborted, StateCancelled}
+	if n, err := q.s.pg.Job.Prune(states, time.Now().Add(-jobRetention)); err != nil {
+		q.s.l.Errorw("could not prune jobs", zap.Error(err))
+	} else if n > 0 {
+		q.s.l.Infow("pruned old jobs", "count", n)
+	}
+}
+
+// schedule stores and queues job
+func (q *jobQueue) schedule(job *Job) (*JobStatus, error) {
+	if err := q.s.pg.Job.Add(job.record()); err != nil {
+		return nil, err
+	}
+	if err := q.push(job); err != nil {
+		job.setState(StateAborted, err)
+		q.store(job)
+		return nil, err
+	}
+	return job.Status(), nil
+}
+
+func (q *jobQueue) activeJob(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, found := q.active[id]
+	return job, found
+}
+
+func (q *jobQueue) get(id string) (*JobStatus, error) {
+	if job, found := q.activeJob(id); found {
+		return job.Status(), nil
+	}
+	jid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, NotFoundError("job not found")
+	}
+	rec, err := q.s.pg.Job.Get(jid)
+	if err != nil {
+		return nil, NotFoundError("job not found")
+	}
+	st := jobStatusFromRecord(rec)
+	return &st, nil
+}
+
+// list returns the latest limit jobs with the progress of active jobs
+func (q *jobQueue) list(limit int) ([]*JobStatus, error) {
+	recs, err := q.s.pg.Job.List(limit)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*JobStatus, len(recs))
+	for i, rec := range recs {
+		if job, found := q.activeJob(rec.Id.String()); found {
+			ret[i] = job.Status()
+		} else {
+			st := jobStatusFromRecord(rec)
+			ret[i] = &st
+		}
+	}
+	return ret, nil
+}
+
+// cancel stops a scheduled or running job. A running job stops at the next file
+func (q *jobQueue) cancel(id string) (*JobStatus, error) {
+	job, found := q.activeJob(id)
+	if !found {
+		return nil, BadRequestError("Job is not scheduled or running")
+	}
+	job.cancel()
+	if job.Status().State == StateScheduled {
+		job.setState(StateCancelled, nil)
+		q.store(job)
+	}
+	return job.Status(), nil
+}
+
+// retry schedules a new job with the same kind and params as an aborted or cancelled job
+func (q *jobQueue) retry(id string) (*JobStatus, error) {
+	st, err := q.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if st.State != StateAborted && st.State != StateCancelled {
+		return nil, BadRequestError("Only aborted or cancelled jobs can be retried")
+	}
+	rec, err := q.s.pg.Job.Get(uuid.MustParse(st.Id))
+	if err != nil {
+		return nil, err
+	}
+	job, err := newJob(q.s, rec.Kind, json.RawMessage(rec.Params))
+	if err != nil {
+		return nil, err
+	}
+	if job.status.Kind == JobArchiveImport && !archiveExists(job) {
+		return nil, BadRequestError("The archive has been removed, upload it again")
+	}
+	return q.schedule(job)
+}
+
+func (s *mserver) handleJobs(r *http.Request) (interface{}, error) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			return nil, BadRequestError("Could not parse limit")
+		}
+	}
+	return s.jobs.list(limit)
+}
+
+func (s *mserver) handleStatusJob(r *http.Request) (interface{}, error) {
+	return s.jobs.get(Var(r, "jobid"))
+}
+
+func (s *mserver) handleCancelJob(r *http.Request) (interface{}, error) {
+	return s.jobs.cancel(Var(r, "jobid"))
+}
+
+func (s *mserver) handleRetryJob(r *http.Request) (interface{}, error) {
+	return s.jobs.retry(Var(r, "jobid"))
+}
+
+// handleScheduleJob schedules jobs that only take photo ids, i.e. regenerate and reindex
+func (s *mserver) handleScheduleJob(r *http.Request) (interface{}, error) {
+	type request struct {
+		Kind     string      `json:"kind"`
+		PhotoIds []uuid.UUID `json:"photoIds"`
+	}
+	var par request
+	if err := decodeRequest(r, &par); err != nil {
+		return nil, err
+	}
+	if par.Kind != JobRegenerate && par.Kind != JobReindex {
+		return nil, BadRequestError(fmt.Sprintf("Jobs of kind %s cannot be scheduled directly", par.Kind))
+	}
+	job, err := newJob(s, par.Kind, PhotoJobParams{PhotoIds: par.PhotoIds})
+	if err != nil {
+		return nil, err
+	}
+	return s.jobs.schedule(job)
+}
+
+// photos returns the photos selected by params
+func (p PhotoJobParams) photos(s *mserver) ([]*dao.Photo, error) {
+	if len(p.PhotoIds) == 0 {
+		return s.pg.Photo.List()
+	}
+	photos := make([]*dao.Photo, 0, len(p.PhotoIds))
+	for _, id := range p.PhotoIds {
+		photo, err := s.pg.Photo.Get(id)
+		if err != nil {
+			return nil, NotFoundError("Could not find photo " + id.String())
+		}
+		photos = append(photos, photo)
+	}
+	return photos, nil
+}
+
+// photoTask runs fn for each photo selected by the job params
+func photoTask(job *Job, status string, fn func(photo *dao.Photo) error) error {
+	var params PhotoJobParams
+	if err := job.decodeParams(&params); err != nil {
+		return err
+	}
+	photos, err := params.photos(job.s)
+	if err != nil {
+		return err
+	}
+	job.setNumFiles(len(photos))
+	for _, p := range photos {
+		if err := job.cancelled(); err != nil {
+			return err
+		}
+		res := &ingest.Result{Name: p.FileName, Status: status, PhotoId: p.Id}
+		if err := fn(p); err != nil {
+			res.Status, res.Error = ingest.StatusFailed, err.Error()
+		}
+		job.addResult(res)
+		job.progress()
+	}
+	return nil
+}
+
+func regenerateTask(job *Job) error {
+	return photoTask(job, "regenerated", func(photo *dao.Photo) error {
+		return dao.GenerateImages(photo.FileName)
+	})
+}
+
+func reindexTask(job *Job) error {
+	return photoTask(job, "reindexed", func(photo *dao.Photo) error {
+		p, err := job.s.ingest.Reindex(photo)
+		if err == nil {
+			job.s.publishPhoto(events.PhotoUpdated, p)
+		}
+		return err
+	})
+}
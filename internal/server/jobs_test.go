@@ -0,0 +1,132 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"go.uber.org/zap"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memJobs is an in memory dao.JobDAO
+type memJobs struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]dao.Job
+}
+
+func (m *memJobs) Add(job *dao.Job) error {
+	return m.Update(job)
+}
+
+func (m *memJobs) Get(id uuid.UUID) (*dao.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, found := m.jobs[id]; found {
+		return &job, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memJobs) List(_ int) ([]*dao.Job, error) {
+	return m.ListState(StateScheduled, StateStarted, StateFinished, StateAborted, StateCancelled)
+}
+
+func (m *memJobs) ListState(states ...string) ([]*dao.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []*dao.Job
+	for _, job := range m.jobs {
+		for _, st := range states {
+			if job.State == st {
+				j := job
+				ret = append(ret, &j)
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (m *memJobs) Prune(_ []string, _ time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *memJobs) Update(job *dao.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.Id] = *job
+	return nil
+}
+
+func (m *memJobs) state(t *testing.T, id string) string {
+	job, err := m.Get(uuid.MustParse(id))
+	if err != nil {
+		t.Fatalf("job %s not stored", id)
+	}
+	return job.State
+}
+
+const jobTest = "TEST"
+
+func TestJobQueueClose(t *testing.T) {
+	started := make(chan *Job, 1)
+	var blocking = true
+	jobTasks[jobTest] = func(job *Job) error {
+		if blocking {
+			started <- job
+			<-job.ctx.Done()
+			return job.cancelled()
+		}
+		return nil
+	}
+	defer delete(jobTasks, jobTest)
+
+	jobs := &memJobs{jobs: map[uuid.UUID]dao.Job{}}
+	s := &mserver{l: zap.NewNop().Sugar(), pg: &dao.PGDB{Job: jobs}, events: events.NewBroker()}
+	s.jobs = newJobQueue(s)
+	running, _ := newJob(s, jobTest, nil)
+	queued, _ := newJob(s, jobTest, nil)
+	for _, job := range []*Job{running, queued} {
+		if _, err := s.jobs.schedule(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.jobs.Start(1)
+	<-started
+	s.jobs.Close()
+	for _, job := range []*Job{running, queued} {
+		if st := jobs.state(t, job.status.Id); st != StateScheduled {
+			t.Errorf("expected job to be left scheduled got %s", st)
+		}
+	}
+
+	//the jobs are run again once the server starts
+	blocking = false
+	s.jobs = newJobQueue(s)
+	s.jobs.Start(1)
+	defer s.jobs.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, job := range []*Job{running, queued} {
+		for jobs.state(t, job.status.Id) != StateFinished {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected job to be finished got %s", jobs.state(t, job.status.Id))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRetryArchiveImport(t *testing.T) {
+	jobs := &memJobs{jobs: map[uuid.UUID]dao.Job{}}
+	s := &mserver{l: zap.NewNop().Sugar(), pg: &dao.PGDB{Job: jobs}, events: events.NewBroker()}
+	s.jobs = newJobQueue(s)
+	id := uuid.New()
+	params := `{"path":"` + filepath.Join(t.TempDir(), "archive.zip") + `"}`
+	_ = jobs.Add(&dao.Job{Id: id, Kind: JobArchiveImport, State: StateCancelled, Params: params})
+	if _, err := s.jobs.retry(id.String()); err == nil {
+		t.Errorf("expected retry to fail when the archive is gone")
+	}
+}
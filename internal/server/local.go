@@ -1,28 +1,28 @@
 package server
 
 import (
-	"crypto/md5"
+	"errors"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/msvens/mimage/metadata"
 	"github.com/msvens/mphotos/internal/config"
-	"github.com/msvens/mphotos/internal/dao"
-	"github.com/msvens/mphotos/internal/gdrive"
-	"go.uber.org/zap"
+	"github.com/msvens/mphotos/internal/ingest"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func calcMD5(src io.Reader) (string, error) {
-	var ret string
-	hash := md5.New()
-	if _, err := io.Copy(hash, src); err != nil {
-		return ret, err
+// ingestError converts ingest errors to api errors
+func ingestError(err error) error {
+	switch {
+	case errors.Is(err, ingest.ErrExists):
+		return BadRequestError("Photo already exists")
+	case errors.Is(err, ingest.ErrNotJpeg):
+		return BadRequestError(err.Error())
+	default:
+		return err
 	}
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
 func (s *mserver) handleUploadLocalPhoto(r *http.Request) (interface{}, error) {
@@ -32,31 +32,7 @@ func (s *mserver) handleUploadLocalPhoto(r *http.Request) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	//get some information about the file
-	md5str, err := calcMD5(file)
-	if err != nil {
-		return nil, err
-	}
-
-	if s.pg.Photo.HasMd5(md5str) {
-		return nil, BadRequestError("Photo already exists")
-	}
-
-	if _, err = file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-
-	//Detect type
-	buff := make([]byte, 512)
-	_, err = file.Read(buff)
-	if err != nil {
-		return nil, err
-	}
-	mt := http.DetectContentType(buff)
-	if mt != gdrive.Jpeg {
-		return nil, BadRequestError("Image is not of the correct mimetype: " + mt)
-	}
+	defer file.Close()
 
 	sourceId := r.FormValue("sourceId")
 	if sourceId == "" {
@@ -71,197 +47,117 @@ func (s *mserver) handleUploadLocalPhoto(r *http.Request) (interface{}, error) {
 			fmt.Println(err.Error())
 		}
 	}
-
-	photo := dao.Photo{}
-	photo.Id = uuid.New()
-	photo.Source = dao.SourceLocal
-	photo.SourceId = sourceId
-	photo.Md5 = md5str
-	photo.SourceDate = sourceDate
-	photo.UploadDate = time.Now()
-	photo.FileName = photo.Id.String() + ".jpg"
-
-	if _, err = file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-
-	dst, err := os.Create(config.PhotoFilePath(config.Original, photo.FileName))
-	if _, err = io.Copy(dst, file); err != nil {
-		return nil, err
-	}
-	dst.Close()
-
-	/*
-		if err := GenerateImages(config.PhotoFilePath(config.Original, photo.FileName), config.ServiceRoot()); err != nil {
-			return nil, err
-		}*/
-	if err := dao.GenerateImages(photo.FileName); err != nil {
-		return nil, err
+	if photo, err := s.ingest.Add(ingest.NewReaderItem(file, sourceId, sourceDate)); err != nil {
+		return nil, ingestError(err)
+	} else {
+		return photo, nil
 	}
+}
 
-	var md *metadata.MetaData
-
-	if md, err = metadata.NewMetaDataFromFile(config.PhotoFilePath(config.Original, photo.FileName)); err == nil {
-		photo.CameraMake = md.Summary().CameraMake
-		photo.CameraModel = md.Summary().CameraModel
-		photo.FocalLength = fmt.Sprintf("%v mm", md.Summary().FocalLength.Float32())
-		photo.FocalLength35 = fmt.Sprintf("%v mm", md.Summary().FocalLengthIn35mmFormat)
-		photo.LensMake = md.Summary().LensMake
-		photo.LensModel = md.Summary().LensModel
-		photo.Exposure = md.Summary().ExposureTime.String()
-		photo.Width = md.ImageWidth
-		photo.Height = md.ImageHeight
-		photo.FNumber = md.Summary().FNumber.Float32()
-		photo.Iso = uint(md.Summary().ISO)
-		photo.Title = md.Summary().Title
-		if len(md.Summary().Keywords) > 0 {
-			photo.Keywords = strings.Join(md.Summary().Keywords, ",")
+// handleImportArchive streams a zip archive (either as the request body or as the
+// "archive" part of a multipart form) to disk and imports it in a background job
+func (s *mserver) handleImportArchive(r *http.Request) (interface{}, error) {
+	createAlbum, _ := strconv.ParseBool(r.URL.Query().Get("createAlbum"))
+	albumName := r.URL.Query().Get("album")
+
+	var src io.Reader = r.Body
+	archiveName := r.URL.Query().Get("name")
+	if strings.HasPrefix(r.Header.Get(contentType), "multipart/") {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, BadRequestError(err.Error())
 		}
-		if md.Summary().OriginalDate.IsZero() {
-			photo.OriginalDate = photo.SourceDate
-		} else {
-			photo.OriginalDate = md.Summary().OriginalDate
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, BadRequestError("no archive in request")
+			} else if err != nil {
+				return nil, BadRequestError(err.Error())
+			}
+			if part.FormName() == "archive" {
+				src = part
+				if archiveName == "" {
+					archiveName = part.FileName()
+				}
+				break
+			}
 		}
-	} else {
-		return nil, err
 	}
-
-	if err = s.pg.Photo.Add(&photo, md.Summary()); err != nil {
-		s.l.Errorw("error adding img: ", zap.Error(err))
-		return nil, err
-	}
-	if !s.pg.Camera.HasModel(photo.CameraModel) {
-		if err = s.pg.Camera.AddFromPhoto(&photo); err != nil {
-			s.l.Fatalw("error adding camera model: ", zap.Error(err))
+	if createAlbum && albumName == "" {
+		if archiveName == "" {
+			return nil, BadRequestError("album name or archive name needed to create album")
 		}
+		albumName = ingest.AlbumName(archiveName)
 	}
-
-	s.l.Infow("added img", "Id", photo.Id, "SourceId", photo.SourceId)
-
-	return &photo, nil
-}
-
-/*
-func (s *mserver) handleUploadLocalPhoto(r *http.Request) (interface{}, error) {
-
-	r.ParseMultipartForm(10 << 20) //10M
-	file, head, err := r.FormFile("image")
-	if err != nil {
+	if err := os.MkdirAll(config.ServicePath(uploadsDir), 0744); err != nil {
 		return nil, err
 	}
-
-	//get some information about the file
-	md5str, err := calcMD5(file)
+	tmp, err := os.CreateTemp(config.ServicePath(uploadsDir), "import-*.zip")
 	if err != nil {
 		return nil, err
 	}
-
-	if s.pg.Photo.HasMd5(md5str) {
-		return nil, BadRequestError("Photo already exists")
-	}
-
-	if _, err = file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
-	}
-
-	//Detect type
-	buff := make([]byte, 512)
-	_, err = file.Read(buff)
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
 	if err != nil {
+		_ = os.Remove(tmp.Name())
 		return nil, err
 	}
-	mt := http.DetectContentType(buff)
-	if mt != gdrive.Jpeg {
-		return nil, BadRequestError("Image is not of the correct mimetype: " + mt)
-	}
-
-	sourceId := r.FormValue("sourceId")
-	if sourceId == "" {
-		sourceId = head.Filename
-	}
-	sourceDateStr := r.FormValue("sourceDate")
-	sourceDate := time.Now()
-	if sourceDateStr != "" {
-		if d,err := time.Parse(time.RFC3339, sourceDateStr); err == nil {
-			sourceDate = d
-		} else {
-			fmt.Println(err.Error())
-		}
+	numFiles, err := ingest.CountImages(tmp.Name())
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, BadRequestError("could not read archive: " + err.Error())
 	}
-	fmt.Println(sourceId, " ", sourceDateStr)
-
-	photo := dao.Photo{}
-	photo.Id = uuid.New()
-	photo.Source = dao.SourceLocal
-	photo.SourceId = sourceId
-	photo.Md5 = md5str
-	photo.SourceDate = sourceDate
-	photo.UploadDate = time.Now()
-	photo.FileName = photo.Id.String()+".jpg"
-	photo.Private = true
-
-	if _, err = file.Seek(0, io.SeekStart); err != nil {
+	params := archiveJobParams{Path: tmp.Name(), Options: ingest.ArchiveOptions{CreateAlbum: createAlbum, AlbumName: albumName}}
+	job, err := newJob(s, JobArchiveImport, params)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
 		return nil, err
 	}
+	s.l.Infow("scheduled archive import", "archive", archiveName, "files", numFiles, "album", albumName)
+	return s.jobs.schedule(job)
+}
 
-	dst, err := os.Create(imgPath(s, photo.FileName))
-	if _, err = io.Copy(dst, file); err != nil {
-		return nil, err
-	}
-	dst.Close()
+type archiveJobParams struct {
+	Path    string                `json:"path"`
+	Options ingest.ArchiveOptions `json:"options"`
+}
 
-	if err := GenerateImages(imgPath(s, photo.FileName), config.ServiceRoot()); err != nil {
-		return nil, err
+// archiveImportTask imports an uploaded archive. The archive is kept if the import fails or is
+// interrupted so that the job can be retried or resumed
+func archiveImportTask(job *Job) error {
+	var params archiveJobParams
+	if err := job.decodeParams(&params); err != nil {
+		return err
 	}
-
-	var exif *mexif.ExifCompact
-	tool, err := mexif.NewMExifTool()
+	numFiles, err := ingest.CountImages(params.Path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	if exif, err = tool.ExifCompact(imgPath(s, photo.FileName)); err == nil {
-		photo.CameraMake = exif.CameraMake
-		photo.CameraModel = exif.CameraModel
-		photo.FocalLength = exif.FocalLength
-		photo.FocalLength35 = exif.FocalLengthIn35mmFormat
-		photo.LensMake = exif.LensMake
-		photo.LensModel = exif.LensModel
-		photo.Exposure = exif.ExposureTime
-		photo.Width = exif.ImageWidth
-		photo.Height = exif.ImageHeight
-		photo.FNumber = exif.FNumber
-		photo.Iso = exif.ISO
-		photo.Title = exif.Title
-		if len(exif.Keywords) > 0 {
-			photo.Keywords = strings.Join(exif.Keywords, ",")
-		}
-		if exif.OriginalDate.IsZero() {
-			photo.OriginalDate = photo.SourceDate
-		} else {
-			photo.OriginalDate = exif.OriginalDate
-		}
-	} else {
-		return nil, err
+	job.setNumFiles(numFiles)
+	_, err = job.s.ingest.WithContext(job.ctx).ImportPath(params.Path, params.Options, func(res *ingest.Result) {
+		job.addResult(res)
+		job.progress()
+	})
+	if err == nil || (job.cancelled() != nil && !job.isInterrupted()) {
+		_ = os.Remove(params.Path)
 	}
+	return err
+}
 
-	if err = s.pg.Photo.Add(&photo, exif); err != nil {
-		s.l.Errorw("error adding img: ", zap.Error(err))
-		return nil, err
-	}
-	if !s.pg.Camera.HasModel(photo.CameraModel) {
-		if err = s.pg.Camera.AddFromPhoto(&photo); err != nil {
-			s.l.Fatalw("error adding camera model: ", zap.Error(err))
-		}
+// archiveExists returns true if the archive of an archive import job is still there, it is
+// removed once the import finishes or is cancelled
+func archiveExists(job *Job) bool {
+	var params archiveJobParams
+	if err := job.decodeParams(&params); err != nil {
+		return false
 	}
-
-	s.l.Infow("added img", "Id", photo.Id, "SourceId", photo.SourceId)
-
-	return &photo, nil
-
+	_, err := os.Stat(params.Path)
+	return err == nil
 }
-*/
-func (s *mserver) handleCheckLocalPhotos(r *http.Request) (interface{}, error) {
 
-	return nil, nil
+func (s *mserver) handleCheckLocalPhotos(_ *http.Request) (interface{}, error) {
+	if s.inbox == nil {
+		return nil, NotFoundError("Inbox folder has not been set")
+	}
+	return s.inbox.check()
 }
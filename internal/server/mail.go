@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/gmail"
+	"github.com/msvens/mphotos/internal/mail"
+	htmltemplate "html/template"
+	netmail "net/mail"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Every email has an html (name.html) and a plain text (name.txt) template in tmpl. The
+// templates are parsed when the mailer is created
+var (
+	templates     *htmltemplate.Template
+	textTemplates *texttemplate.Template
+)
+
+func parseTemplates() error {
+	var err error
+	if templates, err = htmltemplate.ParseGlob("tmpl/*-email.html"); err != nil {
+		return err
+	}
+	textTemplates, err = texttemplate.ParseGlob("tmpl/*-email.txt")
+	return err
+}
+
+func newMailer(s *mserver) (mail.Mailer, error) {
+	if err := parseTemplates(); err != nil {
+		return nil, err
+	}
+	from := config.MailFrom()
+	if _, err := netmail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid mail.from %q: %w", from, err)
+	}
+	switch config.MailDriver() {
+	case config.MailDriverSmtp:
+		return &mail.SMTP{From: from, Host: config.MailSmtpHost(), Port: config.MailSmtpPort(),
+			Username: config.MailSmtpUser(), Password: config.MailSmtpPassword()}, nil
+	case config.MailDriverFile:
+		return &mail.File{From: from, Dir: config.MailDir()}, nil
+	case config.MailDriverLog:
+		return &mail.Log{From: from, L: s.l}, nil
+	default:
+		return &mail.Gmail{From: from, Service: func() *gmail.GmailService { return s.ms }}, nil
+	}
+}
+
+// sendMail renders the templates name.html and name.txt with data and sends the result to to
+func (s *mserver) sendMail(to, subject, name string, data interface{}) error {
+	var html, text strings.Builder
+	if err := templates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return err
+	}
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return err
+	}
+	return s.mailer.Send(&mail.Message{To: to, Subject: subject, Text: text.String(), Html: html.String()})
+}
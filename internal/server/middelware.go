@@ -109,6 +109,8 @@ func (s *mserver) guestOnly(gh guestHandler) http.HandlerFunc {
 		var guest = ctxGuest(r.Context())
 		if guest == emptyuuid {
 			psResponse(nil, UnauthorizedError("guest not found"), w)
+		} else if g, err := s.pg.Guest.Get(guest); err == nil && g.Banned {
+			psResponse(nil, UnauthorizedError("guest is banned"), w)
 		} else {
 			data, err := gh(r, guest)
 			psResponse(data, err, w)
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"net/http"
+	netmail "net/mail"
+	"sync"
+	"time"
+)
+
+// The owner is notified about new comments, newly verified guests, like milestones and submitted
+// proofing selections, either immediately or in a daily or weekly digest. Notifications are queued
+// in the db until they are sent and the preferences are stored under the notifications key of the
+// user config
+
+// Notification delivery modes
+const (
+	DeliveryOff       = "off"
+	DeliveryImmediate = "immediate"
+	DeliveryDaily     = "daily"
+	DeliveryWeekly    = "weekly"
+)
+
+// Notification kinds
+const (
+	NotifyComment  = "comment"
+	NotifyGuest    = "guest"
+	NotifyLikes    = "likes"
+	NotifyProofing = "proofing"
+)
+
+const (
+	notificationsKey = "notifications"
+	// digests are sent every morning, weekly digests on mondays
+	digestSchedule        = "0 8 * * *"
+	notificationRetention = 90 * 24 * time.Hour
+)
+
+// likeMilestones are the number of reactions of a kind on a photo that the owner is notified about
+var likeMilestones = []int{1, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// NotificationPrefs controls which notifications the owner gets and how. Email defaults to the
+// sender address of outgoing email
+type NotificationPrefs struct {
+	Email    string `json:"email"`
+	Delivery string `json:"delivery"`
+	Comments bool   `json:"comments"`
+	Guests   bool   `json:"guests"`
+	Likes    bool   `json:"likes"`
+	Proofing bool   `json:"proofing"`
+}
+
+type NotificationEmail struct {
+	Notifications []*dao.Notification
+}
+
+func defaultNotificationPrefs() *NotificationPrefs {
+	return &NotificationPrefs{Delivery: DeliveryOff, Comments: true, Guests: true, Likes: true, Proofing: true}
+}
+
+func (np *NotificationPrefs) wants(kind string) bool {
+	switch {
+	case np.Delivery == DeliveryOff:
+		return false
+	case kind == NotifyComment:
+		return np.Comments
+	case kind == NotifyGuest:
+		return np.Guests
+	case kind == NotifyLikes:
+		return np.Likes
+	case kind == NotifyProofing:
+		return np.Proofing
+	}
+	return false
+}
+
+func (np *NotificationPrefs) validate() error {
+	switch np.Delivery {
+	case DeliveryOff, DeliveryImmediate, DeliveryDaily, DeliveryWeekly:
+	default:
+		return BadRequestError("Unknown delivery: " + np.Delivery)
+	}
+	if np.Email != "" {
+		if _, err := netmail.ParseAddress(np.Email); err != nil {
+			return BadRequestError("Invalid email: " + np.Email)
+		}
+	}
+	return nil
+}
+
+// to returns the address notifications are sent to
+func (np *NotificationPrefs) to() (string, error) {
+	if np.Email != "" {
+		return np.Email, nil
+	}
+	addr, err := netmail.ParseAddress(config.MailFrom())
+	if err != nil {
+		return "", fmt.Errorf("no notification email configured")
+	}
+	return addr.Address, nil
+}
+
+func (s *mserver) notificationPrefs() (*NotificationPrefs, error) {
+	prefs := defaultNotificationPrefs()
+	if err := s.configValue(notificationsKey, prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+type notifier struct {
+	s    *mserver
+	sub  *events.Subscription
+	cron *cron.Cron
+	mu   sync.Mutex
+	wg   sync.WaitGroup
+}
+
+func newNotifier(s *mserver) *notifier {
+	n := &notifier{s: s, cron: cron.New()}
+	n.sub = s.events.Subscribe([]string{events.TopicComment, events.TopicReaction, events.TopicGuest, events.TopicAlbum}, true)
+	if _, err := n.cron.AddFunc(digestSchedule, n.digest); err != nil {
+		s.l.Panicw("could not schedule notification digest", zap.Error(err))
+	}
+	n.cron.Start()
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for e := range n.sub.C {
+			n.handle(e)
+		}
+	}()
+	return n
+}
+
+// Close stops listening for events and waits for an ongoing digest to be sent
+func (n *notifier) Close() {
+	n.sub.Close()
+	<-n.cron.Stop().Done()
+	n.wg.Wait()
+}
+
+func (n *notifier) photoTitle(id uuid.UUID) string {
+	if p, err := n.s.pg.Photo.Get(id); err == nil && p.Title != "" {
+		return fmt.Sprintf("%q", p.Title)
+	}
+	return "a photo"
+}
+
+func (n *notifier) handle(e *events.Event) {
+	var note *dao.Notification
+	switch data := e.Data.(type) {
+	case *CommentEvent:
+		if e.Type != events.CommentAdded || data.Owner || data.State == dao.CommentSpam {
+			return
+		}
+		msg := fmt.Sprintf("%s commented on %s: %s", data.Name, n.photoTitle(data.PhotoId), data.Body)
+		switch data.State {
+		case dao.CommentPending:
+			msg += " (awaiting approval)"
+		case dao.CommentHeld:
+			msg += " (held as possible spam)"
+		}
+		note = &dao.Notification{Kind: NotifyComment, PhotoId: data.PhotoId, Message: msg}
+	case *ReactionEvent:
+		if e.Type != events.ReactionAdded {
+			return
+		}
+		counts, err := n.s.pg.Reaction.Counts(data.PhotoId)
+		cnt := counts[data.PhotoId][data.Kind]
+		if err != nil || !isMilestone(cnt) {
+			return
+		}
+		//reactions can be removed and added again, only notify the first time a milestone is reached
+		if reached, err := n.s.pg.Notification.ReachMilestone(data.PhotoId, data.Kind, cnt); err != nil {
+			n.s.l.Errorw("could not record milestone", "photo", data.PhotoId, zap.Error(err))
+			return
+		} else if !reached {
+			return
+		}
+		msg := fmt.Sprintf("%s now has %d %s reactions", n.photoTitle(data.PhotoId), cnt, data.Kind)
+		if cnt == 1 {
+			msg = fmt.Sprintf("%s got its first %s from %s", n.photoTitle(data.PhotoId), data.Kind, data.Name)
+		}
+		note = &dao.Notification{Kind: NotifyLikes, PhotoId: data.PhotoId, Message: msg}
+	case *GuestEvent:
+		note = &dao.Notification{Kind: NotifyGuest, Message: fmt.Sprintf("%s is a new verified guest", data.Name)}
+	case *ProofEvent:
+		msg := fmt.Sprintf("%s submitted %d selected photos in %s", data.Name, data.Selected, data.Album)
+		note = &dao.Notification{Kind: NotifyProofing, Message: msg}
+	default:
+		return
+	}
+	if err := n.notify(note); err != nil {
+		n.s.l.Errorw("could not notify owner", "kind", note.Kind, zap.Error(err))
+	}
+}
+
+func isMilestone(likes int) bool {
+	for _, m := range likeMilestones {
+		if m == likes {
+			return true
+		}
+	}
+	return false
+}
+
+// notify queues note if the owner wants it and sends it right away if delivery is immediate
+func (n *notifier) notify(note *dao.Notification) error {
+	prefs, err := n.s.notificationPrefs()
+	if err != nil || !prefs.wants(note.Kind) {
+		return err
+	}
+	note.Created = time.Now()
+	if err = n.s.pg.Notification.Add(note); err != nil {
+		return err
+	}
+	if prefs.Delivery == DeliveryImmediate {
+		return n.send(prefs)
+	}
+	return nil
+}
+
+func (n *notifier) digest() {
+	prefs, err := n.s.notificationPrefs()
+	if err != nil {
+		n.s.l.Errorw("could not read notification preferences", zap.Error(err))
+		return
+	}
+	if prefs.Delivery == DeliveryDaily || (prefs.Delivery == DeliveryWeekly && time.Now().Weekday() == time.Monday) {
+		if err = n.send(prefs); err != nil {
+			n.s.l.Errorw("could not send notification digest", zap.Error(err))
+		}
+	}
+	if _, err = n.s.pg.Notification.DeleteSent(time.Now().Add(-notificationRetention)); err != nil {
+		n.s.l.Errorw("could not delete old notifications", zap.Error(err))
+	}
+}
+
+// send mails all pending notifications to the owner, as a digest if there are more than one
+func (n *notifier) send(prefs *NotificationPrefs) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	pending, err := n.s.pg.Notification.Pending()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	to, err := prefs.to()
+	if err != nil {
+		return err
+	}
+	subject := pending[0].Message
+	if len(pending) > 1 {
+		subject = fmt.Sprintf("Mellowtech Photos: %d new notifications", len(pending))
+	}
+	if err = n.s.sendMail(to, subject, "notification-email", NotificationEmail{pending}); err != nil {
+		return err
+	}
+	ids := make([]int, len(pending))
+	for i, note := range pending {
+		ids[i] = note.Id
+	}
+	return n.s.pg.Notification.MarkSent(ids)
+}
+
+func (s *mserver) handleNotificationPrefs(_ *http.Request) (interface{}, error) {
+	return s.notificationPrefs()
+}
+
+func (s *mserver) handleUpdateNotificationPrefs(r *http.Request) (interface{}, error) {
+	prefs := defaultNotificationPrefs()
+	if err := decodeRequest(r, prefs); err != nil {
+		return nil, err
+	}
+	if err := prefs.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.setConfigValue(notificationsKey, prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
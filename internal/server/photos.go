@@ -4,6 +4,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/msvens/mphotos/internal/config"
 	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
 	"go.uber.org/zap"
 	"net/http"
 )
@@ -21,12 +22,14 @@ func deletePhoto(s *mserver, p *dao.Photo, removeFiles bool) (*dao.Photo, error)
 		return nil, NotFoundError("Photo not found")
 	}
 	if !removeFiles {
+		s.publishPhoto(events.PhotoDeleted, p)
 		return p, nil
 	}
 	if err := dao.DeleteImg(p.FileName); err != nil {
 		s.l.Errorw("Could not remove "+p.FileName, zap.Error(err))
 	}
 	s.l.Infow("Photo deleted", "id", p.Id)
+	s.publishPhoto(events.PhotoDeleted, p)
 	return p, nil
 }
 
@@ -213,7 +216,7 @@ func (s *mserver) handlePhoto(w http.ResponseWriter, r *http.Request) (interface
 	if photo, err := s.pg.Photo.Get(id); err != nil {
 		return nil, err
 	} else {
-		return photo, nil
+		return photo, s.withReactions(photo)
 	}
 }
 
@@ -230,7 +233,7 @@ func (s *mserver) handlePhotos(r *http.Request) (interface{}, error) {
 		if photos, e1 := s.pg.Photo.List(); err != nil {
 			return nil, e1
 		} else {
-			return &PhotoFiles{Length: len(photos), Photos: photos}, nil
+			return &PhotoFiles{Length: len(photos), Photos: photos}, s.withReactions(photos...)
 		}
 
 	}
@@ -276,9 +279,33 @@ func (s *mserver) handleUpdatePhoto(r *http.Request) (interface{}, error) {
 	var par request
 	if err := decodeRequest(r, &par); err != nil {
 		return nil, err
-	} else {
-		return s.pg.Photo.Set(par.Title, par.Description, par.Keywords, par.Id)
 	}
+	photo, err := s.pg.Photo.Set(par.Title, par.Description, par.Keywords, par.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.publishPhoto(events.PhotoUpdated, photo)
+	return photo, nil
+}
+
+func (s *mserver) handleUpdatePhotoFavorite(r *http.Request) (interface{}, error) {
+	type request struct {
+		Favorite bool `json:"favorite"`
+	}
+	var id uuid.UUID
+	var par request
+	if err := uid(r, "photoid", &id); err != nil {
+		return nil, err
+	}
+	if err := decodeRequest(r, &par); err != nil {
+		return nil, err
+	}
+	photo, err := s.pg.Photo.SetFavorite(par.Favorite, id)
+	if err != nil {
+		return nil, err
+	}
+	s.publishPhoto(events.PhotoUpdated, photo)
+	return photo, nil
 }
 
 /*
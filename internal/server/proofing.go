@@ -0,0 +1,345 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"net/http"
+	"time"
+)
+
+// Clients proof a shoot in an album with proofing turned on. A client is a guest that knows
+// the album code. Each guest selects photos, optionally with a note per photo, and submits the
+// selection when done. A submitted selection is locked until the owner unlocks it. The owner
+// sees the selections of all guests and exports the selected files for retouching
+
+// ProofStatus is a guest's view of a proofing album
+type ProofStatus struct {
+	Album      *dao.Album            `json:"album"`
+	Photos     []*dao.Photo          `json:"photos"`
+	Selections []*dao.ProofSelection `json:"selections"`
+	Selected   int                   `json:"selected"`
+	Submitted  *time.Time            `json:"submitted,omitempty"`
+}
+
+// GuestProof is the selection of one guest in a proofing album as seen by the owner
+type GuestProof struct {
+	Id         uuid.UUID             `json:"id"`
+	Name       string                `json:"name"`
+	Email      string                `json:"email"`
+	Submitted  *time.Time            `json:"submitted,omitempty"`
+	Selections []*dao.ProofSelection `json:"selections"`
+}
+
+// ProofPhoto is a photo that at least one guest has selected, with the names of the guests that
+// selected it and their notes
+type ProofPhoto struct {
+	PhotoId  uuid.UUID `json:"photoId"`
+	FileName string    `json:"fileName"`
+	SourceId string    `json:"sourceId"`
+	Guests   []string  `json:"guests"`
+	Notes    []string  `json:"notes"`
+}
+
+// ProofSummary is the consolidated selection of a proofing album
+type ProofSummary struct {
+	Album  *dao.Album    `json:"album"`
+	Guests []*GuestProof `json:"guests"`
+	Photos []*ProofPhoto `json:"photos"`
+	//the photos of the album in album order
+	photos []*dao.Photo
+}
+
+// ProofEvent is the data of album.submitted events, sent when a guest submits their selection
+// in a proofing album
+type ProofEvent struct {
+	AlbumId  uuid.UUID `json:"albumId"`
+	Album    string    `json:"album"`
+	Name     string    `json:"name"`
+	Selected int       `json:"selected"`
+}
+
+// proofingAlbum returns the album in the request if it is in proofing mode and code matches
+func (s *mserver) proofingAlbum(r *http.Request, code string) (*dao.Album, error) {
+	var id uuid.UUID
+	if err := uid(r, "albumid", &id); err != nil {
+		return nil, err
+	}
+	album, err := s.pg.Album.Get(id)
+	if err != nil || !album.Proofing {
+		return nil, NotFoundError("Proofing album not found")
+	}
+	if album.Code != code {
+		return nil, UnauthorizedError("Album code did not match")
+	}
+	return album, nil
+}
+
+// proofSubmission returns when guestId submitted their selection in albumId, nil if not submitted
+func (s *mserver) proofSubmission(albumId, guestId uuid.UUID) (*time.Time, error) {
+	sub, err := s.pg.Proof.Submission(albumId, guestId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &sub.Submitted, nil
+}
+
+func (s *mserver) proofStatus(album *dao.Album, guestId uuid.UUID) (*ProofStatus, error) {
+	var err error
+	ret := ProofStatus{Album: album}
+	if ret.Photos, err = s.pg.Album.SelectPhotos(album.Id, dao.PhotoFilter{}, dao.Range{}, album.OrderBy); err != nil {
+		return nil, err
+	}
+	if ret.Selections, err = s.pg.Proof.Selections(album.Id, guestId); err != nil {
+		return nil, err
+	}
+	for _, sel := range ret.Selections {
+		if sel.Selected {
+			ret.Selected++
+		}
+	}
+	if ret.Submitted, err = s.proofSubmission(album.Id, guestId); err != nil {
+		return nil, err
+	}
+	album.Code = ""
+	return &ret, nil
+}
+
+func (s *mserver) handleProofing(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Code string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	album, err := s.proofingAlbum(r, params.Code)
+	if err != nil {
+		return nil, err
+	}
+	return s.proofStatus(album, guestId)
+}
+
+// handleProofPhoto selects or deselects a photo and sets the guest's note on it. Fields that are
+// not in the request are left unchanged
+func (s *mserver) handleProofPhoto(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Code     string
+		Selected *bool
+		Note     *string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	album, err := s.proofingAlbum(r, params.Code)
+	if err != nil {
+		return nil, err
+	}
+	var photoId uuid.UUID
+	if err = uid(r, "photoid", &photoId); err != nil {
+		return nil, err
+	}
+	if submitted, err := s.proofSubmission(album.Id, guestId); err != nil {
+		return nil, err
+	} else if submitted != nil {
+		return nil, BadRequestError("Selection has been submitted and is locked")
+	}
+	if !s.inAlbum(album.Id, photoId) {
+		return nil, NotFoundError("Photo is not in album")
+	}
+	sel := &dao.ProofSelection{AlbumId: album.Id, GuestId: guestId, PhotoId: photoId}
+	selections, err := s.pg.Proof.Selections(album.Id, guestId)
+	if err != nil {
+		return nil, err
+	}
+	for _, prev := range selections {
+		if prev.PhotoId == photoId {
+			sel = prev
+		}
+	}
+	if params.Selected != nil {
+		if *params.Selected && !sel.Selected && album.MaxSelections > 0 {
+			if cnt, err := s.pg.Proof.CountSelected(album.Id, guestId); err != nil {
+				return nil, err
+			} else if cnt >= album.MaxSelections {
+				return nil, BadRequestError(fmt.Sprintf("At most %d photos can be selected", album.MaxSelections))
+			}
+		}
+		sel.Selected = *params.Selected
+	}
+	if params.Note != nil {
+		sel.Note = *params.Note
+	}
+	sel.Updated = time.Now()
+	if err = s.pg.Proof.Set(sel); err != nil {
+		return nil, err
+	}
+	return sel, nil
+}
+
+func (s *mserver) inAlbum(albumId, photoId uuid.UUID) bool {
+	photos, err := s.pg.Album.Photos(albumId)
+	if err != nil {
+		return false
+	}
+	for _, p := range photos {
+		if p.Id == photoId {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSubmitProof submits and locks the guest's selection
+func (s *mserver) handleSubmitProof(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	type request struct {
+		Code string
+	}
+	var params request
+	if err := decodeRequest(r, &params); err != nil {
+		return nil, err
+	}
+	album, err := s.proofingAlbum(r, params.Code)
+	if err != nil {
+		return nil, err
+	}
+	cnt, err := s.pg.Proof.CountSelected(album.Id, guestId)
+	if err != nil {
+		return nil, err
+	}
+	if cnt == 0 {
+		return nil, BadRequestError("No photos have been selected")
+	}
+	if album.MaxSelections > 0 && cnt > album.MaxSelections {
+		return nil, BadRequestError(fmt.Sprintf("At most %d photos can be selected", album.MaxSelections))
+	}
+	if submitted, err := s.proofSubmission(album.Id, guestId); err != nil {
+		return nil, err
+	} else if submitted != nil {
+		return nil, BadRequestError("Selection has already been submitted")
+	}
+	if err = s.pg.Proof.Submit(&dao.ProofSubmission{AlbumId: album.Id, GuestId: guestId, Submitted: time.Now()}); err != nil {
+		return nil, err
+	}
+	ev := ProofEvent{AlbumId: album.Id, Album: album.Name, Selected: cnt}
+	if g, err := s.pg.Guest.Get(guestId); err == nil {
+		ev.Name = g.Name
+	}
+	s.events.Publish(events.AlbumSubmitted, true, &ev)
+	return s.proofStatus(album, guestId)
+}
+
+func (s *mserver) proofSummary(r *http.Request) (*ProofSummary, error) {
+	var id uuid.UUID
+	if err := uid(r, "albumid", &id); err != nil {
+		return nil, err
+	}
+	album, err := s.pg.Album.Get(id)
+	if err != nil || !album.Proofing {
+		return nil, NotFoundError("Proofing album not found")
+	}
+	selections, err := s.pg.Proof.ListByAlbum(album.Id)
+	if err != nil {
+		return nil, err
+	}
+	submissions, err := s.pg.Proof.Submissions(album.Id)
+	if err != nil {
+		return nil, err
+	}
+	photos, err := s.pg.Album.SelectPhotos(album.Id, dao.PhotoFilter{}, dao.Range{}, album.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	ret := ProofSummary{Album: album, Guests: []*GuestProof{}, photos: photos}
+	guests := map[uuid.UUID]*GuestProof{}
+	for _, sel := range selections {
+		gp, found := guests[sel.GuestId]
+		if !found {
+			gp = &GuestProof{Id: sel.GuestId, Selections: []*dao.ProofSelection{}}
+			if g, err := s.pg.Guest.Get(sel.GuestId); err == nil {
+				gp.Name, gp.Email = g.Name, g.Email
+			}
+			guests[sel.GuestId] = gp
+			ret.Guests = append(ret.Guests, gp)
+		}
+		gp.Selections = append(gp.Selections, sel)
+	}
+	for _, sub := range submissions {
+		if gp, found := guests[sub.GuestId]; found {
+			submitted := sub.Submitted
+			gp.Submitted = &submitted
+		}
+	}
+	ret.Photos = proofPhotos(photos, ret.Guests)
+	return &ret, nil
+}
+
+// proofPhotos returns the photos selected by guests in album order
+func proofPhotos(photos []*dao.Photo, guests []*GuestProof) []*ProofPhoto {
+	ret := []*ProofPhoto{}
+	for _, p := range photos {
+		var pp *ProofPhoto
+		for _, gp := range guests {
+			for _, sel := range gp.Selections {
+				if sel.PhotoId != p.Id || !sel.Selected {
+					continue
+				}
+				if pp == nil {
+					pp = &ProofPhoto{PhotoId: p.Id, FileName: p.FileName, SourceId: p.SourceId, Guests: []string{},
+						Notes: []string{}}
+					ret = append(ret, pp)
+				}
+				pp.Guests = append(pp.Guests, gp.Name)
+				if sel.Note != "" {
+					pp.Notes = append(pp.Notes, gp.Name+": "+sel.Note)
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// handleAlbumProofing returns the selections of all guests in a proofing album
+func (s *mserver) handleAlbumProofing(r *http.Request) (interface{}, error) {
+	return s.proofSummary(r)
+}
+
+// handleExportProofing returns the selected photos of a proofing album with file names, source
+// ids and notes. Only submitted selections are included unless the query parameter all is true
+func (s *mserver) handleExportProofing(r *http.Request) (interface{}, error) {
+	summary, err := s.proofSummary(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Query().Get("all") == "true" {
+		return summary.Photos, nil
+	}
+	submitted := []*GuestProof{}
+	for _, gp := range summary.Guests {
+		if gp.Submitted != nil {
+			submitted = append(submitted, gp)
+		}
+	}
+	return proofPhotos(summary.photos, submitted), nil
+}
+
+// handleUnlockProofing unlocks a guest's submitted selection so it can be changed
+func (s *mserver) handleUnlockProofing(r *http.Request) (interface{}, error) {
+	var albumId, guestId uuid.UUID
+	if err := uid(r, "albumid", &albumId); err != nil {
+		return nil, err
+	}
+	if err := uid(r, "guestid", &guestId); err != nil {
+		return nil, err
+	}
+	if err := s.pg.Proof.Unlock(albumId, guestId); err != nil {
+		return nil, err
+	}
+	return s.proofSummary(r)
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"testing"
+	"time"
+)
+
+func TestProofPhotos(t *testing.T) {
+	photos := []*dao.Photo{{Id: uuid.New(), FileName: "a.jpg"}, {Id: uuid.New(), FileName: "b.jpg"}}
+	submitted := time.Now()
+	anna := &GuestProof{Id: uuid.New(), Name: "anna", Submitted: &submitted, Selections: []*dao.ProofSelection{
+		{PhotoId: photos[1].Id, Selected: true, Note: "crop"}}}
+	bertil := &GuestProof{Id: uuid.New(), Name: "bertil", Selections: []*dao.ProofSelection{
+		{PhotoId: photos[0].Id, Selected: true}, {PhotoId: photos[1].Id, Selected: true, Note: "draft"}}}
+
+	all := proofPhotos(photos, []*GuestProof{anna, bertil})
+	if len(all) != 2 || all[0].FileName != "a.jpg" || len(all[1].Guests) != 2 || len(all[1].Notes) != 2 {
+		t.Errorf("expected both photos in album order with all guests got %v", all)
+	}
+	exported := proofPhotos(photos, []*GuestProof{anna})
+	if len(exported) != 1 || exported[0].FileName != "b.jpg" {
+		t.Fatalf("expected only the photo selected by anna got %v", exported)
+	}
+	if g, n := exported[0].Guests, exported[0].Notes; len(g) != 1 || g[0] != "anna" || len(n) != 1 || n[0] != "anna: crop" {
+		t.Errorf("expected only the selection of anna got %v %v", g, n)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"net/http"
+)
+
+// Guests can add one reaction of each configured kind to a photo. The like routes are kept
+// for clients that only know about likes
+
+const likeKind = "like"
+
+// PhotoReactions are the reactions to a photo. Guest emails are only included for the owner
+type PhotoReactions struct {
+	Counts map[string]int       `json:"counts"`
+	Guests []*dao.GuestReaction `json:"guests"`
+}
+
+func isReactionKind(kind string) bool {
+	for _, k := range config.ReactionKinds() {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// withReactions sets the reaction counts of photos
+func (s *mserver) withReactions(photos ...*dao.Photo) error {
+	ids := make([]uuid.UUID, len(photos))
+	for i, p := range photos {
+		ids[i] = p.Id
+	}
+	counts, err := s.pg.Reaction.Counts(ids...)
+	if err != nil {
+		return err
+	}
+	for _, p := range photos {
+		p.Reactions = counts[p.Id]
+	}
+	return nil
+}
+
+// react adds or removes the reaction of kind by guestId to the photo in the request. Reactions
+// of kinds that are no longer configured can still be removed
+func (s *mserver) react(r *http.Request, guestId uuid.UUID, kind string, add bool) (*dao.Reaction, error) {
+	var photoId uuid.UUID
+	if err := uid(r, "photoid", &photoId); err != nil {
+		return nil, err
+	}
+	if add && !isReactionKind(kind) {
+		return nil, BadRequestError("Unknown reaction: " + kind)
+	}
+	if !s.pg.Photo.Has(photoId) {
+		return nil, NotFoundError("img not found")
+	}
+	reaction := dao.Reaction{GuestId: guestId, PhotoId: photoId, Kind: kind}
+	if add {
+		if s.pg.Reaction.Has(&reaction) {
+			return &reaction, nil
+		}
+		if err := s.pg.Reaction.Add(&reaction); err != nil {
+			return nil, err
+		}
+		s.publishReaction(events.ReactionAdded, &reaction)
+	} else {
+		if err := s.pg.Reaction.Delete(&reaction); err != nil {
+			return nil, err
+		}
+		s.publishReaction(events.ReactionRemoved, &reaction)
+	}
+	return &reaction, nil
+}
+
+func (s *mserver) handleReactionKinds(_ http.ResponseWriter, _ *http.Request) (interface{}, error) {
+	return config.ReactionKinds(), nil
+}
+
+func (s *mserver) handleAddReaction(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.react(r, guestId, Var(r, "kind"), true)
+}
+
+func (s *mserver) handleRemoveReaction(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.react(r, guestId, Var(r, "kind"), false)
+}
+
+func (s *mserver) handlePhotoReactions(r *http.Request, loggedIn bool) (interface{}, error) {
+	var photoId uuid.UUID
+	if err := uid(r, "photoid", &photoId); err != nil {
+		return nil, err
+	}
+	guests, err := s.pg.Reaction.ListByPhoto(photoId)
+	if err != nil {
+		return nil, err
+	}
+	ret := PhotoReactions{Counts: map[string]int{}, Guests: guests}
+	for _, g := range guests {
+		ret.Counts[g.Kind]++
+		if !loggedIn {
+			g.Email = ""
+		}
+	}
+	return &ret, nil
+}
+
+// handleGuestReactions returns all reactions by the guest
+func (s *mserver) handleGuestReactions(_ *http.Request, guestId uuid.UUID) (interface{}, error) {
+	return s.pg.Reaction.ListByGuest(guestId)
+}
+
+// handleGuestPhotoReactions returns the kinds of reactions the guest has added to a photo
+func (s *mserver) handleGuestPhotoReactions(r *http.Request, guestId uuid.UUID) (interface{}, error) {
+	var photoId uuid.UUID
+	if err := uid(r, "photoid", &photoId); err != nil {
+		return nil, err
+	}
+	reactions, err := s.pg.Reaction.ListByGuest(guestId)
+	if err != nil {
+		return nil, err
+	}
+	kinds := []string{}
+	for _, re := range reactions {
+		if re.PhotoId == photoId {
+			kinds = append(kinds, re.Kind)
+		}
+	}
+	return kinds, nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"github.com/msvens/mphotos/internal/gdrive"
+	"github.com/msvens/mphotos/internal/ingest"
+	"go.uber.org/zap"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Photos imported from Drive are reconciled with their Drive files. Photos whose file has
+// been removed or replaced are handled according to config.DriveOnRemoved and
+// config.DriveOnModified
+
+const trashDir = "trash"
+
+// Outcomes of reconciling a photo, reported as ingest.Result status
+const (
+	ReconcileKept       = "kept"
+	ReconcileTrashed    = "trashed"
+	ReconcileReimported = "reimported"
+)
+
+func reconcileResult(p *dao.Photo, status string, err error) *ingest.Result {
+	res := &ingest.Result{Name: p.SourceId, Status: status, PhotoId: p.Id}
+	if err != nil {
+		res.Status, res.Error = ingest.StatusFailed, err.Error()
+	}
+	return res
+}
+
+// driveRemoved applies the removed policy to a photo whose Drive file no longer exists
+func driveRemoved(s *mserver, p *dao.Photo) *ingest.Result {
+	switch policy := config.DriveOnRemoved(); policy {
+	case config.DrivePolicyTrash:
+		s.l.Infow("drive file has been removed, trashing photo", "id", p.Id, "driveId", p.SourceId)
+		return reconcileResult(p, ReconcileTrashed, trashPhoto(s, p))
+	default:
+		s.l.Infow("drive file has been removed, keeping photo", "id", p.Id, "driveId", p.SourceId, "policy", policy)
+		return reconcileResult(p, ReconcileKept, nil)
+	}
+}
+
+// driveModified applies the modified policy to a photo whose Drive file has been replaced
+func driveModified(s *mserver, p *dao.Photo, f *drive.File) *ingest.Result {
+	switch policy := config.DriveOnModified(); policy {
+	case config.DrivePolicyReimport:
+		s.l.Infow("drive file has been modified, reimporting photo", "id", p.Id, "driveId", p.SourceId)
+		replaced, err := s.ingest.Replace(p, ingest.NewDriveItem(s.ds, f, p.SourcePath))
+		if err != nil {
+			s.l.Errorw("could not reimport photo", "id", p.Id, zap.Error(err))
+		} else {
+			s.publishPhoto(events.PhotoUpdated, replaced)
+		}
+		return reconcileResult(p, ReconcileReimported, err)
+	default:
+		s.l.Infow("drive file has been modified, keeping photo", "id", p.Id, "driveId", p.SourceId, "policy", policy)
+		return reconcileResult(p, ReconcileKept, nil)
+	}
+}
+
+// trashPhoto removes p from the library. The original is moved to the trash folder so
+// that it can be recovered
+func trashPhoto(s *mserver, p *dao.Photo) error {
+	dir := config.ServicePath(trashDir)
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return err
+	}
+	src := config.PhotoFilePath(config.Original, p.FileName)
+	if err := os.Rename(src, filepath.Join(dir, p.FileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := deletePhoto(s, p, true)
+	return err
+}
+
+// reconcilePhoto compares p with its Drive file. Returns nil if the photo is up to date
+func reconcilePhoto(s *mserver, t *driveTree, p *dao.Photo) *ingest.Result {
+	f, err := s.ds.GetFields(p.SourceId, fileFields)
+	var gerr *googleapi.Error
+	switch {
+	case errors.As(err, &gerr) && gerr.Code == gdrive.ErrorFileNotFound:
+		syncFolderAlbums(s, t, p, nil)
+		return driveRemoved(s, p)
+	case err != nil:
+		return reconcileResult(p, ingest.StatusFailed, err)
+	case f.Trashed:
+		syncFolderAlbums(s, t, p, nil)
+		return driveRemoved(s, p)
+	case f.Md5Checksum != p.Md5:
+		return driveModified(s, p, f)
+	}
+	return nil
+}
+
+// async
+func (s *mserver) handleScheduleDriveReconcile(_ *http.Request) (interface{}, error) {
+	if s.ds == nil {
+		return nil, UnauthorizedError("No Drive Service Connected")
+	}
+	job, err := newJob(s, JobDriveReconcile, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.jobs.schedule(job)
+}
+
+func driveReconcileTask(job *Job) error {
+	if job.s.ds == nil {
+		return UnauthorizedError("No Drive Service Connected")
+	}
+	photos, err := job.s.pg.Photo.ListSource(dao.SourceGoogle)
+	if err != nil {
+		return err
+	}
+	tree, err := loadDriveTree(job.s)
+	if err != nil {
+		return err
+	}
+	job.setNumFiles(len(photos))
+	for _, p := range photos {
+		if err := job.cancelled(); err != nil {
+			return err
+		}
+		if res := reconcilePhoto(job.s, tree, p); res != nil {
+			job.addResult(res)
+		}
+		job.progress()
+	}
+	return nil
+}
@@ -14,6 +14,9 @@ func (s *mserver) routes() {
 	s.mPUT("/albums/{albumid}").HandlerFunc(s.authOnly(s.handleUpdateAlbum))
 	s.mPUT("/albums/{albumid}/order").HandlerFunc(s.authOnly(s.handleUpdateOrder))
 	s.mGET("/albums/{albumid}/photos").HandlerFunc(s.mResponse(s.handleAlbumPhotos))
+	s.mGET("/albums/{albumid}/proofing").HandlerFunc(s.authOnly(s.handleAlbumProofing))
+	s.mGET("/albums/{albumid}/proofing/export").HandlerFunc(s.authOnly(s.handleExportProofing))
+	s.mPUT("/albums/{albumid}/proofing/{guestid}/unlock").HandlerFunc(s.authOnly(s.handleUnlockProofing))
 	s.mPUT("/albums/{albumid}/photos/add").HandlerFunc(s.authOnly(s.handleAddAlbumPhotos))
 	s.mPUT("/albums/{albumid}/photos/clear").HandlerFunc(s.authOnly(s.handleClearAlbumPhotos))
 	s.mPUT("/albums/{albumid}/photos/delete").HandlerFunc(s.authOnly(s.handleDeleteAlbumPhotos))
@@ -38,10 +41,26 @@ func (s *mserver) routes() {
 	s.mGET("/drive/check").HandlerFunc(s.authOnly(s.handleCheckDrive))
 	s.mPUT("/drive/upload").HandlerFunc(s.authOnly(s.handleAddDrivePhotos))
 	s.mPUT("/drive/job/schedule").HandlerFunc(s.authOnly(s.handleScheduleDriveJob))
-	s.mGET("/drive/job/{jobid}").HandlerFunc(s.authOnly(s.handleStatusDriveJob))
+	s.mGET("/drive/job/{jobid}").HandlerFunc(s.authOnly(s.handleStatusJob))
+	s.mGET("/drive/schedule").HandlerFunc(s.authOnly(s.handleSyncStatus))
+	s.mPUT("/drive/reconcile").HandlerFunc(s.authOnly(s.handleScheduleDriveReconcile))
+	s.mGET("/drive/sources").HandlerFunc(s.authOnly(s.handleDriveSources))
+	s.mPUT("/drive/sources").HandlerFunc(s.authOnly(s.handleAddDriveSource))
+	s.mPUT("/drive/sources/{sourceid}").HandlerFunc(s.authOnly(s.handleUpdateDriveSource))
+	s.mDELETE("/drive/sources/{sourceid}").HandlerFunc(s.authOnly(s.handleDeleteDriveSource))
 
 	s.mPUT("/local/upload").HandlerFunc(s.authOnly(s.handleUploadLocalPhoto))
+	s.mPUT("/local/import").HandlerFunc(s.authOnly(s.handleImportArchive))
+	s.mGET("/local/import/{jobid}").HandlerFunc(s.authOnly(s.handleStatusJob))
 	s.mPUT("/local/check").HandlerFunc(s.authOnly(s.handleCheckLocalPhotos))
+	s.path("/local/uploads").Methods("OPTIONS").HandlerFunc(s.tusHandler(s.handleUploadsOptions))
+	s.path("/local/uploads").Methods("POST").HandlerFunc(s.tusHandler(s.handleCreateUpload))
+	s.path("/local/uploads/{uploadid}").Methods("HEAD").HandlerFunc(s.tusHandler(s.handleHeadUpload))
+	s.path("/local/uploads/{uploadid}").Methods("PATCH").HandlerFunc(s.tusHandler(s.handlePatchUpload))
+	s.mDELETE("/local/uploads/{uploadid}").HandlerFunc(s.tusHandler(s.handleDeleteUpload))
+	s.mGET("/local/uploads/{uploadid}").HandlerFunc(s.authOnly(s.handleUploadStatus))
+
+	s.mGET("/events").HandlerFunc(s.handleEvents)
 
 	s.mGET("/images/{name}").HandlerFunc(s.handleImage)
 	s.mGET("/thumbs/{name}").HandlerFunc(s.handleThumb)
@@ -50,6 +69,18 @@ func (s *mserver) routes() {
 	s.mGET("/landscapes/{name}").HandlerFunc(s.handleLandscape)
 	s.mGET("/resizes/{name}").HandlerFunc(s.handleResize)
 
+	s.mGET("/jobs").HandlerFunc(s.authOnly(s.handleJobs))
+	s.mPUT("/jobs").HandlerFunc(s.authOnly(s.handleScheduleJob))
+	s.mGET("/jobs/{jobid}").HandlerFunc(s.authOnly(s.handleStatusJob))
+	s.mPUT("/jobs/{jobid}/cancel").HandlerFunc(s.authOnly(s.handleCancelJob))
+	s.mPUT("/jobs/{jobid}/retry").HandlerFunc(s.authOnly(s.handleRetryJob))
+
+	s.mGET("/webhooks").HandlerFunc(s.authOnly(s.handleWebhooks))
+	s.mPUT("/webhooks").HandlerFunc(s.authOnly(s.handleAddWebhook))
+	s.mPUT("/webhooks/{hookid}").HandlerFunc(s.authOnly(s.handleUpdateWebhook))
+	s.mDELETE("/webhooks/{hookid}").HandlerFunc(s.authOnly(s.handleDeleteWebhook))
+	s.mGET("/webhooks/{hookid}/deliveries").HandlerFunc(s.authOnly(s.handleWebhookDeliveries))
+
 	s.mPUT("/login").HandlerFunc(s.mResponse(s.handleLogin))
 	s.mGET("/logout").HandlerFunc(s.mResponse(s.handleLogout))
 	s.mGET("/loggedin").HandlerFunc(s.loginInfo(s.handleLoggedIn))
@@ -66,6 +97,7 @@ func (s *mserver) routes() {
 	s.mGET("/photos/{photoid}/exif").HandlerFunc(s.mResponse(s.handleExif))
 	s.mGET("/photos/{photoid}/edit/preview").HandlerFunc(s.handleEditPreviewImage)
 	s.mPUT("/photos/{photoid}/edit").HandlerFunc(s.authOnly(s.handleEditImage))
+	s.mPUT("/photos/{photoid}/favorite").HandlerFunc(s.authOnly(s.handleUpdatePhotoFavorite))
 	//s.path("/photos/latest").Methods("GET").HandlerFunc(s.loginInfo(s.handleLatestPhoto))
 	s.mGET("/photos/{photoid}").HandlerFunc(s.mResponse(s.handlePhoto))
 	s.mPUT("/photos/{photoid}").HandlerFunc(s.authOnly(s.handleUpdatePhoto))
@@ -74,23 +106,66 @@ func (s *mserver) routes() {
 
 	s.mPUT("/comments/{img}").HandlerFunc(s.guestOnly(s.handleCommentPhoto))
 	s.mGET("/comments/{img}").HandlerFunc(s.loginInfo(s.handlePhotoComments))
+	s.mGET("/collections").HandlerFunc(s.authOnly(s.handleCollections))
+	s.mGET("/collections/{collectionid}").HandlerFunc(s.authOnly(s.handleCollection))
+	s.mGET("/collections/{collectionid}/files").HandlerFunc(s.authOnly(s.handleCollectionFiles))
+	s.mPUT("/collections/{collectionid}/album").HandlerFunc(s.authOnly(s.handleCollectionAlbum))
+	s.mGET("/comments").HandlerFunc(s.authOnly(s.handleComments))
+	s.mPUT("/comments/{commentid}/approve").HandlerFunc(s.authOnly(s.handleApproveComment))
+	s.mPUT("/comments/{commentid}/reject").HandlerFunc(s.authOnly(s.handleRejectComment))
+	s.mPUT("/comments/{commentid}/spam").HandlerFunc(s.authOnly(s.handleSpamComment))
+	s.mPUT("/comments/{commentid}/edit").HandlerFunc(s.authOnly(s.handleEditComment))
+	s.mPUT("/comments/{commentid}/reply").HandlerFunc(s.authOnly(s.handleReplyComment))
+	s.mDELETE("/comments/{commentid}").HandlerFunc(s.authOnly(s.handleDeleteComment))
 	s.mPUT("/guest").HandlerFunc(s.mResponse(s.handleCreateGuest))
 	s.mGET("/guest").HandlerFunc(s.guestOnly(s.handleGuest))
+	s.mDELETE("/guest").HandlerFunc(s.mResponse(s.handleDeleteGuest))
+	s.mGET("/guest/export").HandlerFunc(s.guestOnly(s.handleExportGuest))
 	s.mPUT("/guest/update").HandlerFunc(s.guestOnly(s.handleUpdateGuest))
 	s.mGET("/guest/logout").HandlerFunc(s.mResponse(s.handleLogoutGuest))
 	s.mGET("/guest/is").HandlerFunc(s.mResponse(s.handleIsGuest))
 	s.mGET("/guest/likes").HandlerFunc(s.guestOnly(s.handleGuestLikes))
+	s.mGET("/guest/collections").HandlerFunc(s.guestOnly(s.handleGuestCollections))
+	s.mPUT("/guest/collections").HandlerFunc(s.guestOnly(s.handleAddCollection))
+	s.mGET("/guest/collections/{collectionid}").HandlerFunc(s.guestOnly(s.handleGuestCollection))
+	s.mPUT("/guest/collections/{collectionid}").HandlerFunc(s.guestOnly(s.handleUpdateCollection))
+	s.mDELETE("/guest/collections/{collectionid}").HandlerFunc(s.guestOnly(s.handleDeleteCollection))
+	s.mPUT("/guest/collections/{collectionid}/photos/add").HandlerFunc(s.guestOnly(s.handleAddCollectionPhotos))
+	s.mPUT("/guest/collections/{collectionid}/photos/delete").HandlerFunc(s.guestOnly(s.handleDeleteCollectionPhotos))
+	s.mGET("/guest/comments").HandlerFunc(s.guestOnly(s.handleGuestComments))
+	s.mPUT("/guest/comments/{commentid}").HandlerFunc(s.guestOnly(s.handleGuestEditComment))
+	s.mDELETE("/guest/comments/{commentid}").HandlerFunc(s.guestOnly(s.handleGuestDeleteComment))
 	s.mGET("/guest/likes/{photoid}").HandlerFunc(s.guestOnly(s.handleGuestLikePhoto))
+	s.mGET("/guest/reactions").HandlerFunc(s.guestOnly(s.handleGuestReactions))
+	s.mGET("/guest/reactions/{photoid}").HandlerFunc(s.guestOnly(s.handleGuestPhotoReactions))
 	s.mGET("/guest/verify").HandlerFunc(s.mResponse(s.handleVerifyGuest))
+	s.mGET("/guests").HandlerFunc(s.authOnly(s.handleGuests))
+	s.mGET("/guests/{guestid}").HandlerFunc(s.authOnly(s.handleOwnerGuest))
+	s.mDELETE("/guests/{guestid}").HandlerFunc(s.authOnly(s.handleOwnerDeleteGuest))
+	s.mPUT("/guests/{guestid}/ban").HandlerFunc(s.authOnly(s.handleBanGuest))
+	s.mPUT("/guests/{guestid}/unban").HandlerFunc(s.authOnly(s.handleUnbanGuest))
+	s.mPUT("/guests/{guestid}/verify").HandlerFunc(s.authOnly(s.handleResendVerification))
 	s.mPUT("/likes/{photoid}/like").HandlerFunc(s.guestOnly(s.handleLikePhoto))
 	s.mPUT("/likes/{photoid}/unlike").HandlerFunc(s.guestOnly(s.handleUnlikePhoto))
 	s.mGET("/likes/{photoid}").HandlerFunc(s.loginInfo(s.handlePhotoLikes))
+
+	s.mGET("/proofing/{albumid}").HandlerFunc(s.guestOnly(s.handleProofing))
+	s.mPUT("/proofing/{albumid}/photos/{photoid}").HandlerFunc(s.guestOnly(s.handleProofPhoto))
+	s.mPUT("/proofing/{albumid}/submit").HandlerFunc(s.guestOnly(s.handleSubmitProof))
+	s.mGET("/reactions").HandlerFunc(s.mResponse(s.handleReactionKinds))
+	s.mGET("/reactions/{photoid}").HandlerFunc(s.loginInfo(s.handlePhotoReactions))
+	s.mPUT("/reactions/{photoid}/{kind}").HandlerFunc(s.guestOnly(s.handleAddReaction))
+	s.mDELETE("/reactions/{photoid}/{kind}").HandlerFunc(s.guestOnly(s.handleRemoveReaction))
 	s.mGET("/user").HandlerFunc(s.loginInfo(s.handleUser))
 	s.mPUT("/user").HandlerFunc(s.authOnly(s.handleUpdateUser))
 	s.mPUT("/user/pic").HandlerFunc(s.authOnly(s.handleUpdatePicUser))
 	s.mPUT("/user/gdrive").HandlerFunc(s.authOnly(s.handleUpdateDriveUser))
-	s.mGET("/user/config").HandlerFunc(s.mResponse(s.handleUserConfig))
+	s.mGET("/user/config").HandlerFunc(s.loginInfo(s.handleUserConfig))
 	s.mPUT("/user/config").HandlerFunc(s.authOnly(s.handleUpdateConfig))
+	s.mGET("/user/notifications").HandlerFunc(s.authOnly(s.handleNotificationPrefs))
+	s.mPUT("/user/notifications").HandlerFunc(s.authOnly(s.handleUpdateNotificationPrefs))
+	s.mGET("/user/moderation").HandlerFunc(s.authOnly(s.handleModerationPolicy))
+	s.mPUT("/user/moderation").HandlerFunc(s.authOnly(s.handleUpdateModerationPolicy))
 }
 
 func (s *mserver) mGET(p string) *mux.Route {
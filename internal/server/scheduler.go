@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/ingest"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// StateSkipped is the state of a scheduled sync that did not run
+const StateSkipped = "SKIPPED"
+
+const minSyncInterval = time.Minute
+
+// syncScheduler schedules a Drive import job on a schedule and records the outcome as a sync
+// run. A run is skipped (and recorded as such) if another Drive sync is scheduled or running
+// or no Drive service is connected
+type syncScheduler struct {
+	s     *mserver
+	spec  string
+	cron  *cron.Cron
+	entry cron.EntryID
+}
+
+type SyncStatus struct {
+	Schedule string         `json:"schedule"`
+	Running  bool           `json:"running"`
+	LastRun  *dao.SyncRun   `json:"lastRun,omitempty"`
+	NextRun  time.Time      `json:"nextRun"`
+	Runs     []*dao.SyncRun `json:"runs"`
+}
+
+// parseSchedule accepts either an interval, e.g. 30m, or a standard 5 field cron expression
+func parseSchedule(spec string) (cron.Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < minSyncInterval {
+			return nil, fmt.Errorf("sync interval %v is shorter than %v", d, minSyncInterval)
+		}
+		return cron.Every(d), nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+func newSyncScheduler(s *mserver, spec string) (*syncScheduler, error) {
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	sc := &syncScheduler{s: s, spec: spec, cron: cron.New()}
+	sc.entry = sc.cron.Schedule(schedule, cron.FuncJob(sc.run))
+	sc.cron.Start()
+	return sc, nil
+}
+
+// Close stops the scheduler and waits for a running sync to finish
+func (sc *syncScheduler) Close() {
+	<-sc.cron.Stop().Done()
+}
+
+// run schedules a Drive import job and waits for it to finish
+func (sc *syncScheduler) run() {
+	run := dao.SyncRun{Started: time.Now(), State: StateStarted}
+	job, err := sc.s.scheduleDriveImport()
+	if err != nil {
+		sc.finish(&run, StateSkipped, err)
+		return
+	}
+	if err = sc.s.pg.SyncRun.Add(&run); err != nil {
+		sc.s.l.Errorw("could not record sync run", zap.Error(err))
+	}
+	sc.s.jobs.wait(job)
+	st := job.Status()
+	for _, res := range st.Results {
+		if res.Status == ingest.StatusAdded {
+			run.Added++
+		}
+	}
+	switch {
+	case st.State == StateFinished:
+		sc.finish(&run, StateFinished, nil)
+	case st.Err != nil:
+		sc.finish(&run, StateAborted, st.Err)
+	default:
+		sc.finish(&run, StateAborted, fmt.Errorf("drive import job %s was not finished", st.Id))
+	}
+}
+
+// finish records the outcome of run
+func (sc *syncScheduler) finish(run *dao.SyncRun, state string, err error) {
+	run.Finished, run.State = time.Now(), state
+	if err != nil {
+		run.Error = err.Error()
+		sc.s.l.Infow("scheduled drive sync", "state", state, zap.Error(err))
+	} else {
+		sc.s.l.Infow("scheduled drive sync", "state", state, "added", run.Added)
+	}
+	var e error
+	if run.Id == uuid.Nil {
+		e = sc.s.pg.SyncRun.Add(run)
+	} else {
+		e = sc.s.pg.SyncRun.Update(run)
+	}
+	if e != nil {
+		sc.s.l.Errorw("could not record sync run", zap.Error(e))
+	}
+}
+
+func (s *mserver) handleSyncStatus(_ *http.Request) (interface{}, error) {
+	if s.scheduler == nil {
+		return nil, NotFoundError("Automatic sync is not enabled")
+	}
+	runs, err := s.pg.SyncRun.List(10)
+	if err != nil {
+		return nil, err
+	}
+	status := SyncStatus{Schedule: s.scheduler.spec, Running: s.driveSyncRunning(), Runs: runs,
+		NextRun: s.scheduler.cron.Entry(s.scheduler.entry).Next}
+	if len(runs) > 0 {
+		status.LastRun = runs[0]
+	}
+	return &status, nil
+}
@@ -7,15 +7,21 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/msvens/mphotos/internal/config"
 	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
 	"github.com/msvens/mphotos/internal/gdrive"
 	"github.com/msvens/mphotos/internal/gmail"
+	"github.com/msvens/mphotos/internal/ingest"
+	"github.com/msvens/mphotos/internal/mail"
+	"github.com/msvens/mphotos/internal/webhook"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -25,6 +31,7 @@ type mserver struct {
 	pg          *dao.PGDB
 	ds          *gdrive.DriveService
 	ms          *gmail.GmailService
+	mailer      mail.Mailer
 	r           *mux.Router
 	l           *zap.SugaredLogger
 	prefixPath  string
@@ -33,6 +40,16 @@ type mserver struct {
 	guestCookie string
 	tokenFile   string
 	gconfig     *oauth2.Config
+	inbox       *inbox
+	ingest      *ingest.Pipeline
+	scheduler   *syncScheduler
+	jobs        *jobQueue
+	driveSync   sync.Mutex
+	events      *events.Broker
+	webhooks    *webhook.Dispatcher
+	notifier    *notifier
+	commentRate *rateLimiter
+	proxies     []*net.IPNet
 	/*imgDir       string
 	cameraDir    string
 	thumbDir     string
@@ -82,9 +99,39 @@ func newServer(prefixPath string, logger *zap.SugaredLogger) *mserver {
 		s.l.Panicw("could not create camera dir", zap.Error(err))
 	}
 
-	//start async job channel:
-	wg.Add(1)
-	go worker(jobChan)
+	if s.mailer, err = newMailer(&s); err != nil {
+		s.l.Panicw("could not create mailer", zap.Error(err))
+	}
+	s.events = events.NewBroker()
+	s.ingest = ingest.New(s.pg, func(photo *dao.Photo) error {
+		s.publishPhoto(events.PhotoAdded, photo)
+		return nil
+	})
+	s.webhooks = webhook.NewDispatcher(s.pg.Webhook, s.l)
+	if err = s.webhooks.Start(s.events); err != nil {
+		s.l.Errorw("could not resume webhook deliveries", zap.Error(err))
+	}
+	s.notifier = newNotifier(&s)
+	s.commentRate = newRateLimiter(time.Hour)
+	if s.proxies, err = parseTrustedProxies(config.ServerTrustedProxies()); err != nil {
+		s.l.Errorw("could not parse trusted proxies", zap.Error(err))
+	}
+	s.pruneUploads(time.Now())
+
+	if dir := config.InboxDir(); dir != "" {
+		if s.inbox, err = newInbox(&s, dir, config.InboxAlbum()); err != nil {
+			s.l.Errorw("could not start inbox watcher", "dir", dir, zap.Error(err))
+		}
+	}
+
+	//async job workers are started once google auth has been set up
+	s.jobs = newJobQueue(&s)
+
+	if spec := config.DriveSchedule(); spec != "" {
+		if s.scheduler, err = newSyncScheduler(&s, spec); err != nil {
+			s.l.Errorw("could not start drive sync scheduler", "schedule", spec, zap.Error(err))
+		}
+	}
 
 	//init google auth:
 	s.tokenFile = config.ServicePath("token.json")
@@ -126,6 +173,9 @@ func StartMServer() {
         s.l.Infow("auth from file", zap.Error(err))
 	}
 
+	//start async job workers:
+	s.jobs.Start(numJobWorkers)
+
 	srv := &http.Server{
 		Addr:    config.ServerAddr(),
 		Handler: s.r,
@@ -152,12 +202,26 @@ func StartMServer() {
 		cancel()
 	}()
 
-	close(jobChan)
-	wg.Wait()
+	if s.inbox != nil {
+		_ = s.inbox.Close()
+	}
+
+	//interrupt running jobs before stopping the scheduler so that it does not wait for them
+	s.jobs.Close()
+
+	if s.scheduler != nil {
+		s.scheduler.Close()
+	}
 	//if s.ps != nil {
 	//	s.ps.Shutdown()
 	//}
 
+	s.webhooks.Close()
+	s.notifier.Close()
+
+	//close event streams so that they do not block the shutdown
+	s.events.Close()
+
 	if err := srv.Shutdown(ctx); err != nil {
 		s.l.Fatalw("server shutdown failed", zap.Error(err))
 	}
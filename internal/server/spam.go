@@ -0,0 +1,200 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// New guest comments are checked for spam before they are stored. Comments that are too long or
+// posted too often are refused. Comments that look like spam are stored as held, with the reason
+// in the comment flag, and only become visible if the owner approves them
+
+// SpamPolicy is part of the ModerationPolicy. A limit of 0 turns that check off. Blocklist
+// words are matched case insensitively anywhere in the comment
+type SpamPolicy struct {
+	MaxLength    int      `json:"maxLength"`
+	GuestPerHour int      `json:"guestPerHour"`
+	IpPerHour    int      `json:"ipPerHour"`
+	MaxLinks     int      `json:"maxLinks"`
+	Blocklist    []string `json:"blocklist"`
+}
+
+var defaultSpamPolicy = SpamPolicy{MaxLength: 2000, GuestPerHour: 10, IpPerHour: 30, MaxLinks: 2}
+
+// Reasons a comment was held
+const (
+	flagHoneypot  = "honeypot"
+	flagLinks     = "links"
+	flagBlocklist = "blocklist"
+	flagDuplicate = "duplicate"
+)
+
+var linkRegexp = regexp.MustCompile(`(?i)(https?://|www\.)`)
+
+// maxRateKeys is the number of keys a rateLimiter tracks before it forgets idle keys
+const maxRateKeys = 10000
+
+// rateLimiter counts events per key within a sliding window
+type rateLimiter struct {
+	window time.Duration
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newRateLimiter(window time.Duration) *rateLimiter {
+	return &rateLimiter{window: window, events: map[string][]time.Time{}}
+}
+
+// allow records an event for key and returns true if there are at most limit events for key
+// within the window. A limit of 0 allows everything
+func (rl *rateLimiter) allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if len(rl.events) > maxRateKeys {
+		for k, times := range rl.events {
+			if now.Sub(times[len(times)-1]) >= rl.window {
+				delete(rl.events, k)
+			}
+		}
+	}
+	recent := rl.events[key][:0]
+	for _, t := range rl.events[key] {
+		if now.Sub(t) < rl.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		rl.events[key] = recent
+		return false
+	}
+	rl.events[key] = append(recent, now)
+	return true
+}
+
+// parseTrustedProxies parses addresses and CIDR ranges of trusted proxies
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %s", p)
+			}
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only used if the request comes
+// from a trusted proxy. The client is then the last address in the header that is not a trusted
+// proxy, earlier entries are set by the client and cannot be trusted
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if !isTrustedProxy(addr, trusted) {
+		return addr
+	}
+	fwd := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(fwd) - 1; i >= 0; i-- {
+		if a := strings.TrimSpace(fwd[i]); a != "" {
+			addr = a
+			if !isTrustedProxy(a, trusted) {
+				break
+			}
+		}
+	}
+	return addr
+}
+
+// checkCommentLimits returns an error if body is too long or the guest or client has posted too
+// many comments in the last hour
+func (s *mserver) checkCommentLimits(r *http.Request, guestId uuid.UUID, body string, policy *SpamPolicy) error {
+	if policy.MaxLength > 0 && len([]rune(body)) > policy.MaxLength {
+		return BadRequestError("Comment is too long")
+	}
+	if !s.commentRate.allow("guest:"+guestId.String(), policy.GuestPerHour) ||
+		!s.commentRate.allow("ip:"+clientIP(r, s.proxies), policy.IpPerHour) {
+		return newError(http.StatusTooManyRequests, "Too many comments, please try again later")
+	}
+	return nil
+}
+
+// spamFlag returns why body by guestId looks like spam, or an empty string if it does not.
+// commentId is the comment being edited, 0 for new comments
+func (s *mserver) spamFlag(guestId uuid.UUID, commentId int, body string, policy *SpamPolicy) string {
+	if policy.MaxLinks > 0 && len(linkRegexp.FindAllStringIndex(body, -1)) > policy.MaxLinks {
+		return flagLinks
+	}
+	lower := strings.ToLower(body)
+	for _, word := range policy.Blocklist {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" && strings.Contains(lower, word) {
+			return flagBlocklist
+		}
+	}
+	if comments, err := s.pg.Comment.ListByGuest(guestId); err == nil {
+		normalized := strings.Join(strings.Fields(lower), " ")
+		for _, c := range comments {
+			if c.Id != commentId && strings.Join(strings.Fields(strings.ToLower(c.Body)), " ") == normalized {
+				return flagDuplicate
+			}
+		}
+	}
+	return ""
+}
+
+// screenComment checks a new comment, or an edit of commentId, by guestId. It returns an error if
+// the comment should be refused and otherwise the state and flag it should be stored with.
+// honeypot is a form field that is hidden from people, so anything in it was filled in by a bot
+func (s *mserver) screenComment(r *http.Request, guestId uuid.UUID, commentId int, body, honeypot string) (string, string, error) {
+	policy, err := s.moderationPolicy()
+	if err != nil {
+		return "", "", err
+	}
+	if err = s.checkCommentLimits(r, guestId, body, &policy.Spam); err != nil {
+		return "", "", err
+	}
+	if honeypot != "" {
+		return dao.CommentSpam, flagHoneypot, nil
+	}
+	if flag := s.spamFlag(guestId, commentId, body, &policy.Spam); flag != "" {
+		return dao.CommentHeld, flag, nil
+	}
+	return s.newCommentState(guestId), "", nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTrustedProxies([]string{"localhost"}); err == nil {
+		t.Errorf("expected an invalid proxy address to fail")
+	}
+	tests := []struct {
+		remote, forwarded, expected string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+		{"127.0.0.1:1234", "", "127.0.0.1"},
+		{"127.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"127.0.0.1:1234", "203.0.113.9, 198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"10.1.2.3:1234", "10.0.0.1", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/comments", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := clientIP(r, proxies); ip != test.expected {
+			t.Errorf("expected %s for %s (%s) got %s", test.expected, test.remote, test.forwarded, ip)
+		}
+	}
+}
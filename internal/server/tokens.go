@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// Guests log in with single use tokens sent by email. A verify token is sent when a guest is
+// created, a login token when an existing guest asks for access and an email token to the new
+// address when a guest changes email. Only the hash of a token is stored so a leaked database
+// cannot be used to log in
+const (
+	verifyTokenTTL = 24 * time.Hour
+	loginTokenTTL  = 15 * time.Minute
+	tokenResendGap = time.Minute
+	tokenBytes     = 32
+)
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// newGuestToken creates and stores a token for guestId and returns the raw token that should
+// be sent to the guest. Email is only used by email change tokens. Returns a 429 error if a token
+// was issued less than tokenResendGap ago
+func (s *mserver) newGuestToken(guestId uuid.UUID, purpose, email string) (string, error) {
+	if latest, err := s.pg.GuestToken.Latest(guestId); err == nil && time.Since(latest.Created) < tokenResendGap {
+		return "", newError(http.StatusTooManyRequests, "an email was just sent, please wait before asking for another")
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if _, err := s.pg.GuestToken.Prune(time.Now()); err != nil {
+		s.l.Errorw("could not prune guest tokens", zap.Error(err))
+	}
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	ttl := loginTokenTTL
+	if purpose != dao.TokenLogin {
+		ttl = verifyTokenTTL
+	}
+	now := time.Now()
+	t := dao.GuestToken{Hash: hashToken(token), GuestId: guestId, Purpose: purpose, Email: email, Created: now,
+		Expires: now.Add(ttl)}
+	if err := s.pg.GuestToken.Add(&t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// useGuestToken consumes token and returns the guest it was issued for
+func (s *mserver) useGuestToken(token string) (*dao.GuestToken, error) {
+	if token == "" {
+		return nil, BadRequestError("missing code")
+	}
+	t, err := s.pg.GuestToken.Use(hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, UnauthorizedError("code is invalid, expired or already used")
+	}
+	return t, err
+}
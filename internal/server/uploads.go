@@ -0,0 +1,356 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/config"
+	"github.com/msvens/mphotos/internal/ingest"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads following the tus protocol (https://tus.io/protocols/resumable-upload).
+// Supports the core protocol and the creation, termination and expiration extensions. Once an
+// upload is complete it is imported the same way as /local/upload. The offset of an upload is
+// the size of its data file so that bytes written just before a crash are never sent twice.
+// Uploads expire uploadTTL after they were last written to and are then removed
+
+const (
+	tusResumable  = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusMaxSize    = 200 << 20 //200M
+	tusOffsetType = "application/offset+octet-stream"
+	uploadsDir    = "uploads"
+	uploadTTL     = 24 * time.Hour
+)
+
+const (
+	UploadStateActive    = "ACTIVE"
+	UploadStateImported  = "IMPORTED"
+	UploadStateDuplicate = "DUPLICATE"
+	UploadStateFailed    = "FAILED"
+)
+
+type Upload struct {
+	Id       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Created  time.Time         `json:"created"`
+	Expires  time.Time         `json:"expires"`
+	State    string            `json:"state"`
+	PhotoId  uuid.UUID         `json:"photoId"`
+	Error    string            `json:"error,omitempty"`
+}
+
+var uploadLocks sync.Map
+
+func uploadLock(id string) *sync.Mutex {
+	l, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return l.(*sync.Mutex)
+}
+
+func uploadPath(id string, ext string) string {
+	return config.ServicePath(filepath.Join(uploadsDir, id+ext))
+}
+
+func readUpload(id string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, NotFoundError("upload not found")
+	}
+	b, err := os.ReadFile(uploadPath(id, ".json"))
+	if os.IsNotExist(err) {
+		return nil, NotFoundError("upload not found")
+	} else if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err = json.Unmarshal(b, &u); err != nil {
+		return nil, err
+	}
+	if u.State == UploadStateActive && u.expired(time.Now()) {
+		return nil, NotFoundError("upload has expired")
+	}
+	return &u, nil
+}
+
+func (u *Upload) expired(now time.Time) bool {
+	expires := u.Expires
+	if expires.IsZero() {
+		expires = u.Created.Add(uploadTTL)
+	}
+	return now.After(expires)
+}
+
+// syncOffset sets the offset of an active upload to the size of its data file
+func syncOffset(u *Upload) error {
+	if u.State != UploadStateActive {
+		return nil
+	}
+	fi, err := os.Stat(uploadPath(u.Id, ".bin"))
+	if err != nil {
+		return err
+	}
+	u.Offset = fi.Size()
+	return nil
+}
+
+func setExpires(w http.ResponseWriter, u *Upload) {
+	if u.State == UploadStateActive {
+		w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+// pruneUploads removes uploads that expired before now
+func (s *mserver) pruneUploads(now time.Time) {
+	files, err := filepath.Glob(uploadPath("*", ".json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		lock := uploadLock(id)
+		lock.Lock()
+		b, err := os.ReadFile(file)
+		var u Upload
+		if err == nil && json.Unmarshal(b, &u) == nil && u.expired(now) {
+			_ = os.Remove(uploadPath(id, ".bin"))
+			if err = os.Remove(file); err != nil {
+				s.l.Errorw("could not remove expired upload", "id", id, zap.Error(err))
+			}
+			uploadLocks.Delete(id)
+		}
+		lock.Unlock()
+	}
+}
+
+func writeUpload(u *Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploadPath(u.Id, ".json"), b, 0644)
+}
+
+// parseUploadMetadata parses the Upload-Metadata header: comma separated key value
+// pairs where the value is base64 encoded
+func parseUploadMetadata(header string) (map[string]string, error) {
+	ret := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return ret, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		switch len(kv) {
+		case 1:
+			ret[kv[0]] = ""
+		case 2:
+			v, err := base64.StdEncoding.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("could not decode metadata %s", kv[0])
+			}
+			ret[kv[0]] = string(v)
+		default:
+			return nil, fmt.Errorf("malformed upload metadata")
+		}
+	}
+	return ret, nil
+}
+
+// tusHandler wraps the tus handlers and takes care of login and version checks
+func (s *mserver) tusHandler(h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusResumable)
+		if !ctxLoggedIn(r.Context()) {
+			http.Error(w, "user not logged in", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusResumable {
+			w.Header().Set("Tus-Version", tusResumable)
+			http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *mserver) handleUploadsOptions(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Tus-Version", tusResumable)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(tusMaxSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *mserver) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > tusMaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	md, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = os.MkdirAll(config.ServicePath(uploadsDir), 0744); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.pruneUploads(time.Now())
+	now := time.Now()
+	u := Upload{Id: uuid.New().String(), Length: length, Metadata: md, Created: now, Expires: now.Add(uploadTTL),
+		State: UploadStateActive}
+	f, err := os.Create(uploadPath(u.Id, ".bin"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err = writeUpload(&u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.l.Infow("created upload", "id", u.Id, "length", u.Length, "metadata", u.Metadata)
+	w.Header().Set("Location", s.prefixPath+"/local/uploads/"+u.Id)
+	setExpires(w, &u)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *mserver) handleHeadUpload(w http.ResponseWriter, r *http.Request) {
+	u, err := readUpload(Var(r, "uploadid"))
+	if err == nil {
+		err = syncOffset(u)
+	}
+	if err != nil {
+		w.WriteHeader(ResolveError(err).Code)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	setExpires(w, u)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *mserver) handlePatchUpload(w http.ResponseWriter, r *http.Request) {
+	id := Var(r, "uploadid")
+	if r.Header.Get(contentType) != tusOffsetType {
+		http.Error(w, "expected content type "+tusOffsetType, http.StatusUnsupportedMediaType)
+		return
+	}
+	lock := uploadLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	u, err := readUpload(id)
+	if err == nil {
+		err = syncOffset(u)
+	}
+	if err != nil {
+		e := ResolveError(err)
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != u.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if u.State != UploadStateActive {
+		http.Error(w, "upload already completed", http.StatusForbidden)
+		return
+	}
+	f, err := os.OpenFile(uploadPath(id, ".bin"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	//a broken connection still leaves us with the bytes that made it
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, u.Length-u.Offset))
+	f.Close()
+	u.Offset += n
+	u.Expires = time.Now().Add(uploadTTL)
+	if u.Offset == u.Length {
+		s.completeUpload(u)
+	}
+	if err = writeUpload(u); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if copyErr != nil {
+		s.l.Infow("upload interrupted", "id", id, "offset", u.Offset, zap.Error(copyErr))
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	setExpires(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeUpload imports the uploaded file and removes the data file. The outcome is
+// recorded in the upload and can be retrieved with GET /local/uploads/{uploadid}
+func (s *mserver) completeUpload(u *Upload) {
+	sourceId := u.Metadata["sourceId"]
+	if sourceId == "" {
+		sourceId = u.Metadata["filename"]
+	}
+	sourceDate := time.Now()
+	if d, err := time.Parse(time.RFC3339, u.Metadata["sourceDate"]); err == nil {
+		sourceDate = d
+	}
+	f, err := os.Open(uploadPath(u.Id, ".bin"))
+	if err != nil {
+		u.State, u.Error = UploadStateFailed, err.Error()
+		return
+	}
+	photo, err := s.ingest.Add(ingest.NewReaderItem(f, sourceId, sourceDate))
+	f.Close()
+	switch {
+	case err == nil:
+		u.State, u.PhotoId = UploadStateImported, photo.Id
+	case errors.Is(err, ingest.ErrExists):
+		u.State = UploadStateDuplicate
+		if photo != nil {
+			u.PhotoId = photo.Id
+		}
+	default:
+		u.State, u.Error = UploadStateFailed, err.Error()
+		s.l.Errorw("could not import upload", "id", u.Id, zap.Error(err))
+	}
+	_ = os.Remove(uploadPath(u.Id, ".bin"))
+}
+
+func (s *mserver) handleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	id := Var(r, "uploadid")
+	lock := uploadLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	if _, err := readUpload(id); err != nil {
+		e := ResolveError(err)
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+	_ = os.Remove(uploadPath(id, ".bin"))
+	if err := os.Remove(uploadPath(id, ".json")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	uploadLocks.Delete(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *mserver) handleUploadStatus(r *http.Request) (interface{}, error) {
+	return readUpload(Var(r, "uploadid"))
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newUploadServer(t *testing.T) (*mserver, http.Handler) {
+	viper.Set("service.root", t.TempDir())
+	if err := os.MkdirAll(uploadPath("", ""), 0744); err != nil {
+		t.Fatal(err)
+	}
+	s := &mserver{l: zap.NewNop().Sugar(), prefixPath: "/api"}
+	r := mux.NewRouter()
+	r.Path("/api/local/uploads").Methods("OPTIONS").HandlerFunc(s.tusHandler(s.handleUploadsOptions))
+	r.Path("/api/local/uploads").Methods("POST").HandlerFunc(s.tusHandler(s.handleCreateUpload))
+	r.Path("/api/local/uploads/{uploadid}").Methods("HEAD").HandlerFunc(s.tusHandler(s.handleHeadUpload))
+	r.Path("/api/local/uploads/{uploadid}").Methods("PATCH").HandlerFunc(s.tusHandler(s.handlePatchUpload))
+	r.Path("/api/local/uploads/{uploadid}").Methods("DELETE").HandlerFunc(s.tusHandler(s.handleDeleteUpload))
+	return s, r
+}
+
+func tusRequest(h http.Handler, method, url string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusResumable)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func createUpload(t *testing.T, h http.Handler, length int) string {
+	md := "filename " + base64.StdEncoding.EncodeToString([]byte("photo.jpg"))
+	w := tusRequest(h, "POST", "/api/local/uploads", nil, "Upload-Length", strconv.Itoa(length), "Upload-Metadata", md)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := time.Parse(http.TimeFormat, w.Header().Get("Upload-Expires")); err != nil {
+		t.Errorf("expected Upload-Expires got %q", w.Header().Get("Upload-Expires"))
+	}
+	return w.Header().Get("Location")
+}
+
+func TestUploadProtocol(t *testing.T) {
+	_, h := newUploadServer(t)
+
+	if w := tusRequest(h, "OPTIONS", "/api/local/uploads", nil); w.Code != http.StatusNoContent ||
+		w.Header().Get("Tus-Extension") != tusExtensions {
+		t.Errorf("unexpected options response %d %v", w.Code, w.Header())
+	}
+	if w := tusRequest(h, "POST", "/api/local/uploads", nil, "Upload-Length", strconv.Itoa(tusMaxSize+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 got %d", w.Code)
+	}
+	noVersion := httptest.NewRecorder()
+	h.ServeHTTP(noVersion, httptest.NewRequest("POST", "/api/local/uploads", nil))
+	if noVersion.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable got %d", noVersion.Code)
+	}
+
+	loc := createUpload(t, h, 10)
+	if w := tusRequest(h, "HEAD", loc, nil); w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("expected offset 0 got %d %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	w := tusRequest(h, "PATCH", loc, []byte("hello"), "Content-Type", tusOffsetType, "Upload-Offset", "0")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("expected offset 5 got %d %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := tusRequest(h, "PATCH", loc, []byte("world"), "Content-Type", tusOffsetType, "Upload-Offset", "2"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for wrong offset got %d", w.Code)
+	}
+	if w := tusRequest(h, "PATCH", loc, []byte("world"), "Upload-Offset", "5"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 got %d", w.Code)
+	}
+	if w := tusRequest(h, "DELETE", loc, nil); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 got %d", w.Code)
+	}
+	if w := tusRequest(h, "HEAD", loc, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete got %d", w.Code)
+	}
+}
+
+// Bytes appended to the data file without the upload being updated, e.g. because the server
+// crashed in between, must count towards the offset
+func TestUploadOffsetFollowsData(t *testing.T) {
+	_, h := newUploadServer(t)
+	loc := createUpload(t, h, 10)
+	id := loc[len("/api/local/uploads/"):]
+	f, err := os.OpenFile(uploadPath(id, ".bin"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("hel"))
+	f.Close()
+
+	if w := tusRequest(h, "HEAD", loc, nil); w.Header().Get("Upload-Offset") != "3" {
+		t.Errorf("expected offset 3 got %s", w.Header().Get("Upload-Offset"))
+	}
+	if w := tusRequest(h, "PATCH", loc, []byte("lo"), "Content-Type", tusOffsetType, "Upload-Offset", "0"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for stale offset got %d", w.Code)
+	}
+	if w := tusRequest(h, "PATCH", loc, []byte("lo"), "Content-Type", tusOffsetType, "Upload-Offset", "3"); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 got %d", w.Code)
+	}
+	if b, _ := os.ReadFile(uploadPath(id, ".bin")); string(b) != "hello" {
+		t.Errorf("expected hello got %s", b)
+	}
+}
+
+func TestUploadExpiry(t *testing.T) {
+	s, h := newUploadServer(t)
+	loc := createUpload(t, h, 10)
+	id := loc[len("/api/local/uploads/"):]
+
+	s.pruneUploads(time.Now())
+	if _, err := os.Stat(uploadPath(id, ".bin")); err != nil {
+		t.Errorf("expected upload to be kept: %v", err)
+	}
+	if _, err := readUpload(id); err != nil {
+		t.Errorf("expected active upload: %v", err)
+	}
+	later := time.Now().Add(uploadTTL + time.Minute)
+	s.pruneUploads(later)
+	for _, ext := range []string{".bin", ".json"} {
+		if _, err := os.Stat(uploadPath(id, ext)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed: %v", ext, err)
+		}
+	}
+	if w := tusRequest(h, "HEAD", loc, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for pruned upload got %d", w.Code)
+	}
+}
@@ -11,6 +11,7 @@ func (s *mserver) handleUser(r *http.Request, loggedIn bool) (interface{}, error
 		if !loggedIn {
 			u.DriveFolderId = ""
 			u.DriveFolderName = ""
+			u.Config = publicConfig(u.Config)
 		}
 		return u, nil
 	} else {
@@ -18,14 +19,74 @@ func (s *mserver) handleUser(r *http.Request, loggedIn bool) (interface{}, error
 	}
 }
 
-func (s *mserver) handleUserConfig(_ http.ResponseWriter, r *http.Request) (interface{}, error) {
-	if u, err := s.pg.User.Get(); err == nil {
-		var conf map[string]interface{}
-		if err := json.Unmarshal([]byte(u.Config), &conf); err != nil {
-			return nil, err
-		} else {
-			return &conf, nil
+// privateConfigKeys are user config keys that are only visible to the owner
+var privateConfigKeys = []string{notificationsKey, moderationKey}
+
+// userConfig returns the user config as a map
+func (s *mserver) userConfig() (*dao.User, map[string]interface{}, error) {
+	u, err := s.pg.User.Get()
+	if err != nil {
+		return nil, nil, err
+	}
+	conf := map[string]interface{}{}
+	if u.Config != "" {
+		if err = json.Unmarshal([]byte(u.Config), &conf); err != nil {
+			return nil, nil, err
 		}
+	}
+	return u, conf, nil
+}
+
+// configValue decodes the user config value of key into dst. dst is left as is if key is not set
+func (s *mserver) configValue(key string, dst interface{}) error {
+	_, conf, err := s.userConfig()
+	if err != nil {
+		return err
+	}
+	if v, found := conf[key]; found {
+		b, _ := json.Marshal(v)
+		return json.Unmarshal(b, dst)
+	}
+	return nil
+}
+
+func (s *mserver) setConfigValue(key string, v interface{}) error {
+	u, conf, err := s.userConfig()
+	if err != nil {
+		return err
+	}
+	conf[key] = v
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	u.Config = string(b)
+	_, err = s.pg.User.Update(u)
+	return err
+}
+
+// publicConfig returns config without the private keys
+func publicConfig(config string) string {
+	var conf map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &conf); err != nil {
+		return config
+	}
+	for _, key := range privateConfigKeys {
+		delete(conf, key)
+	}
+	b, _ := json.Marshal(conf)
+	return string(b)
+}
+
+// handleUserConfig returns the user config. Private keys are only returned to the owner
+func (s *mserver) handleUserConfig(r *http.Request, loggedIn bool) (interface{}, error) {
+	if _, conf, err := s.userConfig(); err == nil {
+		if !loggedIn {
+			for _, key := range privateConfigKeys {
+				delete(conf, key)
+			}
+		}
+		return &conf, nil
 	} else {
 		return nil, err
 	}
@@ -56,6 +117,14 @@ func (s *mserver) handleUpdateDriveUser(r *http.Request) (interface{}, error) {
 		if user, err := s.pg.User.Get(); err != nil {
 			return nil, InternalError(err.Error())
 		} else {
+			if !s.pg.Drive.HasFolder(f.Id) {
+				//the user drive folder is kept as a (non recursive) drive source
+				if _, err = s.pg.Drive.Add(&dao.DriveSource{FolderId: f.Id, FolderName: f.Name}); err != nil {
+					return nil, err
+				}
+				//the next sync has to list the new folder
+				user.DrivePageToken = ""
+			}
 			user.DriveFolderId = f.Id
 			user.DriveFolderName = f.Name
 			return s.pg.User.Update(user)
@@ -79,20 +148,28 @@ func (s *mserver) handleUpdateUser(r *http.Request) (interface{}, error) {
 	}
 }
 
+// handleUpdateConfig replaces the user config. Private keys are kept unless given
 func (s *mserver) handleUpdateConfig(r *http.Request) (interface{}, error) {
 	var c map[string]interface{}
 
 	if err := decodeRequest(r, &c); err != nil {
 		return nil, err
 	}
+	user, conf, err := s.userConfig()
+	if err != nil {
+		return nil, InternalError(err.Error())
+	}
+	for _, key := range privateConfigKeys {
+		if v, found := conf[key]; found {
+			if _, found = c[key]; !found {
+				c[key] = v
+			}
+		}
+	}
 	if b, err := json.Marshal(c); err != nil {
 		return nil, err
 	} else {
-		if user, err := s.pg.User.Get(); err != nil {
-			return nil, InternalError(err.Error())
-		} else {
-			user.Config = string(b)
-			return s.pg.User.Update(user)
-		}
+		user.Config = string(b)
+		return s.pg.User.Update(user)
 	}
 }
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WebhookRequest adds or updates a webhook. Events are event types (e.g. photo.added) or topics
+// (e.g. album), all events if empty. A secret is generated if none is given
+type WebhookRequest struct {
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// NewWebhook is returned when a webhook is added, the only time that its secret is returned
+type NewWebhook struct {
+	*dao.Webhook
+	Secret string `json:"secret"`
+}
+
+func (wr *WebhookRequest) apply(hook *dao.Webhook) error {
+	if wr.Url != "" {
+		if u, err := url.Parse(wr.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return BadRequestError("Webhook url must be an absolute http(s) url")
+		}
+		hook.Url = wr.Url
+	}
+	if hook.Url == "" {
+		return BadRequestError("Webhook url is required")
+	}
+	if wr.Events != nil {
+		for _, e := range wr.Events {
+			if !events.IsTopic(strings.SplitN(e, ".", 2)[0]) {
+				return BadRequestError("Unknown event: " + e)
+			}
+		}
+		hook.Events = strings.Join(wr.Events, ",")
+	}
+	if wr.Secret != "" {
+		hook.Secret = wr.Secret
+	}
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	if wr.Active != nil {
+		hook.Active = *wr.Active
+	}
+	return nil
+}
+
+func (s *mserver) handleWebhooks(_ *http.Request) (interface{}, error) {
+	return s.pg.Webhook.List()
+}
+
+func (s *mserver) handleAddWebhook(r *http.Request) (interface{}, error) {
+	var par WebhookRequest
+	if err := decodeRequest(r, &par); err != nil {
+		return nil, err
+	}
+	hook := dao.Webhook{Active: true}
+	if err := par.apply(&hook); err != nil {
+		return nil, err
+	}
+	added, err := s.pg.Webhook.Add(&hook)
+	if err != nil {
+		return nil, err
+	}
+	return &NewWebhook{added, added.Secret}, nil
+}
+
+func (s *mserver) handleUpdateWebhook(r *http.Request) (interface{}, error) {
+	hook, err := s.webhook(r)
+	if err != nil {
+		return nil, err
+	}
+	var par WebhookRequest
+	if err = decodeRequest(r, &par); err != nil {
+		return nil, err
+	}
+	if err = par.apply(hook); err != nil {
+		return nil, err
+	}
+	return s.pg.Webhook.Update(hook)
+}
+
+func (s *mserver) handleDeleteWebhook(r *http.Request) (interface{}, error) {
+	hook, err := s.webhook(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.pg.Webhook.Delete(hook.Id); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// handleWebhookDeliveries returns the delivery log of a webhook, most recent first
+func (s *mserver) handleWebhookDeliveries(r *http.Request) (interface{}, error) {
+	hook, err := s.webhook(r)
+	if err != nil {
+		return nil, err
+	}
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			return nil, BadRequestError("Could not parse limit")
+		}
+	}
+	return s.pg.Webhook.Deliveries(hook.Id, limit)
+}
+
+func (s *mserver) webhook(r *http.Request) (*dao.Webhook, error) {
+	var id uuid.UUID
+	if err := uid(r, "hookid", &id); err != nil {
+		return nil, err
+	}
+	hook, err := s.pg.Webhook.Get(id)
+	if err != nil {
+		return nil, NotFoundError("Webhook not found")
+	}
+	return hook, nil
+}
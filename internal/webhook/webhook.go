@@ -0,0 +1,236 @@
+// Package webhook delivers library events to registered webhooks. Each delivery is the JSON
+// encoded event POSTed to the webhook url and signed with the webhook secret. Failed deliveries
+// are retried with exponential backoff and every attempt is recorded in the delivery log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers sent with each delivery
+const (
+	HeaderEvent     = "X-Mphotos-Event"
+	HeaderDelivery  = "X-Mphotos-Delivery"
+	HeaderSignature = "X-Mphotos-Signature"
+)
+
+// deliveryRetention is how long finished deliveries are kept in the log
+const deliveryRetention = 30 * 24 * time.Hour
+
+const requestTimeout = 10 * time.Second
+
+// RetryPolicy controls how often and how quickly a failed delivery is retried
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetry = RetryPolicy{MaxAttempts: 6, BaseDelay: 10 * time.Second, MaxDelay: 30 * time.Minute}
+
+// Delay returns how long to wait after attempt number attempt (starting at 1) has failed
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Sign returns the signature of payload, sha256= followed by the hex encoded HMAC-SHA256 of
+// payload using secret as key
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches returns true if hook is subscribed to events of eventType
+func Matches(hook *dao.Webhook, eventType string) bool {
+	if hook.Events == "" {
+		return true
+	}
+	topic := strings.SplitN(eventType, ".", 2)[0]
+	for _, e := range strings.Split(hook.Events, ",") {
+		if e = strings.TrimSpace(e); e == eventType || e == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher subscribes to a broker and delivers events to all active webhooks that match them
+type Dispatcher struct {
+	Client *http.Client
+	Retry  RetryPolicy
+	hooks  dao.WebhookDAO
+	l      *zap.SugaredLogger
+	sub    *events.Subscription
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(hooks dao.WebhookDAO, l *zap.SugaredLogger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{Client: &http.Client{Timeout: requestTimeout}, Retry: DefaultRetry, hooks: hooks, l: l,
+		ctx: ctx, cancel: cancel}
+}
+
+// Start resumes deliveries that were pending when the dispatcher was last closed and starts
+// delivering events published on broker. Events are queued rather than dropped when they are
+// published faster than they are dispatched
+func (d *Dispatcher) Start(broker *events.Broker) error {
+	if _, err := d.hooks.PruneDeliveries(time.Now().Add(-deliveryRetention)); err != nil {
+		d.l.Errorw("could not prune webhook deliveries", zap.Error(err))
+	}
+	pending, err := d.hooks.Pending()
+	if err != nil {
+		return err
+	}
+	for _, delivery := range pending {
+		d.resume(delivery)
+	}
+	d.sub = broker.SubscribeQueued(nil, true)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for e := range d.sub.C {
+			d.dispatch(e)
+		}
+	}()
+	return nil
+}
+
+// Close stops listening for events and waits for ongoing attempts to finish. Queued events
+// are still added to the delivery log, and deliveries that are waiting for a retry stay
+// pending and are resumed by the next Start
+func (d *Dispatcher) Close() {
+	d.cancel()
+	if d.sub != nil {
+		d.sub.Close()
+	}
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) dispatch(e *events.Event) {
+	hooks, err := d.hooks.List()
+	if err != nil {
+		d.l.Errorw("could not list webhooks", zap.Error(err))
+		return
+	}
+	var payload []byte
+	for _, hook := range hooks {
+		if !hook.Active || !Matches(hook, e.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(e); err != nil {
+				d.l.Errorw("could not encode event", "type", e.Type, zap.Error(err))
+				return
+			}
+		}
+		delivery := dao.WebhookDelivery{WebhookId: hook.Id, EventType: e.Type, Payload: string(payload),
+			State: dao.DeliveryPending, Created: time.Now()}
+		if err = d.hooks.AddDelivery(&delivery); err != nil {
+			d.l.Errorw("could not add webhook delivery", "webhook", hook.Id, zap.Error(err))
+			continue
+		}
+		d.deliver(hook, &delivery)
+	}
+}
+
+func (d *Dispatcher) resume(delivery *dao.WebhookDelivery) {
+	hook, err := d.hooks.Get(delivery.WebhookId)
+	if err != nil || !hook.Active {
+		delivery.State, delivery.Error = dao.DeliveryFailed, "webhook removed or inactive"
+		d.update(delivery)
+		return
+	}
+	d.deliver(hook, delivery)
+}
+
+// deliver makes the remaining attempts of delivery in the background
+func (d *Dispatcher) deliver(hook *dao.Webhook, delivery *dao.WebhookDelivery) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for delivery.Attempts < d.Retry.MaxAttempts {
+			if delivery.Attempts > 0 {
+				wait := time.Until(delivery.LastAttempt.Add(d.Retry.Delay(delivery.Attempts)))
+				select {
+				case <-d.ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+			err := d.attempt(hook, delivery)
+			if err != nil && d.ctx.Err() != nil {
+				//interrupted by Close, the attempt is made again on the next Start
+				delivery.Attempts--
+				return
+			}
+			switch {
+			case err == nil:
+				delivery.State, delivery.Error = dao.DeliveryDelivered, ""
+			case delivery.Attempts >= d.Retry.MaxAttempts:
+				delivery.State, delivery.Error = dao.DeliveryFailed, err.Error()
+			default:
+				delivery.Error = err.Error()
+			}
+			d.update(delivery)
+			if err == nil {
+				return
+			}
+		}
+	}()
+}
+
+// attempt posts delivery to hook once. Any response other than 2xx is an error
+func (d *Dispatcher) attempt(hook *dao.Webhook, delivery *dao.WebhookDelivery) error {
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+	delivery.StatusCode = 0
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.Id.String())
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, []byte(delivery.Payload)))
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) update(delivery *dao.WebhookDelivery) {
+	if err := d.hooks.UpdateDelivery(delivery); err != nil {
+		d.l.Errorw("could not update webhook delivery", "delivery", delivery.Id, zap.Error(err))
+	}
+}
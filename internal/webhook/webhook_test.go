@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"github.com/google/uuid"
+	"github.com/msvens/mphotos/internal/dao"
+	"github.com/msvens/mphotos/internal/events"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in memory dao.WebhookDAO
+type memStore struct {
+	mu         sync.Mutex
+	hooks      []*dao.Webhook
+	deliveries []dao.WebhookDelivery
+}
+
+func (m *memStore) Add(hook *dao.Webhook) (*dao.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook.Id = uuid.New()
+	m.hooks = append(m.hooks, hook)
+	return hook, nil
+}
+
+func (m *memStore) AddDelivery(delivery *dao.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery.Id = uuid.New()
+	m.deliveries = append(m.deliveries, *delivery)
+	return nil
+}
+
+func (m *memStore) Delete(_ uuid.UUID) error {
+	return nil
+}
+
+func (m *memStore) Deliveries(_ uuid.UUID, _ int) ([]*dao.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ret []*dao.WebhookDelivery
+	for i := range m.deliveries {
+		d := m.deliveries[i]
+		ret = append(ret, &d)
+	}
+	return ret, nil
+}
+
+func (m *memStore) Get(id uuid.UUID) (*dao.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range m.hooks {
+		if h.Id == id {
+			return h, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+func (m *memStore) List() ([]*dao.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hooks, nil
+}
+
+func (m *memStore) Pending() ([]*dao.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m *memStore) PruneDeliveries(_ time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *memStore) Update(hook *dao.Webhook) (*dao.Webhook, error) {
+	return hook, nil
+}
+
+func (m *memStore) UpdateDelivery(delivery *dao.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.deliveries {
+		if m.deliveries[i].Id == delivery.Id {
+			m.deliveries[i] = *delivery
+		}
+	}
+	return nil
+}
+
+func TestMatches(t *testing.T) {
+	hook := dao.Webhook{Events: "photo, album.added"}
+	cases := map[string]bool{
+		events.PhotoAdded:   true,
+		events.PhotoDeleted: true,
+		events.AlbumAdded:   true,
+		events.AlbumDeleted: false,
+		events.CommentAdded: false,
+	}
+	for typ, exp := range cases {
+		if act := Matches(&hook, typ); act != exp {
+			t.Errorf("%s: expected %v got %v", typ, exp, act)
+		}
+	}
+	if !Matches(&dao.Webhook{}, events.JobUpdated) {
+		t.Errorf("expected empty events to match all")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	cases := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 8: 5 * time.Second}
+	for attempt, exp := range cases {
+		if act := p.Delay(attempt); act != exp {
+			t.Errorf("attempt %d: expected %v got %v", attempt, exp, act)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if sig := r.Header.Get(HeaderSignature); sig != Sign("secret", body) {
+			t.Errorf("wrong signature %s", sig)
+		}
+		received = append(received, r.Header.Get(HeaderEvent))
+	}))
+	defer srv.Close()
+
+	store := &memStore{}
+	hook, _ := store.Add(&dao.Webhook{Url: srv.URL, Secret: "secret", Events: "photo", Active: true})
+	_, _ = store.Add(&dao.Webhook{Url: srv.URL, Secret: "secret", Active: false})
+
+	broker := events.NewBroker()
+	d := NewDispatcher(store, zap.NewNop().Sugar())
+	d.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	if err := d.Start(broker); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish(events.AlbumAdded, false, "album")
+	broker.Publish(events.PhotoAdded, false, "photo")
+
+	var deliveries []*dao.WebhookDelivery
+	for i := 0; i < 100; i++ {
+		deliveries, _ = store.Deliveries(hook.Id, 10)
+		if len(deliveries) == 1 && deliveries[0].State != dao.DeliveryPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Close()
+
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery got %d", len(deliveries))
+	}
+	if del := deliveries[0]; del.State != dao.DeliveryDelivered || del.Attempts != 2 || del.StatusCode != 200 {
+		t.Errorf("expected delivered after 2 attempts got %s %d %d", del.State, del.Attempts, del.StatusCode)
+	}
+	if len(received) != 1 || received[0] != events.PhotoAdded {
+		t.Errorf("expected photo.added got %v", received)
+	}
+}
+
+func TestNoDroppedEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	store := &memStore{}
+	_, _ = store.Add(&dao.Webhook{Url: srv.URL, Secret: "secret", Active: true})
+
+	broker := events.NewBroker()
+	d := NewDispatcher(store, zap.NewNop().Sugar())
+	if err := d.Start(broker); err != nil {
+		t.Fatal(err)
+	}
+	n := 500
+	for i := 0; i < n; i++ {
+		broker.Publish(events.PhotoUpdated, false, i)
+	}
+	d.Close()
+	if deliveries, _ := store.Deliveries(uuid.Nil, 0); len(deliveries) != n {
+		t.Errorf("expected %d deliveries got %d", n, len(deliveries))
+	}
+}